@@ -0,0 +1,209 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+)
+
+/* AttachmentLimits bounds what a multipart request may upload. Zero fields take the
+ * value from DefaultAttachmentLimits. The limits apply to the decoded size of each
+ * part, so compressed parts can't be used to sneak past them. */
+type AttachmentLimits struct {
+	// MaxPartSize caps each part, including the data part, and the body of a
+	// request that isn't multipart.
+	MaxPartSize int64
+
+	// MaxTotalSize caps all the parts of a request together.
+	MaxTotalSize int64
+
+	// MemoryThreshold is the size up to which parts are kept in memory; larger parts
+	// are spooled to a temporary file.
+	MemoryThreshold int64
+
+	// SpoolDir is where larger parts are spooled. Empty means os.TempDir(); ideally
+	// it's a swap-backed tmpfs.
+	SpoolDir string
+}
+
+var DefaultAttachmentLimits = AttachmentLimits{
+	MaxPartSize:     32 << 20,
+	MaxTotalSize:    64 << 20,
+	MemoryThreshold: 64 << 10,
+}
+
+func (limits AttachmentLimits) withDefaults() AttachmentLimits {
+	if limits.MaxPartSize <= 0 {
+		limits.MaxPartSize = DefaultAttachmentLimits.MaxPartSize
+	}
+
+	if limits.MaxTotalSize <= 0 {
+		limits.MaxTotalSize = DefaultAttachmentLimits.MaxTotalSize
+	}
+
+	if limits.MemoryThreshold <= 0 {
+		limits.MemoryThreshold = DefaultAttachmentLimits.MemoryThreshold
+	}
+
+	if limits.SpoolDir == "" {
+		limits.SpoolDir = DefaultAttachmentLimits.SpoolDir
+	}
+
+	return limits
+}
+
+/* memoryAttachment is a part small enough to be kept in memory. */
+type memoryAttachment struct {
+	*bytes.Reader
+}
+
+func (*memoryAttachment) Close() error {
+	return nil
+}
+
+/* spooledPart is an attachment as read off the wire, along with the first bytes
+ * of it for content sniffing. */
+type spooledPart struct {
+	file multipart.File
+	head []byte
+	size int64
+}
+
+/* spoolPart reads a part into memory or, if it's larger than the memory threshold,
+ * into a temporary file. remaining is what's left of the total allowance for the
+ * request. */
+func spoolPart(r io.Reader, contentId string, limits AttachmentLimits, remaining int64) (*spooledPart, error) {
+	limit, tooLarge := limits.MaxPartSize, ErrorAttachmentTooLarge(contentId, limits.MaxPartSize)
+	if remaining < limit {
+		limit, tooLarge = remaining, ErrorAttachmentTooLarge(contentId, limits.MaxTotalSize)
+	}
+
+	/* Read one byte past the limit, so an oversized part is noticed rather than
+	 * silently truncated */
+	limited := io.LimitReader(r, limit+1)
+
+	buf := make([]byte, limits.MemoryThreshold+1)
+	n, er := io.ReadFull(limited, buf)
+
+	if er == io.EOF || er == io.ErrUnexpectedEOF {
+		if int64(n) > limit {
+			return nil, tooLarge
+		}
+
+		return &spooledPart{
+			file: &memoryAttachment{bytes.NewReader(buf[:n])},
+			head: sniffHead(buf[:n]),
+			size: int64(n),
+		}, nil
+
+	} else if er != nil {
+		return nil, er
+	}
+
+	tmpFile, er := ioutil.TempFile(limits.SpoolDir, "macrobooru-")
+	if er != nil {
+		return nil, er
+	}
+
+	part := &spooledPart{
+		file: tmpFile,
+		head: sniffHead(buf[:n]),
+	}
+
+	if part.size, er = io.Copy(tmpFile, io.MultiReader(bytes.NewReader(buf[:n]), limited)); er == nil && part.size > limit {
+		er = tooLarge
+	}
+
+	if er == nil {
+		_, er = tmpFile.Seek(0, os.SEEK_SET)
+	}
+
+	if er != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return nil, er
+	}
+
+	return part, nil
+}
+
+/* http.DetectContentType only ever looks at this much */
+const sniffLength = 512
+
+func sniffHead(bs []byte) []byte {
+	if len(bs) > sniffLength {
+		bs = bs[:sniffLength]
+	}
+
+	return append([]byte(nil), bs...)
+}
+
+/* mimeAliases normalizes the names clients commonly use for a type to the one
+ * http.DetectContentType reports. */
+var mimeAliases = map[string]string{
+	"image/jpg":                    "image/jpeg",
+	"image/pjpeg":                  "image/jpeg",
+	"image/x-ms-bmp":               "image/bmp",
+	"image/x-icon":                 "image/vnd.microsoft.icon",
+	"audio/mp3":                    "audio/mpeg",
+	"audio/wav":                    "audio/wave",
+	"audio/x-wav":                  "audio/wave",
+	"audio/ogg":                    "application/ogg",
+	"video/ogg":                    "application/ogg",
+	"application/gzip":             "application/x-gzip",
+	"application/x-zip-compressed": "application/zip",
+}
+
+/* checkContentType sniffs an attachment and compares the result against the type
+ * the client declared, returning the type to record for it. Content with a
+ * recognizable signature must be declared as what it is, and text can't be
+ * declared as a binary format. Types the sniffer knows nothing about are taken on
+ * trust. */
+func checkContentType(declared string, head []byte) (string, error) {
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+
+	if declared == "" {
+		return sniffed, nil
+	}
+
+	declaredType, _, er := mime.ParseMediaType(declared)
+	if er != nil {
+		return "", ErrorInvalidFileType()
+	}
+
+	if alias, ok := mimeAliases[declaredType]; ok {
+		declaredType = alias
+	}
+
+	switch {
+	case declaredType == sniffed, declaredType == "application/octet-stream":
+		return declared, nil
+
+	case sniffed != "application/octet-stream" && !strings.HasPrefix(sniffed, "text/"):
+		return "", ErrorInvalidFileType()
+
+	case strings.HasPrefix(sniffed, "text/") && binaryMediaType(declaredType):
+		return "", ErrorInvalidFileType()
+	}
+
+	return declared, nil
+}
+
+func binaryMediaType(mediaType string) bool {
+	if mediaType == "image/svg+xml" {
+		return false
+	}
+
+	for _, prefix := range []string{"image/", "audio/", "video/"} {
+		if strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+
+	return mediaType == "application/pdf" || mediaType == "application/zip"
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"testing"
+)
+
+type testPart struct {
+	contentId   string
+	contentType string
+	body        []byte
+}
+
+func unwrapTestParts(t *testing.T, limits AttachmentLimits, parts ...testPart) (*RequestWrapper, error) {
+	buf := &bytes.Buffer{}
+	writer := multipart.NewWriter(buf)
+
+	data := testPart{"data", "application/json", []byte(`{ "operation" : "test_echo", "data" : { "value" : "hello" } }`)}
+
+	for _, part := range append([]testPart{data}, parts...) {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-ID", part.contentId)
+		if part.contentType != "" {
+			header.Set("Content-Type", part.contentType)
+		}
+
+		partWriter, er := writer.CreatePart(header)
+		if er != nil {
+			t.Fatal(er)
+		}
+
+		partWriter.Write(part.body)
+	}
+
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", "/v2/api", buf)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	return UnwrapHttpRequestWithLimits(req, limits)
+}
+
+var testGIF = append([]byte("GIF89a"), bytes.Repeat([]byte{0}, 100)...)
+
+func TestAttachmentSpooling(t *testing.T) {
+	spoolDir, er := ioutil.TempDir("", "spool")
+	if er != nil {
+		t.Fatal(er)
+	}
+	defer os.RemoveAll(spoolDir)
+
+	large := append([]byte("GIF89a"), bytes.Repeat([]byte{0}, 4096)...)
+	limits := AttachmentLimits{MemoryThreshold: 1024, SpoolDir: spoolDir}
+
+	wrapper, er := unwrapTestParts(t, limits,
+		testPart{"small", "image/gif", testGIF},
+		testPart{"large", "image/gif", large},
+	)
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	if _, ok := wrapper.Attachments["small"].(*memoryAttachment); !ok {
+		t.Errorf("small part was spooled to %T", wrapper.Attachments["small"])
+	}
+
+	file, ok := wrapper.Attachments["large"].(*os.File)
+	if !ok {
+		t.Fatalf("large part was kept in %T", wrapper.Attachments["large"])
+	}
+
+	received, _ := ioutil.ReadAll(file)
+	if !bytes.Equal(received, large) {
+		t.Errorf("large part did not survive spooling")
+	}
+
+	if entries, _ := ioutil.ReadDir(spoolDir); len(entries) != 1 {
+		t.Errorf("expected the large part in the spool directory, found %d files", len(entries))
+	}
+
+	wrapper.Close()
+
+	if entries, _ := ioutil.ReadDir(spoolDir); len(entries) != 0 {
+		t.Errorf("spooled files were not removed")
+	}
+}
+
+func TestAttachmentLimits(t *testing.T) {
+	big := append([]byte("GIF89a"), bytes.Repeat([]byte{0}, 2048)...)
+
+	_, er := unwrapTestParts(t, AttachmentLimits{MaxPartSize: 1024}, testPart{"big", "image/gif", big})
+	if !IsAttachmentTooLarge(er) {
+		t.Errorf("expected the part limit to be enforced, got %v", er)
+	}
+
+	/* Each part fits, but together they don't */
+	_, er = unwrapTestParts(t, AttachmentLimits{MaxPartSize: 4096, MaxTotalSize: 3000, MemoryThreshold: 512},
+		testPart{"one", "image/gif", big},
+		testPart{"two", "image/gif", big},
+	)
+	if !IsAttachmentTooLarge(er) {
+		t.Errorf("expected the total limit to be enforced, got %v", er)
+	}
+}
+
+func TestAttachmentSniffing(t *testing.T) {
+	cases := []struct {
+		contentType string
+		body        []byte
+		ok          bool
+		recorded    string
+	}{
+		{"image/gif", testGIF, true, "image/gif"},
+		{"", testGIF, true, "image/gif"},
+		{"application/octet-stream", testGIF, true, "application/octet-stream"},
+		{"image/png", testGIF, false, ""},
+		{"image/gif", []byte("<html><script>alert(1)</script></html>"), false, ""},
+		{"text/plain", testGIF, false, ""},
+		{"image/jpg", []byte("not a jpeg"), false, ""},
+		{"image/jpg", []byte("\xFF\xD8\xFF\xE0"), true, "image/jpg"},
+		{"application/json", []byte(`{ "a" : 1 }`), true, "application/json"},
+		{"image/svg+xml", []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`), true, "image/svg+xml"},
+	}
+
+	for _, c := range cases {
+		wrapper, er := unwrapTestParts(t, AttachmentLimits{}, testPart{"file", c.contentType, c.body})
+
+		if !c.ok {
+			if !IsInvalidFileType(er) {
+				t.Errorf("%q declared as %q: expected invalid_file_type, got %v", c.body, c.contentType, er)
+			}
+
+			continue
+		}
+
+		if er != nil {
+			t.Errorf("%q declared as %q: %s", c.body, c.contentType, er)
+			continue
+		}
+
+		if wrapper.MimeTypes["file"] != c.recorded {
+			t.Errorf("%q declared as %q: recorded %q", c.body, c.contentType, wrapper.MimeTypes["file"])
+		}
+
+		wrapper.Close()
+	}
+}
+
+func TestDuplicateContentID(t *testing.T) {
+	_, er := unwrapTestParts(t, AttachmentLimits{},
+		testPart{"file", "image/gif", testGIF},
+		testPart{"file", "image/gif", testGIF},
+	)
+	if er == nil {
+		t.Errorf("expected duplicate attachments to be rejected")
+	}
+
+	_, er = unwrapTestParts(t, AttachmentLimits{}, testPart{"data", "application/json", []byte(`{}`)})
+	if er == nil {
+		t.Errorf("expected a duplicate data part to be rejected")
+	}
+}
@@ -0,0 +1,245 @@
+package changes
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"macrobooru/models"
+)
+
+type Kind string
+
+const (
+	Created Kind = "created"
+	Updated Kind = "updated"
+)
+
+/* Event describes a committed change to a single object. */
+type Event struct {
+	Kind  Kind        `json:"kind"`
+	Model string      `json:"model"`
+	Pid   models.GUID `json:"pid"`
+
+	// Object is the object (a pointer to a model struct) as it stands after the
+	// change.
+	Object interface{} `json:"object"`
+}
+
+/* MaxBacklog is how many undelivered events a subscription holds on to. Beyond
+ * that the oldest are dropped, and the subscriber told how many it missed. */
+const MaxBacklog = 256
+
+/* Subscriptions nobody has polled or held open for this long are dropped. */
+const IdleTimeout = 5 * time.Minute
+
+/* MaxSubscriptionsPerOwner is how many live subscriptions one owner may hold. */
+const MaxSubscriptionsPerOwner = 32
+
+/* ErrTooManySubscriptions is returned by Subscribe when the owner already holds
+ * MaxSubscriptionsPerOwner subscriptions. */
+var ErrTooManySubscriptions = errors.New("too many subscriptions")
+
+/* Hub fans events out to the subscriptions whose filters match them. */
+type Hub struct {
+	lock          sync.Mutex
+	subscriptions map[string]*Subscription
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		subscriptions: make(map[string]*Subscription),
+	}
+}
+
+/* DefaultHub is the hub modify publishes to. */
+var DefaultHub = NewHub()
+
+func Publish(events ...Event) {
+	DefaultHub.Publish(events...)
+}
+
+func (hub *Hub) Publish(events ...Event) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	now := time.Now()
+
+	for id, sub := range hub.subscriptions {
+		if sub.idle(now) {
+			delete(hub.subscriptions, id)
+			continue
+		}
+
+		for _, event := range events {
+			if sub.filter(event) {
+				sub.push(event)
+			}
+		}
+	}
+}
+
+/* Subscribe registers a subscription receiving every event filter accepts. owner
+ * is recorded so that only whoever created the subscription can poll it, and so
+ * that nobody holds more than MaxSubscriptionsPerOwner at once. */
+func (hub *Hub) Subscribe(owner string, filter func(Event) bool) (*Subscription, error) {
+	bs := make([]byte, 16)
+	if _, er := rand.Read(bs); er != nil {
+		return nil, er
+	}
+
+	sub := &Subscription{
+		ID:       hex.EncodeToString(bs),
+		Owner:    owner,
+		filter:   filter,
+		notify:   make(chan struct{}, 1),
+		lastSeen: time.Now(),
+	}
+
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	held := 0
+	now := time.Now()
+
+	for id, other := range hub.subscriptions {
+		if other.idle(now) {
+			delete(hub.subscriptions, id)
+
+		} else if other.Owner == owner {
+			held += 1
+		}
+	}
+
+	if held >= MaxSubscriptionsPerOwner {
+		return nil, ErrTooManySubscriptions
+	}
+
+	hub.subscriptions[sub.ID] = sub
+	return sub, nil
+}
+
+/* Lookup returns the subscription with the given ID, or nil if it doesn't exist
+ * (or has expired). */
+func (hub *Hub) Lookup(id string) *Subscription {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	sub, ok := hub.subscriptions[id]
+	if !ok || sub.idle(time.Now()) {
+		return nil
+	}
+
+	return sub
+}
+
+func (hub *Hub) Unsubscribe(id string) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	delete(hub.subscriptions, id)
+}
+
+type Subscription struct {
+	ID    string
+	Owner string
+
+	filter func(Event) bool
+
+	lock     sync.Mutex
+	backlog  []Event
+	missed   int64
+	notify   chan struct{}
+	lastSeen time.Time
+	held     int
+}
+
+func (sub *Subscription) push(event Event) {
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+
+	if len(sub.backlog) >= MaxBacklog {
+		sub.backlog = sub.backlog[1:]
+		sub.missed += 1
+	}
+
+	sub.backlog = append(sub.backlog, event)
+
+	select {
+	case sub.notify <- struct{}{}:
+	default:
+	}
+}
+
+/* idle reports whether nobody has waited on the subscription for IdleTimeout. */
+func (sub *Subscription) idle(now time.Time) bool {
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+
+	return sub.held == 0 && now.Sub(sub.lastSeen) > IdleTimeout
+}
+
+/* Wait blocks until there are events to deliver, ctx is done, or timeout passes,
+ * then returns whatever has been delivered along with the number of events that
+ * were dropped since the last call. */
+func (sub *Subscription) Wait(ctx context.Context, timeout time.Duration) ([]Event, int64) {
+	sub.hold(1)
+	defer sub.hold(-1)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		if events, missed := sub.Take(); len(events) > 0 || missed > 0 {
+			return events, missed
+		}
+
+		select {
+		case <-sub.notify:
+		case <-timer.C:
+			return sub.Take()
+		case <-ctx.Done():
+			return sub.Take()
+		}
+	}
+}
+
+/* Notify returns a channel which receives a value whenever events are waiting to be
+ * taken. Callers streaming events (rather than polling) should hold the
+ * subscription open with Hold for as long as they're listening. */
+func (sub *Subscription) Notify() <-chan struct{} {
+	return sub.notify
+}
+
+/* Hold keeps the subscription from expiring until the returned func is called. */
+func (sub *Subscription) Hold() func() {
+	sub.hold(1)
+	return func() {
+		sub.hold(-1)
+	}
+}
+
+func (sub *Subscription) hold(delta int) {
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+
+	sub.held += delta
+	sub.lastSeen = time.Now()
+}
+
+/* Take removes and returns the events waiting for delivery, along with the number
+ * dropped since the last call. */
+func (sub *Subscription) Take() ([]Event, int64) {
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+
+	events, missed := sub.backlog, sub.missed
+	sub.backlog = nil
+	sub.missed = 0
+	sub.lastSeen = time.Now()
+
+	return events, missed
+}
@@ -0,0 +1,105 @@
+package changes
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHubFiltersEvents(t *testing.T) {
+	hub := NewHub()
+
+	sub, er := hub.Subscribe("", func(event Event) bool {
+		return event.Model == "Image"
+	})
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	hub.Publish(Event{Kind: Created, Model: "Tag"}, Event{Kind: Updated, Model: "Image"})
+
+	events, missed := sub.Wait(context.Background(), time.Second)
+	if len(events) != 1 || events[0].Kind != Updated || missed != 0 {
+		t.Fatalf("unexpected delivery %v (%d missed)", events, missed)
+	}
+
+	/* Nothing waiting, so this times out */
+	if events, _ := sub.Wait(context.Background(), 10*time.Millisecond); len(events) != 0 {
+		t.Fatalf("unexpected delivery %v", events)
+	}
+}
+
+func TestHubWakesWaiters(t *testing.T) {
+	hub := NewHub()
+	sub, _ := hub.Subscribe("", func(Event) bool { return true })
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		hub.Publish(Event{Kind: Created, Model: "Image"})
+	}()
+
+	events, _ := sub.Wait(context.Background(), 5*time.Second)
+	if len(events) != 1 || events[0].Kind != Created {
+		t.Fatalf("unexpected delivery %v", events)
+	}
+}
+
+func TestHubDropsOldestEvents(t *testing.T) {
+	hub := NewHub()
+	sub, _ := hub.Subscribe("", func(Event) bool { return true })
+
+	for i := 0; i < MaxBacklog+3; i += 1 {
+		hub.Publish(Event{Kind: Created, Model: "Image"})
+	}
+
+	events, missed := sub.Take()
+	if len(events) != MaxBacklog || missed != 3 {
+		t.Fatalf("expected %d events and 3 missed, got %d and %d", MaxBacklog, len(events), missed)
+	}
+}
+
+func TestHubUnsubscribe(t *testing.T) {
+	hub := NewHub()
+	sub, _ := hub.Subscribe("", func(Event) bool { return true })
+
+	if hub.Lookup(sub.ID) != sub {
+		t.Fatalf("subscription not found")
+	}
+
+	hub.Unsubscribe(sub.ID)
+
+	if hub.Lookup(sub.ID) != nil {
+		t.Fatalf("subscription still registered")
+	}
+}
+
+func TestHubCapsSubscriptionsPerOwner(t *testing.T) {
+	hub := NewHub()
+
+	for i := 0; i < MaxSubscriptionsPerOwner; i += 1 {
+		if _, er := hub.Subscribe("owner", func(Event) bool { return true }); er != nil {
+			t.Fatal(er)
+		}
+	}
+
+	if _, er := hub.Subscribe("owner", func(Event) bool { return true }); er != ErrTooManySubscriptions {
+		t.Fatalf("expected ErrTooManySubscriptions, got %v", er)
+	}
+
+	/* Others aren't affected */
+	if _, er := hub.Subscribe("other", func(Event) bool { return true }); er != nil {
+		t.Fatal(er)
+	}
+
+	/* Cancelling one makes room for another */
+	for id, held := range hub.subscriptions {
+		if held.Owner == "owner" {
+			hub.Unsubscribe(id)
+			break
+		}
+	}
+
+	if _, er := hub.Subscribe("owner", func(Event) bool { return true }); er != nil {
+		t.Fatal(er)
+	}
+}
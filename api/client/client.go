@@ -11,10 +11,12 @@ import (
 
 	"macrobooru/api"
 	"macrobooru/api/operations/authenticate"
+	"macrobooru/api/operations/describe"
 	"macrobooru/api/operations/nonce"
 	"macrobooru/api/operations/resetpassword"
 	"macrobooru/api/operations/setpassword"
 	_ "macrobooru/api/operations/static_status"
+	"macrobooru/api/operations/subscribe"
 	"macrobooru/api/operations/verify"
 	"macrobooru/models"
 )
@@ -23,6 +25,22 @@ type Client struct {
 	endpoint  string
 	AuthToken string
 	Config    ClientConfig
+
+	// Progress, if set, is called as request bodies (including attachments) are sent.
+	Progress api.ProgressFunc
+
+	// Codec is the encoding requests are sent in, and responses are asked for in.
+	// nil means JSON; api.MsgpackCodec and api.CBORCodec are the alternatives.
+	Codec api.Codec
+
+	// DisableCompression stops large request payloads from being gzipped, and
+	// compressed responses from being asked for.
+	DisableCompression bool
+
+	// Version is the version of the API to speak. Zero leaves it to the endpoint
+	// path (or the server's default), but payloads are then sent in their version 2
+	// form, so set it if the path names a later version.
+	Version int
 }
 
 type ClientConfig struct {
@@ -182,6 +200,61 @@ func (client *Client) Verify(code string) (token string, user *models.User, er e
 	return
 }
 
+/* Describe fetches the server's description of its operations and models. */
+func (client *Client) Describe() (*describe.DescribeResponse, error) {
+	payload := &describe.DescribePayload{}
+
+	res, er := client.Execute(payload, nil)
+	if er != nil {
+		return nil, er
+	}
+
+	iRes, er := payload.ParseResponse(*res)
+	if er != nil {
+		return nil, er
+	}
+
+	return iRes.(*describe.DescribeResponse), nil
+}
+
+/* Subscribe creates a subscription to changes of the objects of model matching
+ * where, which is then polled for events with Poll. */
+func (client *Client) Subscribe(model string, where map[string]interface{}) (string, error) {
+	res, er := client.pollSubscription(&subscribe.SubscribePayload{
+		Model: model,
+		Where: where,
+	})
+
+	if er != nil {
+		return "", er
+	}
+
+	return res.Subscription, nil
+}
+
+/* Poll waits up to timeoutSeconds (0 for the server default) for events on a
+ * subscription created with Subscribe. */
+func (client *Client) Poll(subscription string, timeoutSeconds int) (*subscribe.SubscribeResponse, error) {
+	return client.pollSubscription(&subscribe.SubscribePayload{
+		Subscription: subscription,
+		Timeout:      timeoutSeconds,
+	})
+}
+
+func (client *Client) pollSubscription(payload *subscribe.SubscribePayload) (*subscribe.SubscribeResponse, error) {
+	res, er := client.Execute(payload, nil)
+	if er != nil {
+		return nil, er
+	}
+
+	iRes, er := payload.ParseResponse(*res)
+	if er != nil {
+		return nil, er
+	}
+
+	return iRes.(*subscribe.SubscribeResponse), nil
+}
+
 func (client *Client) CreateNonce(email string) error {
 	payload := &nonce.NoncePayload{
 		Email: email,
@@ -223,15 +296,22 @@ func (client *Client) SetPasswordWithNonce(pword string, nonce string) error {
 	return nil
 }
 
-func (client *Client) wrapRequest(operation api.Operation, attachments map[string]multipart.File) (*http.Request, error) {
+func (client *Client) wrapRequest(operation api.Operation, attachments map[string]multipart.File, idempotencyKey string) (*http.Request, error) {
 	reqWrapper := api.RequestWrapper{
-		Operation:   operation.Name(),
-		AuthToken:   client.AuthToken,
-		Data:        operation,
-		Attachments: attachments,
+		Operation:      operation.Name(),
+		AuthToken:      client.AuthToken,
+		IdempotencyKey: idempotencyKey,
+		Data:           operation,
+		Attachments:    attachments,
+		Codec:          client.Codec,
+		Version:        client.Version,
+	}
+
+	if !client.DisableCompression {
+		reqWrapper.ContentEncoding = "gzip"
 	}
 
-	httpReq, er := api.WrapHttpRequest(client.endpoint, &reqWrapper)
+	httpReq, er := api.WrapHttpRequestWithProgress(client.endpoint, &reqWrapper, client.Progress)
 	if er != nil {
 		return nil, er
 	}
@@ -240,7 +320,7 @@ func (client *Client) wrapRequest(operation api.Operation, attachments map[strin
 }
 
 func (client *Client) dumpRequest(operation api.Operation, attachments map[string]multipart.File) {
-	httpReq, er := client.wrapRequest(operation, attachments)
+	httpReq, er := client.wrapRequest(operation, attachments, "")
 	if er == nil {
 		body, _ := ioutil.ReadAll(httpReq.Body)
 		fmt.Printf("=======\nREQUEST\n=======\n%s\n", string(body))
@@ -253,7 +333,13 @@ func (client *Client) DumpQuery(query *Query) {
 }
 
 func (client *Client) Execute(operation api.Operation, attachments map[string]multipart.File) (*api.ResponseWrapper, error) {
-	httpReq, er := client.wrapRequest(operation, attachments)
+	return client.ExecuteIdempotently(operation, attachments, "")
+}
+
+/* ExecuteIdempotently sends the operation with an idempotency key, so that it can
+ * safely be resent (with the same key) if it's unclear whether it went through. */
+func (client *Client) ExecuteIdempotently(operation api.Operation, attachments map[string]multipart.File, idempotencyKey string) (*api.ResponseWrapper, error) {
+	httpReq, er := client.wrapRequest(operation, attachments, idempotencyKey)
 	if er != nil {
 		return nil, er
 	}
@@ -264,13 +350,26 @@ func (client *Client) Execute(operation api.Operation, attachments map[string]mu
 	}
 	defer httpRes.Body.Close()
 
-	resWrapper, er := api.UnwrapHttpResponse(httpRes.Body)
+	/* Servers which predate alternative encodings always answer in JSON, whatever
+	 * they were asked for */
+	codec := api.CodecByContentType(httpRes.Header.Get("Content-Type"))
+	if codec == nil {
+		codec = api.JSONCodec
+	}
+
+	body, er := api.DecompressReader(httpRes.Header.Get("Content-Encoding"), httpRes.Body)
+	if er != nil {
+		return nil, er
+	}
+	defer body.Close()
+
+	resWrapper, er := api.UnwrapHttpResponseWithCodec(body, codec)
 	if er != nil {
 		return nil, er
 	}
 
-	if resWrapper.StatusCode != 0 {
-		return nil, api.NewApiError(resWrapper.StatusCode, nil, fmt.Errorf("%s", resWrapper.StatusMessage))
+	if er := resWrapper.Err(); er != nil {
+		return nil, er
 	}
 
 	return resWrapper, nil
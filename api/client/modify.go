@@ -9,6 +9,7 @@ import (
 	"os"
 	"reflect"
 
+	"macrobooru/api"
 	"macrobooru/api/operations/modify"
 	"macrobooru/models"
 )
@@ -109,7 +110,7 @@ func (mod *Modification) Execute(client *Client) error {
 		return mod.deferredError
 	}
 
-	resWrapper, er := client.Execute(&mod.payload, nil)
+	resWrapper, er := client.Execute(client.modifyPayload(mod.payload), nil)
 	if er != nil {
 		return er
 	}
@@ -127,6 +128,15 @@ func (mod *Modification) Execute(client *Client) error {
 	return nil
 }
 
+/* modifyPayload is payload in the form the version of the API client speaks expects. */
+func (client *Client) modifyPayload(payload modify.ModifyPayload) api.Operation {
+	if client.Version >= api.Version3 {
+		return payload.V3()
+	}
+
+	return &payload
+}
+
 type UploadModification struct {
 	static models.Static
 	data   multipart.File
@@ -183,7 +193,7 @@ func (mod *UploadModification) Execute(client *Client) error {
 		return er
 	}
 
-	resWrapper, er := client.Execute(payload, map[string]multipart.File{
+	resWrapper, er := client.Execute(client.modifyPayload(*payload), map[string]multipart.File{
 		"attached-file": mod.data,
 	})
 
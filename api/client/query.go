@@ -181,6 +181,11 @@ func (query *Query) Execute(client *Client) error {
 			*req.outTotalPtr = part.Total
 		}
 
+		req.cursor = part.Cursor
+		if req.outCursorPtr != nil {
+			*req.outCursorPtr = part.Cursor
+		}
+
 		outSlicePtr.Elem().Set(outSliceVal)
 	}
 
@@ -191,12 +196,81 @@ func (query *Query) Error() error {
 	return query.deferredError
 }
 
+/* QueryPages steps through the results of one request of a query a page at a
+ * time, following the cursor of each page to the next:
+ *
+ *     pages := query.Pages(client, "images")
+ *     for pages.Next() {
+ *         // use the slice given to Add
+ *     }
+ *
+ *     if er := pages.Err(); er != nil { ... }
+ */
+type QueryPages struct {
+	query   *Query
+	client  *Client
+	name    string
+	started bool
+	done    bool
+	err     error
+}
+
+/* Pages pages through the results of the request called name, each page as long
+ * as its limit. The rest of the query is run again alongside each page. */
+func (query *Query) Pages(client *Client, name string) *QueryPages {
+	return &QueryPages{
+		query:  query,
+		client: client,
+		name:   name,
+	}
+}
+
+/* Next fetches the next page into the slice given for the request, returning false
+ * once there are no more or something went wrong. */
+func (pages *QueryPages) Next() bool {
+	if pages.done || pages.err != nil {
+		return false
+	}
+
+	details, ok := pages.query.requests[pages.name]
+	if !ok || details.Transient {
+		pages.err = fmt.Errorf("query: no request (%s) to page through", pages.name)
+		return false
+	}
+
+	/* The cursor marks where the last page ended, so an offset (as set by
+	 * Paginate) only applies to the first */
+	if pages.started {
+		details.After = details.cursor
+		details.Offset = 0
+	}
+	pages.started = true
+
+	if er := pages.query.Execute(pages.client); er != nil {
+		pages.err = er
+		return false
+	}
+
+	/* Only full pages have cursors */
+	if details.cursor == "" {
+		pages.done = true
+	}
+
+	return reflect.ValueOf(details.outSlicePtr).Elem().Len() > 0
+}
+
+func (pages *QueryPages) Err() error {
+	return pages.err
+}
+
 type QueryDetails struct {
 	QueryRequest
 
-	modelMeta   models.ModelMeta
-	outSlicePtr interface{}
-	outTotalPtr *int64
+	modelMeta    models.ModelMeta
+	outSlicePtr  interface{}
+	outTotalPtr  *int64
+	outCursorPtr *string
+	cursor       string
 }
 
 func (details *QueryDetails) Where(fields map[string]interface{}) *QueryDetails {
@@ -219,14 +293,55 @@ func (details *QueryDetails) TextSearch(fields map[string]string) *QueryDetails
 	return details
 }
 
+/* Paginate fetches page (counting from zero) of the results, sorted by order (as
+ * in OrderBy). */
 func (details *QueryDetails) Paginate(order string, page, perPage int64) *QueryDetails {
-	details.Order = order
+	if order != "" {
+		details.Order = OrderClause{order}
+	}
+
 	details.Limit = perPage
 	details.Offset = page * perPage
 	return details
 }
 
+/* OrderBy sorts the results by each of terms ("field", "field ASC" or "field
+ * DESC"), most significant first. */
+func (details *QueryDetails) OrderBy(terms ...string) *QueryDetails {
+	details.Order = OrderClause(terms)
+	return details
+}
+
+/* Select fetches only the named fields (by their JSON names) of the results, and
+ * their primary keys; the rest are left zero. */
+func (details *QueryDetails) Select(fields ...string) *QueryDetails {
+	details.Fields = fields
+	return details
+}
+
 func (details *QueryDetails) Total(out *int64) *QueryDetails {
 	details.outTotalPtr = out
 	return details
 }
+
+/* SkipTotal doesn't count the results, which saves a query where the database
+ * can't count them alongside the page. */
+func (details *QueryDetails) SkipTotal() *QueryDetails {
+	skip := false
+	details.CountTotal = &skip
+	return details
+}
+
+/* Cursor stores the cursor of the page fetched in out. It's empty unless the page
+ * was full; otherwise Resume picks up where the page left off. */
+func (details *QueryDetails) Cursor(out *string) *QueryDetails {
+	details.outCursorPtr = out
+	return details
+}
+
+/* Resume fetches the results following the page cursor came with. The query must
+ * be ordered the same way as the one that page came from. */
+func (details *QueryDetails) Resume(cursor string) *QueryDetails {
+	details.After = cursor
+	return details
+}
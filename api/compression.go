@@ -0,0 +1,111 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+/* Bodies smaller than this aren't worth compressing; the framing overhead eats
+ * most of the savings. */
+const minCompressSize = 1024
+
+/* DecompressReader undoes the Content-Encoding of a body (or multipart part). The
+ * returned reader must be closed, but doesn't close r. As per HTTP, "deflate" means
+ * zlib-wrapped deflate. */
+func DecompressReader(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return ioutil.NopCloser(r), nil
+
+	case "gzip", "x-gzip":
+		reader, er := gzip.NewReader(r)
+		if er != nil {
+			return nil, ErrorInvalidInputFormat(er.Error())
+		}
+
+		return reader, nil
+
+	case "deflate":
+		reader, er := zlib.NewReader(r)
+		if er != nil {
+			return nil, ErrorInvalidInputFormat(er.Error())
+		}
+
+		return reader, nil
+	}
+
+	return nil, ErrorInvalidInputFormat("unsupported Content-Encoding " + encoding)
+}
+
+/* Compress encodes body with the given content coding, which must be "gzip" or
+ * "deflate". */
+func Compress(encoding string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var writer io.WriteCloser
+
+	switch encoding {
+	case "gzip":
+		writer = gzip.NewWriter(&buf)
+
+	case "deflate":
+		writer = zlib.NewWriter(&buf)
+
+	default:
+		return nil, fmt.Errorf("unsupported content coding %s", encoding)
+	}
+
+	if _, er := writer.Write(body); er != nil {
+		return nil, er
+	}
+
+	if er := writer.Close(); er != nil {
+		return nil, er
+	}
+
+	return buf.Bytes(), nil
+}
+
+/* NegotiateContentEncoding picks the compression for a response from an
+ * Accept-Encoding header, preferring gzip when the client weighs them equally.
+ * Returns "" if the response should be sent uncompressed. */
+func NegotiateContentEncoding(acceptEncoding string) string {
+	best := ""
+	bestQuality := 0.0
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		quality := 1.0
+
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+
+			if strings.HasPrefix(param, "q=") {
+				if q, er := strconv.ParseFloat(param[2:], 64); er == nil {
+					quality = q
+				}
+			}
+		}
+
+		if coding == "x-gzip" || coding == "*" {
+			coding = "gzip"
+		}
+
+		if (coding != "gzip" && coding != "deflate") || quality <= 0 {
+			continue
+		}
+
+		if quality > bestQuality || (quality == bestQuality && coding == "gzip") {
+			best = coding
+			bestQuality = quality
+		}
+	}
+
+	return best
+}
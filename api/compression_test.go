@@ -0,0 +1,130 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateContentEncoding(t *testing.T) {
+	cases := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"br, *", "gzip"},
+		{"gzip;q=0", ""},
+	}
+
+	for _, c := range cases {
+		if encoding := NegotiateContentEncoding(c.acceptEncoding); encoding != c.expected {
+			t.Errorf("NegotiateContentEncoding(%q) = %q, expected %q", c.acceptEncoding, encoding, c.expected)
+		}
+	}
+}
+
+func TestCompressedRoundTrip(t *testing.T) {
+	value := strings.Repeat("compressible ", 500)
+
+	for _, encoding := range []string{"gzip", "deflate"} {
+		req := &RequestWrapper{
+			Operation:       "test_echo",
+			Data:            &echoPayload{value},
+			ContentEncoding: encoding,
+		}
+
+		httpReq, er := WrapHttpRequest("/v2/api", req)
+		if er != nil {
+			t.Fatal(er)
+		}
+
+		/* Check the data part really was compressed on the way out */
+		var sent bytes.Buffer
+		sent.ReadFrom(httpReq.Body)
+
+		if sent.Len() >= len(value) {
+			t.Fatalf("%s: request body of %d bytes was not compressed", encoding, sent.Len())
+		}
+
+		httpReq.Body = (*BufCloser)(&sent)
+		httpReq.Header.Set("Accept-Encoding", encoding)
+
+		recorder := httptest.NewRecorder()
+		NewHandler(nil).ServeHTTP(recorder, httpReq)
+
+		if contentEncoding := recorder.Header().Get("Content-Encoding"); contentEncoding != encoding {
+			t.Fatalf("expected a %s response, got %q", encoding, contentEncoding)
+		}
+
+		body, er := DecompressReader(encoding, recorder.Body)
+		if er != nil {
+			t.Fatal(er)
+		}
+
+		wrapper, er := UnwrapHttpResponse(body)
+		if er != nil {
+			t.Fatal(er)
+		}
+
+		if res, er := (&echoPayload{}).ParseResponse(*wrapper); er != nil || res != value {
+			t.Fatalf("%s: payload did not survive the round trip (%v)", encoding, er)
+		}
+	}
+}
+
+func TestCompressedJsonBody(t *testing.T) {
+	body, er := Compress("gzip", []byte(`{ "operation" : "test_echo", "data" : { "value" : "hello" } }`))
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	req, er := http.NewRequest("POST", "/v2/api", bytes.NewBuffer(body))
+	if er != nil {
+		t.Fatal(er)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	recorder := httptest.NewRecorder()
+	NewHandler(nil).ServeHTTP(recorder, req)
+
+	/* Too small to be worth compressing on the way back */
+	if recorder.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("small response was compressed")
+	}
+
+	wrapper, er := UnwrapHttpResponse(recorder.Body)
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	if string(wrapper.Data) != `"hello"` {
+		t.Fatalf("unexpected response %#v", wrapper)
+	}
+}
+
+func TestCompressedBodyLimit(t *testing.T) {
+	/* Compresses to almost nothing, but inflates past the limit */
+	padding := strings.Repeat(" ", 4096)
+	body, er := Compress("gzip", []byte(`{ "operation" : "test_echo", "data" : { "value" : "hello" } }`+padding))
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	req, er := http.NewRequest("POST", "/v2/api", bytes.NewBuffer(body))
+	if er != nil {
+		t.Fatal(er)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	if _, er := UnwrapHttpRequestWithLimits(req, AttachmentLimits{MaxPartSize: 1024}); !IsAttachmentTooLarge(er) {
+		t.Fatalf("expected the part limit to be enforced, got %v", er)
+	}
+}
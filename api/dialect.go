@@ -0,0 +1,121 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+/* Dialect covers the differences between the SQL databases operations build
+ * statements for. */
+type Dialect interface {
+	Name() string
+
+	// QuoteIdentifier quotes a table, column or alias name so it can never be read
+	// as anything else.
+	QuoteIdentifier(name string) string
+
+	// Placeholder is the marker for the n'th (counting from 1) query argument.
+	Placeholder(n int) string
+
+	// NullsFirst is whether NULL sorts before every other value in ascending order.
+	NullsFirst() bool
+
+	// WindowFunctions is whether aggregates can be taken OVER () the rows of a
+	// query, as COUNT(*) OVER () is.
+	WindowFunctions() bool
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string {
+	return "postgres"
+}
+
+func (postgresDialect) QuoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func (postgresDialect) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (postgresDialect) NullsFirst() bool {
+	return false
+}
+
+func (postgresDialect) WindowFunctions() bool {
+	return true
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string {
+	return "sqlite"
+}
+
+func (sqliteDialect) QuoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func (sqliteDialect) Placeholder(n int) string {
+	return "?" + strconv.Itoa(n)
+}
+
+func (sqliteDialect) NullsFirst() bool {
+	return true
+}
+
+/* Since SQLite 3.25 */
+func (sqliteDialect) WindowFunctions() bool {
+	return true
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string {
+	return "mysql"
+}
+
+func (mysqlDialect) QuoteIdentifier(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+func (mysqlDialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (mysqlDialect) NullsFirst() bool {
+	return true
+}
+
+/* Not until MySQL 8, so not assumed */
+func (mysqlDialect) WindowFunctions() bool {
+	return false
+}
+
+var (
+	PostgresDialect Dialect = postgresDialect{}
+	SQLiteDialect   Dialect = sqliteDialect{}
+	MySQLDialect    Dialect = mysqlDialect{}
+)
+
+type dialectHolder struct {
+	dialect Dialect
+}
+
+var activeDialect atomic.Value
+
+/* SetDialect chooses the dialect statements are built in. The default is
+ * PostgresDialect. */
+func SetDialect(dialect Dialect) {
+	activeDialect.Store(dialectHolder{dialect})
+}
+
+func ActiveDialect() Dialect {
+	if holder, ok := activeDialect.Load().(dialectHolder); ok && holder.dialect != nil {
+		return holder.dialect
+	}
+
+	return PostgresDialect
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestDialects(t *testing.T) {
+	cases := []struct {
+		dialect     Dialect
+		quoted      string
+		placeholder string
+		nullsFirst  bool
+		windows     bool
+	}{
+		{PostgresDialect, `"we""ird"`, "$3", false, true},
+		{SQLiteDialect, `"we""ird"`, "?3", true, true},
+		{MySQLDialect, "`we\"ird`", "?", true, false},
+	}
+
+	for _, c := range cases {
+		if quoted := c.dialect.QuoteIdentifier(`we"ird`); quoted != c.quoted {
+			t.Errorf("%s: expected %s, got %s", c.dialect.Name(), c.quoted, quoted)
+		}
+
+		if placeholder := c.dialect.Placeholder(3); placeholder != c.placeholder {
+			t.Errorf("%s: expected %s, got %s", c.dialect.Name(), c.placeholder, placeholder)
+		}
+
+		if c.dialect.NullsFirst() != c.nullsFirst {
+			t.Errorf("%s: expected NULLs first to be %v", c.dialect.Name(), c.nullsFirst)
+		}
+
+		if c.dialect.WindowFunctions() != c.windows {
+			t.Errorf("%s: expected window functions to be %v", c.dialect.Name(), c.windows)
+		}
+	}
+
+	if MySQLDialect.QuoteIdentifier("a`b") != "`a``b`" {
+		t.Errorf("backticks not escaped")
+	}
+
+	SetDialect(MySQLDialect)
+	defer SetDialect(nil)
+
+	if ActiveDialect() != MySQLDialect {
+		t.Errorf("SetDialect had no effect")
+	}
+}
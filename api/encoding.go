@@ -0,0 +1,216 @@
+package api
+
+import (
+	"encoding/json"
+	"mime"
+	"reflect"
+	"strings"
+
+	"github.com/ugorji/go/codec"
+)
+
+/* Codec is a wire encoding for request and response payloads. JSON is the default;
+ * MessagePack and CBOR are offered for clients where JSON parsing is expensive.
+ * Both binary codecs use the same field names as the JSON encoding. */
+type Codec interface {
+	// The media type bodies in this encoding are sent with.
+	ContentType() string
+
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type binaryCodec struct {
+	contentType string
+	handle      codec.Handle
+}
+
+func (bc *binaryCodec) ContentType() string {
+	return bc.contentType
+}
+
+func (bc *binaryCodec) Marshal(v interface{}) (bs []byte, er error) {
+	er = codec.NewEncoderBytes(&bs, bc.handle).Encode(v)
+	return
+}
+
+func (bc *binaryCodec) Unmarshal(data []byte, v interface{}) error {
+	er := codec.NewDecoderBytes(data, bc.handle).Decode(v)
+
+	/* Selfers report failures by panicking, which the decoder wraps up; dig out
+	 * any *ApiError so it reaches the client as-is, as it would with JSON. */
+	if causer, ok := er.(interface{ Cause() error }); ok {
+		if apiEr, ok := causer.Cause().(*ApiError); ok {
+			return apiEr
+		}
+	}
+
+	return er
+}
+
+var (
+	JSONCodec    Codec = jsonCodec{}
+	MsgpackCodec Codec = newMsgpackCodec()
+	CBORCodec    Codec = newCBORCodec()
+)
+
+/* Generic values (e.g. the values of a where clause) should decode into the same
+ * shapes encoding/json would produce, and RawMessage relies on Raw being enabled. */
+var genericMapType = reflect.TypeOf(map[string]interface{}(nil))
+
+func newMsgpackCodec() Codec {
+	handle := &codec.MsgpackHandle{}
+	handle.MapType = genericMapType
+	handle.Raw = true
+	handle.WriteExt = true
+
+	return &binaryCodec{"application/msgpack", handle}
+}
+
+func newCBORCodec() Codec {
+	handle := &codec.CborHandle{}
+	handle.MapType = genericMapType
+	handle.Raw = true
+
+	return &binaryCodec{"application/cbor", handle}
+}
+
+var codecsByMediaType = map[string]Codec{
+	"application/json":      JSONCodec,
+	"text/json":             JSONCodec,
+	"application/msgpack":   MsgpackCodec,
+	"application/x-msgpack": MsgpackCodec,
+	"application/cbor":      CBORCodec,
+}
+
+/* CodecByContentType returns the codec for a Content-Type header, or nil if the
+ * media type isn't one we speak. */
+func CodecByContentType(contentType string) Codec {
+	mediaType, _, er := mime.ParseMediaType(contentType)
+	if er != nil {
+		return nil
+	}
+
+	return codecsByMediaType[mediaType]
+}
+
+/* NegotiateCodec picks the codec for a response from an Accept header, in the
+ * order the client listed them. If nothing acceptable is offered, fallback is used. */
+func NegotiateCodec(accept string, fallback Codec) Codec {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		if codec := CodecByContentType(strings.TrimSpace(mediaRange)); codec != nil {
+			return codec
+		}
+	}
+
+	return fallback
+}
+
+/* RawMessage is an encoded value whose decoding has been deferred, like a
+ * json.RawMessage but usable with every Codec. It holds bytes in whichever codec
+ * the enclosing message was decoded with. */
+type RawMessage []byte
+
+func (m RawMessage) MarshalJSON() ([]byte, error) {
+	if m == nil {
+		return []byte("null"), nil
+	}
+
+	return m, nil
+}
+
+func (m *RawMessage) UnmarshalJSON(bs []byte) error {
+	*m = append((*m)[0:0], bs...)
+	return nil
+}
+
+func (m *RawMessage) CodecEncodeSelf(e *codec.Encoder) {
+	if *m == nil {
+		e.MustEncode(nil)
+		return
+	}
+
+	e.MustEncode(codec.Raw(*m))
+}
+
+func (m *RawMessage) CodecDecodeSelf(d *codec.Decoder) {
+	var raw codec.Raw
+	d.MustDecode(&raw)
+	*m = RawMessage(raw)
+}
+
+type binaryRequestEnvelope struct {
+	Operation      string      `codec:"operation"`
+	AuthToken      string      `codec:"token,omitempty"`
+	Locale         string      `codec:"locale,omitempty"`
+	IdempotencyKey string      `codec:"idempotencyKey,omitempty"`
+	Data           interface{} `codec:"data"`
+}
+
+type binaryRequestEnvelopeRaw struct {
+	Operation      string     `codec:"operation"`
+	AuthToken      string     `codec:"token,omitempty"`
+	Locale         string     `codec:"locale,omitempty"`
+	IdempotencyKey string     `codec:"idempotencyKey,omitempty"`
+	Data           RawMessage `codec:"data"`
+}
+
+/* The binary encodings of a RequestWrapper mirror MarshalJSON: the parsed Data is
+ * sent, and is read back into RawData. */
+func (wrapper *RequestWrapper) CodecEncodeSelf(e *codec.Encoder) {
+	e.MustEncode(&binaryRequestEnvelope{
+		Operation:      wrapper.Operation,
+		AuthToken:      wrapper.AuthToken,
+		Locale:         wrapper.Locale,
+		IdempotencyKey: wrapper.IdempotencyKey,
+		Data:           wrapper.Data,
+	})
+}
+
+func (wrapper *RequestWrapper) CodecDecodeSelf(d *codec.Decoder) {
+	var envelope binaryRequestEnvelopeRaw
+	d.MustDecode(&envelope)
+
+	wrapper.Operation = envelope.Operation
+	wrapper.AuthToken = envelope.AuthToken
+	wrapper.Locale = envelope.Locale
+	wrapper.IdempotencyKey = envelope.IdempotencyKey
+	wrapper.RawData = envelope.Data
+}
+
+func codecOrDefault(c Codec) Codec {
+	if c == nil {
+		return JSONCodec
+	}
+
+	return c
+}
+
+/* UnmarshalData decodes the operation payload, in whatever encoding it was sent. */
+func (wrapper *RequestWrapper) UnmarshalData(v interface{}) error {
+	return codecOrDefault(wrapper.Codec).Unmarshal(wrapper.RawData, v)
+}
+
+/* UnmarshalData decodes the response payload, in whatever encoding it was sent. */
+func (wrapper *ResponseWrapper) UnmarshalData(v interface{}) error {
+	return wrapper.UnmarshalNested(wrapper.Data, v)
+}
+
+/* UnmarshalNested decodes a RawMessage that was pulled out of the response payload. */
+func (wrapper *ResponseWrapper) UnmarshalNested(data RawMessage, v interface{}) error {
+	return codecOrDefault(wrapper.Codec).Unmarshal(data, v)
+}
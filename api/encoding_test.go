@@ -0,0 +1,93 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"macrobooru/models"
+)
+
+func TestCodecNegotiation(t *testing.T) {
+	cases := []struct {
+		accept   string
+		expected Codec
+	}{
+		{"", JSONCodec},
+		{"*/*", JSONCodec},
+		{"application/msgpack", MsgpackCodec},
+		{"text/html, application/x-msgpack", MsgpackCodec},
+		{"application/cbor; q=0.9, application/json", CBORCodec},
+	}
+
+	for _, c := range cases {
+		if codec := NegotiateCodec(c.accept, JSONCodec); codec != c.expected {
+			t.Errorf("NegotiateCodec(%q) = %s, expected %s", c.accept, codec.ContentType(), c.expected.ContentType())
+		}
+	}
+}
+
+func TestBinaryCodecRoundTrip(t *testing.T) {
+	for _, codec := range []Codec{MsgpackCodec, CBORCodec} {
+		req := &RequestWrapper{
+			Operation: "test_echo",
+			Data:      &echoPayload{"hello"},
+			Codec:     codec,
+		}
+
+		httpReq, er := WrapHttpRequest("/v2/api", req)
+		if er != nil {
+			t.Fatal(er)
+		}
+
+		recorder := httptest.NewRecorder()
+		NewHandler(nil).ServeHTTP(recorder, httpReq)
+
+		if contentType := recorder.Header().Get("Content-Type"); contentType != codec.ContentType() {
+			t.Fatalf("expected a %s response, got %s", codec.ContentType(), contentType)
+		}
+
+		wrapper, er := UnwrapHttpResponseWithCodec(recorder.Body, codec)
+		if er != nil {
+			t.Fatal(er)
+		}
+
+		if er := wrapper.Err(); er != nil {
+			t.Fatalf("%s: %s", codec.ContentType(), er)
+		}
+
+		res, er := (&echoPayload{}).ParseResponse(*wrapper)
+		if er != nil {
+			t.Fatal(er)
+		}
+
+		if res != "hello" {
+			t.Fatalf("%s: unexpected response %#v", codec.ContentType(), res)
+		}
+	}
+}
+
+func TestBinaryModelRoundTrip(t *testing.T) {
+	image := models.Image{
+		Pid:          models.NewGUID(),
+		Filehash:     "abc123",
+		Mime:         "image/png",
+		UploadedDate: time.Unix(1400000000, 0).UTC(),
+	}
+
+	for _, codec := range []Codec{MsgpackCodec, CBORCodec} {
+		bs, er := codec.Marshal(&image)
+		if er != nil {
+			t.Fatal(er)
+		}
+
+		var decoded models.Image
+		if er := codec.Unmarshal(bs, &decoded); er != nil {
+			t.Fatal(er)
+		}
+
+		if !decoded.Pid.Equal(image.Pid) || decoded.Filehash != image.Filehash || !decoded.UploadedDate.Equal(image.UploadedDate) {
+			t.Fatalf("%s: %#v did not survive the round trip: %#v", codec.ContentType(), image, decoded)
+		}
+	}
+}
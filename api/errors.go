@@ -32,8 +32,59 @@ const (
 	ErrCodeUserNotFound           = 0x80000009
 	ErrCodeSphinxSyntaxError      = 0x8000000A
 	ErrCodeSphinxOtherError       = 0x8000000B
+	ErrCodeRateLimitExceeded      = 0x8000000C
+	ErrCodeIdempotencyKeyReused   = 0x8000000D
+	ErrCodeRequestInProgress      = 0x8000000E
+	ErrCodeAttachmentTooLarge     = 0x8000000F
+	ErrCodeTooManySubscriptions   = 0x80000010
+	ErrCodeNotRun                 = 0x80000011
+	ErrCodeRolledBack             = 0x80000012
 )
 
+/* errorKeys gives each status code a stable, machine-readable name which is sent
+ * alongside the numeric code in every response envelope. */
+var errorKeys = map[int64]string{
+	ErrCodeNoError:                "ok",
+	ErrCodeGeneric:                "generic",
+	ErrCodeInvalidInputFormat:     "invalid_input_format",
+	ErrCodeMissingInputField:      "missing_input_field",
+	ErrCodeInvalidInputField:      "invalid_input_field",
+	ErrCodeObjectNotFound:         "object_not_found",
+	ErrCodeRequiresAuthentication: "requires_authentication",
+	ErrCodeInvalidCredentials:     "invalid_credentials",
+	ErrCodeInvalidToken:           "invalid_token",
+	ErrCodeConversionFailure:      "conversion_failure",
+	ErrCodeFileNotFound:           "file_not_found",
+	ErrCodeInvalidFileType:        "invalid_file_type",
+	ErrCodeVerificationEmailSent:  "verification_email_sent",
+	ErrCodeUsernameAlreadyExists:  "username_already_exists",
+	ErrCodeEmailAlreadyExists:     "email_already_exists",
+	ErrCodeAuthenticationFailure:  "authentication_failure",
+	ErrCodeAccountNotVerified:     "account_not_verified",
+	ErrCodeRequiresAdmin:          "requires_admin",
+	ErrCodeInvalidNonce:           "invalid_nonce",
+	ErrCodePermissionDenied:       "permission_denied",
+	ErrCodeAlreadyDeleted:         "already_deleted",
+	ErrCodeUserNotFound:           "user_not_found",
+	ErrCodeSphinxSyntaxError:      "search_syntax_error",
+	ErrCodeSphinxOtherError:       "search_error",
+	ErrCodeRateLimitExceeded:      "rate_limit_exceeded",
+	ErrCodeIdempotencyKeyReused:   "idempotency_key_reused",
+	ErrCodeRequestInProgress:      "request_in_progress",
+	ErrCodeAttachmentTooLarge:     "attachment_too_large",
+	ErrCodeTooManySubscriptions:   "too_many_subscriptions",
+	ErrCodeNotRun:                 "not_run",
+	ErrCodeRolledBack:             "rolled_back",
+}
+
+func ErrorKey(code int64) string {
+	if key, ok := errorKeys[code]; ok {
+		return key
+	}
+
+	return "unknown"
+}
+
 type ApiError struct {
 	code     int64
 	args     []interface{}
@@ -62,6 +113,14 @@ func (e *ApiError) Code() int64 {
 	return e.code
 }
 
+func (e *ApiError) Key() string {
+	return ErrorKey(e.code)
+}
+
+func (e *ApiError) Args() []interface{} {
+	return e.args
+}
+
 func (e *ApiError) Original() (errorString string) {
 	if e.original != nil {
 		errorString = e.original.Error()
@@ -83,12 +142,148 @@ func (e *ApiError) MarshalJSON() ([]byte, error) {
 
 	tmp := map[string]interface{}{
 		"statusCode": e.code,
+		"statusKey":  e.Key(),
 		"statusMsg":  msg,
 		"data":       nil,
 	}
+
+	if len(e.args) > 0 {
+		tmp["statusArgs"] = e.args
+	}
+
 	return json.Marshal(tmp)
 }
 
+/* HasCode reports whether er is an *ApiError with the given status code. Every
+ * code but ErrCodeNoError, which isn't an error, has an Is* predicate wrapping it. */
+func HasCode(er error, code int64) bool {
+	if apiEr, ok := er.(*ApiError); ok && apiEr != nil {
+		return apiEr.code == code
+	}
+
+	return false
+}
+
+func IsGeneric(er error) bool {
+	return HasCode(er, ErrCodeGeneric)
+}
+
+func IsInvalidInputFormat(er error) bool {
+	return HasCode(er, ErrCodeInvalidInputFormat)
+}
+
+func IsMissingInputField(er error) bool {
+	return HasCode(er, ErrCodeMissingInputField)
+}
+
+func IsInvalidInputField(er error) bool {
+	return HasCode(er, ErrCodeInvalidInputField)
+}
+
+func IsObjectNotFound(er error) bool {
+	return HasCode(er, ErrCodeObjectNotFound)
+}
+
+func IsRequiresAuthentication(er error) bool {
+	return HasCode(er, ErrCodeRequiresAuthentication)
+}
+
+func IsInvalidCredentials(er error) bool {
+	return HasCode(er, ErrCodeInvalidCredentials)
+}
+
+func IsInvalidToken(er error) bool {
+	return HasCode(er, ErrCodeInvalidToken)
+}
+
+func IsConversionFailure(er error) bool {
+	return HasCode(er, ErrCodeConversionFailure)
+}
+
+func IsFileNotFound(er error) bool {
+	return HasCode(er, ErrCodeFileNotFound)
+}
+
+func IsInvalidFileType(er error) bool {
+	return HasCode(er, ErrCodeInvalidFileType)
+}
+
+func IsVerificationEmailSent(er error) bool {
+	return HasCode(er, ErrCodeVerificationEmailSent)
+}
+
+func IsUsernameAlreadyExists(er error) bool {
+	return HasCode(er, ErrCodeUsernameAlreadyExists)
+}
+
+func IsEmailAlreadyExists(er error) bool {
+	return HasCode(er, ErrCodeEmailAlreadyExists)
+}
+
+func IsAuthenticationFailure(er error) bool {
+	return HasCode(er, ErrCodeAuthenticationFailure)
+}
+
+func IsAccountNotVerified(er error) bool {
+	return HasCode(er, ErrCodeAccountNotVerified)
+}
+
+func IsRequiresAdmin(er error) bool {
+	return HasCode(er, ErrCodeRequiresAdmin)
+}
+
+func IsInvalidNonce(er error) bool {
+	return HasCode(er, ErrCodeInvalidNonce)
+}
+
+func IsPermissionDenied(er error) bool {
+	return HasCode(er, ErrCodePermissionDenied)
+}
+
+func IsAlreadyDeleted(er error) bool {
+	return HasCode(er, ErrCodeAlreadyDeleted)
+}
+
+func IsUserNotFound(er error) bool {
+	return HasCode(er, ErrCodeUserNotFound)
+}
+
+func IsSphinxSyntaxError(er error) bool {
+	return HasCode(er, ErrCodeSphinxSyntaxError)
+}
+
+func IsSphinxOtherError(er error) bool {
+	return HasCode(er, ErrCodeSphinxOtherError)
+}
+
+func IsRateLimitExceeded(er error) bool {
+	return HasCode(er, ErrCodeRateLimitExceeded)
+}
+
+func IsIdempotencyKeyReused(er error) bool {
+	return HasCode(er, ErrCodeIdempotencyKeyReused)
+}
+
+func IsRequestInProgress(er error) bool {
+	return HasCode(er, ErrCodeRequestInProgress)
+}
+
+func IsAttachmentTooLarge(er error) bool {
+	return HasCode(er, ErrCodeAttachmentTooLarge)
+}
+
+func IsTooManySubscriptions(er error) bool {
+	return HasCode(er, ErrCodeTooManySubscriptions)
+}
+
+func IsNotRun(er error) bool {
+	return HasCode(er, ErrCodeNotRun)
+}
+
+func IsRolledBack(er error) bool {
+	return HasCode(er, ErrCodeRolledBack)
+}
+
 func NoError() *ApiError {
 	return &ApiError{ErrCodeNoError, nil, nil}
 }
@@ -190,3 +385,37 @@ func ErrorSphinxSyntaxError(original error) error {
 func ErrorSphinxOtherError(original error) error {
 	return &ApiError{ErrCodeSphinxOtherError, nil, original}
 }
+
+func ErrorRateLimitExceeded() error {
+	return &ApiError{ErrCodeRateLimitExceeded, nil, nil}
+}
+
+func ErrorIdempotencyKeyReused(key string) error {
+	return &ApiError{ErrCodeIdempotencyKeyReused, []interface{}{key}, nil}
+}
+
+func ErrorRequestInProgress() error {
+	return &ApiError{ErrCodeRequestInProgress, nil, nil}
+}
+
+func ErrorAttachmentTooLarge(contentId string, limit int64) error {
+	return &ApiError{ErrCodeAttachmentTooLarge, []interface{}{contentId, limit}, nil}
+}
+
+func ErrorTooManySubscriptions(limit int) error {
+	return &ApiError{ErrCodeTooManySubscriptions, []interface{}{limit}, nil}
+}
+
+/* ErrorNotRun is the status of an operation a batch skipped because it stopped at
+ * the operation at index stoppedAt (counting from 0): either that operation failed,
+ * or the request was cancelled before it ran. */
+func ErrorNotRun(stoppedAt int) error {
+	return &ApiError{ErrCodeNotRun, []interface{}{stoppedAt}, nil}
+}
+
+/* ErrorRolledBack is the status of an operation that succeeded in a transactional
+ * batch which was then rolled back, having stopped at the operation at index
+ * stoppedAt. */
+func ErrorRolledBack(stoppedAt int) error {
+	return &ApiError{ErrCodeRolledBack, []interface{}{stoppedAt}, nil}
+}
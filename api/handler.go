@@ -0,0 +1,222 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lye/crud"
+
+	"macrobooru/api/pluggable"
+	"macrobooru/models"
+)
+
+/* Handler serves the API endpoint (e.g. /v2/api, or /v3/api for version 3). As
+ * per the spec, every request that makes it this far is answered with HTTP 200;
+ * the outcome is reported through the statusCode/statusMsg of the response
+ * envelope instead. */
+type Handler struct {
+	// DB is handed to every operation that is dispatched.
+	DB *sql.DB
+
+	// Sessions resolves authentication tokens. If nil, the stores registered
+	// through pluggable.RegisterSessionStore are consulted instead.
+	Sessions pluggable.SessionStore
+
+	// Idempotency remembers the responses to requests sent with an idempotency
+	// key. If nil, the store registered through pluggable.RegisterIdempotencyStore
+	// is used; if there's none, keys are ignored.
+	Idempotency pluggable.IdempotencyStore
+
+	// IdempotencyTTL is how long responses are remembered for. Zero means
+	// DefaultIdempotencyTTL.
+	IdempotencyTTL time.Duration
+
+	// AttachmentLimits bounds the size of multipart uploads, and says where large
+	// ones are spooled. Zero fields take their value from DefaultAttachmentLimits.
+	AttachmentLimits AttachmentLimits
+}
+
+func NewHandler(db *sql.DB) *Handler {
+	return &Handler{
+		DB: db,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	start := time.Now()
+	entry := &accessEntry{}
+
+	wrapper := h.dispatch(r, entry)
+	wrapper.RequestID = entry.requestID
+	entry.status = wrapper.StatusCode
+
+	w.Header().Set(RequestIDHeader, entry.requestID)
+
+	if version, er := RequestVersion(r); er == nil {
+		w.Header().Set(VersionHeader, strconv.Itoa(version))
+	}
+	entry.bytes = h.writeResponse(r.Context(), w, wrapper, r.Header.Get("Accept-Encoding"))
+
+	entry.log(r.Context(), r, start)
+}
+
+func (h *Handler) dispatch(r *http.Request, entry *accessEntry) *ResponseWrapper {
+	acceptLanguage := r.Header.Get("Accept-Language")
+	accept := r.Header.Get("Accept")
+
+	req, er := UnwrapHttpRequestWithLimits(r, h.AttachmentLimits)
+	if er != nil {
+		entry.requestID = requestIDFor(r)
+		ctx := WithRequestID(r.Context(), entry.requestID)
+
+		if _, ok := er.(*ApiError); !ok {
+			er = ErrorInvalidInputFormat(er.Error())
+		}
+
+		return h.respond(ctx, nil, er, NegotiateLocale("", acceptLanguage), NegotiateCodec(accept, JSONCodec))
+	}
+	defer req.Close()
+
+	entry.requestID = req.RequestID
+	entry.operation = req.Operation
+
+	/* Answer in whatever the client asked for, otherwise in what it spoke */
+	codec := NegotiateCodec(accept, req.Codec)
+
+	ctx := WithRequestID(r.Context(), req.RequestID)
+	locale := NegotiateLocale(req.Locale, acceptLanguage)
+
+	user, er := h.LookupUser(ctx, req.AuthToken)
+	if er != nil {
+		return h.respond(ctx, nil, er, locale, codec)
+	}
+
+	if user != nil {
+		entry.user = user.Pid.String()
+	}
+
+	opReq := &Request{
+		User:        user,
+		SessionKey:  req.AuthToken,
+		Attachments: req.Attachments,
+		MimeTypes:   req.MimeTypes,
+		RemoteAddr:  r.RemoteAddr,
+		RequestID:   req.RequestID,
+		Locale:      locale,
+		Codec:       codec,
+		Version:     req.Version,
+	}
+
+	if req.IdempotencyKey != "" {
+		return h.executeIdempotently(ctx, req, opReq)
+	}
+
+	data, er := Execute(ctx, req.Data, opReq, h.DB)
+	return h.respond(ctx, data, er, locale, codec)
+}
+
+/* respond builds the localized envelope. The catalog message replaces whatever the
+ * underlying error said, so that's logged here rather than lost. */
+func (h *Handler) respond(ctx context.Context, data interface{}, er error, locale string, codec Codec) *ResponseWrapper {
+	wrapper := NewEncodedResponseWrapper(codec, data, er)
+
+	if wrapper.StatusCode != ErrCodeNoError && wrapper.StatusMessage != "" {
+		level := slog.LevelInfo
+		if wrapper.StatusCode == ErrCodeGeneric {
+			level = slog.LevelError
+		}
+
+		Logger(ctx).Log(ctx, level, "request failed", "status", ErrorKey(wrapper.StatusCode), "error", wrapper.StatusMessage)
+	}
+
+	wrapper.Localize(locale)
+	return wrapper
+}
+
+/* LookupUser resolves an authentication token to its user, or nil if token is empty.
+ * It's exported for endpoints (such as WebSocket upgrades) which authenticate
+ * outside of the request envelope. */
+func (h *Handler) LookupUser(ctx context.Context, token string) (*models.User, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	var userGuid *models.GUID
+	var er error
+
+	if h.Sessions != nil {
+		userGuid, er = h.Sessions.LookupSession(pluggable.SessionKey(token))
+	} else {
+		userGuid, er = pluggable.LookupSession(pluggable.SessionKey(token))
+	}
+
+	if er != nil {
+		return nil, ErrorGeneric(er)
+	}
+
+	if userGuid == nil {
+		return nil, ErrorInvalidToken()
+	}
+
+	q := fmt.Sprintf("SELECT * FROM %s WHERE %s = $1", models.UserMeta.TableName(), models.UserMeta.PrimaryKey())
+	rows, er := h.DB.QueryContext(ctx, q, userGuid.String())
+	if er != nil {
+		return nil, ErrorGeneric(er)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, ErrorUserNotFound()
+	}
+
+	var user models.User
+
+	if er := crud.Scan(rows, &user); er != nil {
+		return nil, ErrorGeneric(er)
+	}
+
+	return &user, nil
+}
+
+/* writeResponse sends the envelope, returning the size of the body written. */
+func (h *Handler) writeResponse(ctx context.Context, w http.ResponseWriter, wrapper *ResponseWrapper, acceptEncoding string) int {
+	codec := codecOrDefault(wrapper.Codec)
+
+	body, er := codec.Marshal(wrapper)
+	if er != nil {
+		/* Only really happens if an operation returns something unmarshalable, which
+		 * NewResponseWrapper already guards against. Still, never leave the client
+		 * without an envelope. */
+		Logger(ctx).Error("unable to marshal response envelope", "error", er)
+		body, _ = codec.Marshal(NewEncodedResponseWrapper(codec, nil, ErrorGeneric(er)))
+	}
+
+	w.Header().Set("Content-Type", codec.ContentType())
+	w.Header().Set("Vary", "Accept, Accept-Encoding")
+
+	if encoding := NegotiateContentEncoding(acceptEncoding); encoding != "" && len(body) >= minCompressSize {
+		if compressed, er := Compress(encoding, body); er == nil {
+			w.Header().Set("Content-Encoding", encoding)
+			body = compressed
+
+		} else {
+			Logger(ctx).Warn("unable to compress response", "error", er)
+		}
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+
+	return len(body)
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"macrobooru/models"
+)
+
+type echoPayload struct {
+	Value string `json:"value"`
+}
+
+func (*echoPayload) Name() string {
+	return "test_echo"
+}
+
+func (*echoPayload) Parse(req *RequestWrapper) (Operation, error) {
+	var payload echoPayload
+
+	if er := req.UnmarshalData(&payload); er != nil {
+		return nil, ErrorInvalidInputFormat(er.Error())
+	}
+
+	return &payload, nil
+}
+
+func (payload *echoPayload) Execute(ctx context.Context, req *Request, db Queryer) (interface{}, error) {
+	switch payload.Value {
+	case "denied":
+		return nil, ErrorPermissionDenied(payload.Value)
+
+	case "broken":
+		return nil, fmt.Errorf("something broke")
+	}
+
+	return payload.Value, nil
+}
+
+func (*echoPayload) ParseResponse(wrapper ResponseWrapper) (interface{}, error) {
+	var res string
+	er := wrapper.UnmarshalData(&res)
+	return res, er
+}
+
+func init() {
+	RegisterOperation(&echoPayload{})
+}
+
+func serveTestRequest(t *testing.T, body string) ResponseWrapper {
+	req, er := http.NewRequest("POST", "/v2/api", bytes.NewBufferString(body))
+	if er != nil {
+		t.Fatal(er)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	recorder := httptest.NewRecorder()
+	NewHandler(nil).ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected HTTP 200, got %d", recorder.Code)
+	}
+
+	var wrapper ResponseWrapper
+	if er := json.Unmarshal(recorder.Body.Bytes(), &wrapper); er != nil {
+		t.Fatalf("%s\n%s", er, recorder.Body.String())
+	}
+
+	return wrapper
+}
+
+func TestHandlerSuccess(t *testing.T) {
+	wrapper := serveTestRequest(t, `{ "operation" : "test_echo", "data" : { "value" : "hello" } }`)
+
+	if wrapper.StatusCode != ErrCodeNoError {
+		t.Fatalf("expected success, got %#v", wrapper)
+	}
+
+	if string(wrapper.Data) != `"hello"` {
+		t.Fatalf("unexpected data %s", string(wrapper.Data))
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	cases := []struct {
+		body string
+		code int64
+	}{
+		{`{ "operation" : "test_echo", "data" : { "value" : "denied" } }`, ErrCodePermissionDenied},
+		{`{ "operation" : "test_echo", "data" : { "value" : "broken" } }`, ErrCodeGeneric},
+		{`{ "operation" : "no_such_operation", "data" : {} }`, ErrCodeInvalidInputFormat},
+		{`{ "operation" : `, ErrCodeInvalidInputFormat},
+	}
+
+	for _, c := range cases {
+		wrapper := serveTestRequest(t, c.body)
+
+		if wrapper.StatusCode != c.code {
+			t.Errorf("%s: expected status %d, got %d", c.body, c.code, wrapper.StatusCode)
+		}
+	}
+}
+
+type legacyPayload struct{}
+
+func (*legacyPayload) Name() string {
+	return "test_legacy"
+}
+
+func (*legacyPayload) Parse(req *RequestWrapper) (Operation, error) {
+	return &legacyPayload{}, nil
+}
+
+func (*legacyPayload) Process(u *models.User, db *sql.DB) (interface{}, error) {
+	return "legacy", nil
+}
+
+func (*legacyPayload) ParseResponse(wrapper ResponseWrapper) (interface{}, error) {
+	return nil, nil
+}
+
+func TestHandlerLegacyProcessor(t *testing.T) {
+	RegisterOperation(&legacyPayload{})
+
+	wrapper := serveTestRequest(t, `{ "operation" : "test_legacy", "data" : null }`)
+
+	if wrapper.StatusCode != ErrCodeNoError || string(wrapper.Data) != `"legacy"` {
+		t.Fatalf("legacy operation was not adapted: %#v", wrapper)
+	}
+}
+
+func TestEnvelopeCarriesArgs(t *testing.T) {
+	body, er := json.Marshal(NewResponseWrapper(nil, ErrorInvalidInputField("title")))
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	var wrapper ResponseWrapper
+	if er := json.Unmarshal(body, &wrapper); er != nil {
+		t.Fatal(er)
+	}
+
+	if wrapper.StatusKey != "invalid_input_field" {
+		t.Errorf("unexpected status key %s", wrapper.StatusKey)
+	}
+
+	rebuilt := wrapper.Err()
+	if !IsInvalidInputField(rebuilt) {
+		t.Fatalf("expected an invalid input field error, got %#v", rebuilt)
+	}
+
+	args := rebuilt.(*ApiError).Args()
+	if len(args) != 1 || args[0] != "title" {
+		t.Fatalf("args did not survive the round trip: %#v", args)
+	}
+
+	if IsPermissionDenied(rebuilt) {
+		t.Fatalf("predicates should only match their own code")
+	}
+}
@@ -0,0 +1,258 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"macrobooru/api/pluggable"
+)
+
+/* DefaultIdempotencyTTL is how long a response is kept for replay if the Handler
+ * doesn't say otherwise. */
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+func (h *Handler) idempotencyStore() pluggable.IdempotencyStore {
+	if h.Idempotency != nil {
+		return h.Idempotency
+	}
+
+	return pluggable.RegisteredIdempotencyStore()
+}
+
+/* executeIdempotently runs a request that was sent with an idempotency key. The first
+ * request with a given key (per user) runs as normal and has its response stored;
+ * any retry gets that response back without running again. Keys are scoped to the
+ * user, so anonymous requests with one are refused, whether or not there's a store
+ * to keep responses in. */
+func (h *Handler) executeIdempotently(ctx context.Context, req *RequestWrapper, opReq *Request) *ResponseWrapper {
+	if opReq.User == nil {
+		return h.respond(ctx, nil, ErrorRequiresAuthentication(), opReq.Locale, opReq.Codec)
+	}
+
+	store := h.idempotencyStore()
+	if store == nil {
+		Logger(ctx).Warn("ignoring idempotency key; no idempotency store is registered")
+
+		data, er := Execute(ctx, req.Data, opReq, h.DB)
+		return h.respond(ctx, data, er, opReq.Locale, opReq.Codec)
+	}
+
+	fingerprint, er := requestFingerprint(req)
+	if er != nil {
+		return h.respond(ctx, nil, ErrorGeneric(er), opReq.Locale, opReq.Codec)
+	}
+
+	ttl := h.IdempotencyTTL
+	if ttl == 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+
+	userGuid := opReq.User.Pid
+
+	record, er := store.Reserve(userGuid, req.IdempotencyKey, fingerprint, ttl)
+	if er != nil {
+		return h.respond(ctx, nil, ErrorGeneric(er), opReq.Locale, opReq.Codec)
+	}
+
+	if record != nil {
+		if record.Fingerprint != fingerprint {
+			return h.respond(ctx, nil, ErrorIdempotencyKeyReused(req.IdempotencyKey), opReq.Locale, opReq.Codec)
+		}
+
+		if record.Response == nil {
+			return h.respond(ctx, nil, ErrorRequestInProgress(), opReq.Locale, opReq.Codec)
+		}
+
+		wrapper, er := replayResponse(record, opReq.Codec)
+		if er != nil {
+			return h.respond(ctx, nil, ErrorGeneric(er), opReq.Locale, opReq.Codec)
+		}
+
+		wrapper.Localize(opReq.Locale)
+		return wrapper
+	}
+
+	/* Free the key if the operation panics, rather than leave retries waiting on it
+	 * until it expires */
+	defer func() {
+		if p := recover(); p != nil {
+			if er := store.Release(userGuid, req.IdempotencyKey); er != nil {
+				Logger(ctx).Error("unable to release idempotency key", "error", er)
+			}
+
+			panic(p)
+		}
+	}()
+
+	data, er := Execute(ctx, req.Data, opReq, h.DB)
+
+	/* Unexpected failures are usually transient (the database went away, the client
+	 * hung up), so rather than replaying them forever, let the retry run again. */
+	codec := codecOrDefault(opReq.Codec)
+	stored := NewEncodedResponseWrapper(codec, data, er)
+
+	if stored.StatusCode == ErrCodeGeneric {
+		if er := store.Release(userGuid, req.IdempotencyKey); er != nil {
+			Logger(ctx).Error("unable to release idempotency key", "error", er)
+		}
+
+	} else if bs, er := codec.Marshal(stored); er != nil {
+		Logger(ctx).Error("unable to store response for idempotency key", "error", er)
+		store.Release(userGuid, req.IdempotencyKey)
+
+	} else if er := store.Complete(userGuid, req.IdempotencyKey, codec.ContentType(), bs); er != nil {
+		Logger(ctx).Error("unable to store response for idempotency key", "error", er)
+	}
+
+	return h.respond(ctx, data, er, opReq.Locale, opReq.Codec)
+}
+
+/* requestFingerprint hashes everything that determines what a request does: the
+ * operation, its payload and the contents of its attachments. The payload is hashed
+ * as decoded, so a retry sent in another encoding has the same fingerprint. */
+func requestFingerprint(req *RequestWrapper) (string, error) {
+	hash := sha256.New()
+
+	io.WriteString(hash, req.Operation)
+	hash.Write([]byte{0})
+
+	if len(req.RawData) > 0 {
+		payload, er := decodeGeneric(codecOrDefault(req.Codec), req.RawData)
+		if er != nil {
+			return "", er
+		}
+
+		/* encoding/json sorts map keys, so equal payloads encode the same */
+		bs, er := json.Marshal(payload)
+		if er != nil {
+			return "", er
+		}
+
+		hash.Write(bs)
+	}
+
+	names := make([]string, 0, len(req.Attachments))
+	for name := range req.Attachments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		attachment := req.Attachments[name]
+
+		hash.Write([]byte{0})
+		io.WriteString(hash, name)
+		hash.Write([]byte{0})
+		io.WriteString(hash, req.MimeTypes[name])
+		hash.Write([]byte{0})
+
+		if _, er := attachment.Seek(0, os.SEEK_SET); er != nil {
+			return "", er
+		}
+
+		if _, er := io.Copy(hash, attachment); er != nil {
+			return "", er
+		}
+
+		if _, er := attachment.Seek(0, os.SEEK_SET); er != nil {
+			return "", er
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+/* replayResponse decodes a stored envelope. A retry in the encoding the original
+ * was sent in gets exactly what was sent; otherwise the data is re-encoded. */
+func replayResponse(record *pluggable.IdempotencyRecord, codec Codec) (*ResponseWrapper, error) {
+	codec = codecOrDefault(codec)
+
+	original := CodecByContentType(record.ContentType)
+	if original == nil {
+		return nil, fmt.Errorf("stored response has unknown encoding %q", record.ContentType)
+	}
+
+	wrapper := ResponseWrapper{}
+
+	if er := original.Unmarshal(record.Response, &wrapper); er != nil {
+		return nil, er
+	}
+
+	if codec != original && wrapper.Data != nil {
+		data, er := decodeGeneric(original, wrapper.Data)
+		if er != nil {
+			return nil, er
+		}
+
+		if wrapper.Data, er = codec.Marshal(data); er != nil {
+			return nil, er
+		}
+	}
+
+	wrapper.Codec = codec
+	return &wrapper, nil
+}
+
+/* decodeGeneric decodes bs into generic values, the same whichever codec they were
+ * encoded with: integers stay integers, and strings are never left as bytes. */
+func decodeGeneric(codec Codec, bs []byte) (interface{}, error) {
+	var data interface{}
+
+	if codec == JSONCodec {
+		decoder := json.NewDecoder(bytes.NewReader(bs))
+		decoder.UseNumber()
+
+		if er := decoder.Decode(&data); er != nil {
+			return nil, er
+		}
+
+	} else if er := codec.Unmarshal(bs, &data); er != nil {
+		return nil, er
+	}
+
+	return nativeNumbers(data), nil
+}
+
+/* nativeNumbers turns the json.Numbers in a decoded value back into integers where
+ * they are integers, so that they survive being re-encoded in a binary format, and
+ * any bytes into the strings JSON would have held. */
+func nativeNumbers(value interface{}) interface{} {
+	switch val := value.(type) {
+	case json.Number:
+		if i, er := val.Int64(); er == nil {
+			return i
+		}
+
+		f, _ := val.Float64()
+		return f
+
+	case []byte:
+		/* The binary codecs send GUIDs as their 16 bytes, and JSON as hex */
+		if len(val) == 16 {
+			return strings.ToUpper(hex.EncodeToString(val))
+		}
+
+		return string(val)
+
+	case []interface{}:
+		for idx := range val {
+			val[idx] = nativeNumbers(val[idx])
+		}
+
+	case map[string]interface{}:
+		for k := range val {
+			val[k] = nativeNumbers(val[k])
+		}
+	}
+
+	return value
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"macrobooru/api/pluggable/idempotencystores/memory"
+	"macrobooru/models"
+)
+
+type countPayload struct {
+	Label string `json:"label"`
+}
+
+var countExecutions int
+
+func (*countPayload) Name() string {
+	return "test_count"
+}
+
+func (*countPayload) Parse(req *RequestWrapper) (Operation, error) {
+	var payload countPayload
+
+	if er := req.UnmarshalData(&payload); er != nil {
+		return nil, ErrorInvalidInputFormat(er.Error())
+	}
+
+	return &payload, nil
+}
+
+func (payload *countPayload) Execute(ctx context.Context, req *Request, db Queryer) (interface{}, error) {
+	if payload.Label == "panic" {
+		panic("test_count panicked")
+	}
+
+	countExecutions += 1
+	return countExecutions, nil
+}
+
+func (*countPayload) ParseResponse(wrapper ResponseWrapper) (interface{}, error) {
+	var res int
+	er := wrapper.UnmarshalData(&res)
+	return res, er
+}
+
+func init() {
+	RegisterOperation(&countPayload{})
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	handler := &Handler{Idempotency: memory.NewIdempotencyStore()}
+	user := &models.User{Pid: models.NewGUID()}
+
+	send := func(label, key string, codec Codec) *ResponseWrapper {
+		/* The request is sent in the encoding the response is wanted in */
+		payload, er := codecOrDefault(codec).Marshal(map[string]interface{}{"label": label})
+		if er != nil {
+			t.Fatal(er)
+		}
+
+		req := &RequestWrapper{
+			Operation:      "test_count",
+			RawData:        RawMessage(payload),
+			Codec:          codec,
+			IdempotencyKey: key,
+		}
+
+		if er := req.Parse(); er != nil {
+			t.Fatal(er)
+		}
+
+		opReq := &Request{User: user, Locale: DefaultLocale, Codec: codec}
+		return handler.executeIdempotently(context.Background(), req, opReq)
+	}
+
+	countExecutions = 0
+
+	first := send("a", "key-1", JSONCodec)
+	if string(first.Data) != "1" {
+		t.Fatalf("unexpected first response %#v", first)
+	}
+
+	/* A retry gets the original response back, even in another encoding */
+	retry := send("a", "key-1", MsgpackCodec)
+	if er := retry.Err(); er != nil {
+		t.Fatal(er)
+	}
+
+	var count int
+	if er := retry.UnmarshalData(&count); er != nil || count != 1 || countExecutions != 1 {
+		t.Fatalf("retry ran again (count %d, executions %d, %v)", count, countExecutions, er)
+	}
+
+	reused := send("b", "key-1", JSONCodec)
+	if reused.StatusCode != ErrCodeIdempotencyKeyReused || countExecutions != 1 {
+		t.Fatalf("reusing a key with a different payload was not rejected: %#v", reused)
+	}
+
+	other := send("b", "key-2", CBORCodec)
+	if er := other.UnmarshalData(&count); er != nil || count != 2 {
+		t.Fatalf("a new key did not run: %#v", other)
+	}
+
+	/* A retry in the original encoding gets exactly what was sent the first time */
+	again := send("b", "key-2", CBORCodec)
+	if !bytes.Equal(again.Data, other.Data) || countExecutions != 2 {
+		t.Fatalf("replay differs from the original: %x, %x", again.Data, other.Data)
+	}
+
+	/* A panic frees the key, so a retry runs (and panics) again instead of being
+	 * told the original is still in progress */
+	for attempt := 1; attempt <= 2; attempt += 1 {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("attempt %d did not run", attempt)
+				}
+			}()
+
+			send("panic", "key-3", JSONCodec)
+		}()
+	}
+}
+
+func TestIdempotencyRequiresUser(t *testing.T) {
+	handler := &Handler{Idempotency: memory.NewIdempotencyStore()}
+
+	req := &RequestWrapper{
+		Operation:      "test_count",
+		RawData:        RawMessage(`{}`),
+		IdempotencyKey: "key",
+	}
+
+	if er := req.Parse(); er != nil {
+		t.Fatal(er)
+	}
+
+	wrapper := handler.executeIdempotently(context.Background(), req, &Request{})
+	if wrapper.StatusCode != ErrCodeRequiresAuthentication {
+		t.Fatalf("anonymous idempotent request was not rejected: %#v", wrapper)
+	}
+}
@@ -1,7 +1,11 @@
 package api
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"mime/multipart"
+
 	"macrobooru/models"
 )
 
@@ -12,9 +16,99 @@ type Operation interface {
 	// Parses the wrapper's .RawData into an an Operation instance.
 	Parse(*RequestWrapper) (Operation, error)
 
-	// Runs the operation with the specified (potentially nil) user and database.
-	Process(*models.User, *sql.DB) (interface{}, error)
-
 	// Parses the wrapper's .Data into an operation-specific format.
 	ParseResponse(ResponseWrapper) (interface{}, error)
 }
+
+// Executor is implemented by operations which run with access to the request
+// they were sent in. Executors must only touch the database through db, which
+// may be a transaction shared with other operations.
+type Executor interface {
+	// Runs the operation. ctx is cancelled if the client goes away.
+	Execute(ctx context.Context, req *Request, db Queryer) (interface{}, error)
+}
+
+// Processor is the original execution interface, which only sees the (potentially
+// nil) user and database. Operations implementing it are run through AdaptProcessor.
+type Processor interface {
+	Process(*models.User, *sql.DB) (interface{}, error)
+}
+
+// Queryer is the subset of *sql.DB used by operations; *sql.Tx satisfies it too.
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Request carries the request-scoped state an operation is executed with.
+type Request struct {
+	// User is the authenticated user, or nil if no token was sent.
+	User *models.User
+
+	// SessionKey is the token the request was authenticated with, if any.
+	SessionKey string
+
+	// Attachments and MimeTypes are keyed on the Content-ID of each part of a
+	// multipart request.
+	Attachments map[string]multipart.File
+	MimeTypes   map[string]string
+
+	RemoteAddr string
+	RequestID  string
+
+	// Locale is the language status messages should be written in.
+	Locale string
+
+	// Codec is the encoding the response is sent in.
+	Codec Codec
+
+	// Version is the version of the API the request was made in.
+	Version int
+}
+
+/* Execute runs op against req, through the global middleware and whatever middleware
+ * op was registered with. Operations which only implement the older Processor
+ * interface are adapted on the fly. */
+func Execute(ctx context.Context, op Operation, req *Request, db Queryer) (interface{}, error) {
+	var execute ExecuteFunc
+
+	if executor, ok := op.(Executor); ok {
+		execute = executor.Execute
+
+	} else if processor, ok := op.(Processor); ok {
+		execute = AdaptProcessor(processor).Execute
+
+	} else {
+		return nil, ErrorGeneric(fmt.Errorf("operation %s cannot be executed", op.Name()))
+	}
+
+	chain := middlewareFor(op, req.Version)
+	for i := len(chain) - 1; i >= 0; i -= 1 {
+		execute = chain[i](op, execute)
+	}
+
+	return execute(ctx, req, db)
+}
+
+func AdaptProcessor(processor Processor) Executor {
+	return &processorAdapter{processor}
+}
+
+type processorAdapter struct {
+	processor Processor
+}
+
+func (adapter *processorAdapter) Execute(ctx context.Context, req *Request, db Queryer) (interface{}, error) {
+	/* Processors predate cancellation, so the best we can do is not start them */
+	if er := ctx.Err(); er != nil {
+		return nil, ErrorGeneric(er)
+	}
+
+	sqlDB, ok := db.(*sql.DB)
+	if db != nil && !ok {
+		return nil, ErrorGeneric(fmt.Errorf("%T cannot be run against a %T", adapter.processor, db))
+	}
+
+	return adapter.processor.Process(req.User, sqlDB)
+}
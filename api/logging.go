@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+/* RequestIDHeader carries the request ID: a client may send one to correlate our
+ * logs with its own, and every response echoes the one that was used. */
+const RequestIDHeader = "X-Request-ID"
+
+/* Client-supplied IDs longer than this are replaced, so they can't bloat the logs. */
+const maxRequestIDLength = 64
+
+var logger atomic.Pointer[slog.Logger]
+
+/* SetLogger replaces the logger everything in the API logs through. The level of
+ * its handler decides whether queries (logged at debug) are written; nil restores
+ * slog.Default(). */
+func SetLogger(l *slog.Logger) {
+	logger.Store(l)
+}
+
+type requestIDKey struct{}
+
+/* WithRequestID tags ctx with the ID of the request it's serving, which Logger then
+ * adds to every line. */
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+/* Logger returns the logger to use while serving ctx, tagged with its request ID. */
+func Logger(ctx context.Context) *slog.Logger {
+	l := logger.Load()
+	if l == nil {
+		l = slog.Default()
+	}
+
+	if id := RequestIDFromContext(ctx); id != "" {
+		return l.With("requestId", id)
+	}
+
+	return l
+}
+
+/* LogQuery logs a SQL statement at debug level. Only the types of the arguments are
+ * written, since they're frequently user data (emails, password hashes, ...). */
+func LogQuery(ctx context.Context, query string, args []interface{}) {
+	l := Logger(ctx)
+
+	if !l.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+
+	l.Debug("query", "sql", query, "args", RedactArgs(args))
+}
+
+/* RedactArgs describes query arguments without revealing them. */
+func RedactArgs(args []interface{}) []string {
+	redacted := make([]string, len(args))
+
+	for idx, arg := range args {
+		if arg == nil {
+			redacted[idx] = "NULL"
+		} else {
+			redacted[idx] = fmt.Sprintf("<%T>", arg)
+		}
+	}
+
+	return redacted
+}
+
+func newRequestID() string {
+	bs := make([]byte, 8)
+
+	if _, er := rand.Read(bs); er != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(bs)
+}
+
+/* requestIDFor picks the ID for an HTTP request: the one the client sent, if it's
+ * reasonable, or a fresh one. */
+func requestIDFor(r *http.Request) string {
+	id := r.Header.Get(RequestIDHeader)
+
+	if id == "" || len(id) > maxRequestIDLength {
+		return newRequestID()
+	}
+
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return newRequestID()
+		}
+	}
+
+	return id
+}
+
+/* accessEntry collects what the access log says about a request as it's served. */
+type accessEntry struct {
+	requestID string
+	operation string
+	user      string
+	status    int64
+	bytes     int
+}
+
+func (entry *accessEntry) log(ctx context.Context, r *http.Request, start time.Time) {
+	Logger(ctx).Info("access",
+		"requestId", entry.requestID,
+		"remoteAddr", r.RemoteAddr,
+		"method", r.Method,
+		"path", r.URL.Path,
+		"operation", entry.operation,
+		"user", entry.user,
+		"status", ErrorKey(entry.status),
+		"bytes", entry.bytes,
+		"duration", time.Since(start),
+	)
+}
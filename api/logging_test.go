@@ -0,0 +1,83 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDEchoed(t *testing.T) {
+	body := `{ "operation" : "test_echo", "data" : { "value" : "hello" } }`
+
+	for _, sent := range []string{"", "abc-123", strings.Repeat("x", maxRequestIDLength+1), "bad id"} {
+		req, _ := http.NewRequest("POST", "/v2/api", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if sent != "" {
+			req.Header.Set(RequestIDHeader, sent)
+		}
+
+		recorder := httptest.NewRecorder()
+		NewHandler(nil).ServeHTTP(recorder, req)
+
+		var wrapper ResponseWrapper
+		if er := json.Unmarshal(recorder.Body.Bytes(), &wrapper); er != nil {
+			t.Fatal(er)
+		}
+
+		header := recorder.Header().Get(RequestIDHeader)
+		if header == "" || header != wrapper.RequestID {
+			t.Errorf("%q: header %q doesn't match envelope %q", sent, header, wrapper.RequestID)
+		}
+
+		if sent == "abc-123" && header != sent {
+			t.Errorf("expected the client's request ID to be kept, got %q", header)
+
+		} else if sent != "abc-123" && header == sent {
+			t.Errorf("expected %q to be replaced", sent)
+		}
+	}
+}
+
+func TestAccessLogAndRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	SetLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer SetLogger(nil)
+
+	ctx := WithRequestID(t.Context(), "req-1")
+	LogQuery(ctx, "SELECT * FROM User WHERE email = $1", []interface{}{"secret@example.com", nil})
+
+	if strings.Contains(buf.String(), "secret@example.com") {
+		t.Fatalf("query arguments were logged: %s", buf.String())
+	}
+
+	if !strings.Contains(buf.String(), `"requestId":"req-1"`) || !strings.Contains(buf.String(), `"<string>"`) {
+		t.Fatalf("unexpected query log: %s", buf.String())
+	}
+
+	buf.Reset()
+	serveTestRequest(t, `{ "operation" : "test_echo", "data" : { "value" : "hello" } }`)
+
+	accessLines := 0
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		if er := json.Unmarshal([]byte(line), &entry); er != nil {
+			t.Fatal(er)
+		}
+
+		if entry["msg"] == "access" {
+			accessLines += 1
+
+			if entry["operation"] != "test_echo" || entry["status"] != ErrorKey(ErrCodeNoError) {
+				t.Errorf("unexpected access log entry %v", entry)
+			}
+		}
+	}
+
+	if accessLines != 1 {
+		t.Errorf("expected one access log line, got %d:\n%s", accessLines, buf.String())
+	}
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const DefaultLocale = "en"
+
+/* messageCatalog holds the human-readable statusMsg for every status code, per
+ * locale. `{0}`, `{1}`, ... are replaced with the corresponding statusArgs. Every
+ * code must have an entry for DefaultLocale; other locales fall back to it for
+ * anything they don't translate. */
+var messageCatalog = map[string]map[int64]string{
+	"en": {
+		ErrCodeNoError:                "ok",
+		ErrCodeGeneric:                "An unexpected error occurred on the server",
+		ErrCodeInvalidInputFormat:     "The request was malformed: {0}",
+		ErrCodeMissingInputField:      "The required field '{0}' is missing",
+		ErrCodeInvalidInputField:      "The field '{0}' has an invalid value",
+		ErrCodeObjectNotFound:         "The object '{0}' does not exist",
+		ErrCodeRequiresAuthentication: "You must be logged in to do that",
+		ErrCodeInvalidCredentials:     "The username or password is incorrect",
+		ErrCodeInvalidToken:           "Your session has expired; please log in again",
+		ErrCodeConversionFailure:      "The file could not be converted",
+		ErrCodeFileNotFound:           "The file does not exist",
+		ErrCodeInvalidFileType:        "The file is not of an accepted type",
+		ErrCodeVerificationEmailSent:  "A verification email has been sent",
+		ErrCodeUsernameAlreadyExists:  "The username '{0}' is already taken",
+		ErrCodeEmailAlreadyExists:     "The email address '{0}' is already registered",
+		ErrCodeAuthenticationFailure:  "Authentication failed",
+		ErrCodeAccountNotVerified:     "This account has not been verified yet",
+		ErrCodeRequiresAdmin:          "Only administrators can do that",
+		ErrCodeInvalidNonce:           "The code is invalid or has expired",
+		ErrCodePermissionDenied:       "You do not have permission to modify '{0}'",
+		ErrCodeAlreadyDeleted:         "The object has already been deleted",
+		ErrCodeUserNotFound:           "The user does not exist",
+		ErrCodeSphinxSyntaxError:      "The search query could not be understood",
+		ErrCodeSphinxOtherError:       "The search could not be completed",
+		ErrCodeRateLimitExceeded:      "Too many requests; please try again later",
+		ErrCodeIdempotencyKeyReused:   "The idempotency key '{0}' was already used for a different request",
+		ErrCodeRequestInProgress:      "The original request is still being processed; please try again later",
+		ErrCodeAttachmentTooLarge:     "The attachment '{0}' is larger than the limit of {1} bytes",
+		ErrCodeTooManySubscriptions:   "No more than {0} subscriptions may be open at once",
+		ErrCodeNotRun:                 "Not run, because the batch stopped at operation {0}",
+		ErrCodeRolledBack:             "Not committed, because the batch stopped at operation {0}",
+	},
+
+	"es": {
+		ErrCodeNoError:                "ok",
+		ErrCodeGeneric:                "Se produjo un error inesperado en el servidor",
+		ErrCodeInvalidInputFormat:     "La solicitud tiene un formato incorrecto: {0}",
+		ErrCodeMissingInputField:      "Falta el campo obligatorio '{0}'",
+		ErrCodeInvalidInputField:      "El campo '{0}' tiene un valor no válido",
+		ErrCodeObjectNotFound:         "El objeto '{0}' no existe",
+		ErrCodeRequiresAuthentication: "Debe iniciar sesión para hacer eso",
+		ErrCodeInvalidCredentials:     "El nombre de usuario o la contraseña son incorrectos",
+		ErrCodeInvalidToken:           "Su sesión ha caducado; vuelva a iniciar sesión",
+		ErrCodeConversionFailure:      "No se pudo convertir el archivo",
+		ErrCodeFileNotFound:           "El archivo no existe",
+		ErrCodeInvalidFileType:        "El tipo de archivo no es válido",
+		ErrCodeVerificationEmailSent:  "Se ha enviado un correo de verificación",
+		ErrCodeUsernameAlreadyExists:  "El nombre de usuario '{0}' ya está en uso",
+		ErrCodeEmailAlreadyExists:     "La dirección de correo '{0}' ya está registrada",
+		ErrCodeAuthenticationFailure:  "Error de autenticación",
+		ErrCodeAccountNotVerified:     "Esta cuenta aún no ha sido verificada",
+		ErrCodeRequiresAdmin:          "Solo los administradores pueden hacer eso",
+		ErrCodeInvalidNonce:           "El código no es válido o ha caducado",
+		ErrCodePermissionDenied:       "No tiene permiso para modificar '{0}'",
+		ErrCodeAlreadyDeleted:         "El objeto ya ha sido eliminado",
+		ErrCodeUserNotFound:           "El usuario no existe",
+		ErrCodeSphinxSyntaxError:      "No se pudo interpretar la búsqueda",
+		ErrCodeSphinxOtherError:       "No se pudo completar la búsqueda",
+		ErrCodeRateLimitExceeded:      "Demasiadas solicitudes; inténtelo más tarde",
+		ErrCodeIdempotencyKeyReused:   "La clave de idempotencia '{0}' ya se usó para otra solicitud",
+		ErrCodeRequestInProgress:      "La solicitud original aún se está procesando; inténtelo más tarde",
+		ErrCodeAttachmentTooLarge:     "El adjunto '{0}' supera el límite de {1} bytes",
+		ErrCodeTooManySubscriptions:   "No se pueden tener más de {0} suscripciones abiertas a la vez",
+		ErrCodeNotRun:                 "No se ejecutó porque el lote se detuvo en la operación {0}",
+		ErrCodeRolledBack:             "No se confirmó porque el lote se detuvo en la operación {0}",
+	},
+}
+
+/* Message renders the statusMsg for code in the given locale. */
+func Message(locale string, code int64, args []interface{}) string {
+	template, ok := messageCatalog[supportedLocale(locale)][code]
+	if !ok {
+		if template, ok = messageCatalog[DefaultLocale][code]; !ok {
+			return ErrorKey(code)
+		}
+	}
+
+	for idx, arg := range args {
+		template = strings.Replace(template, "{"+strconv.Itoa(idx)+"}", fmt.Sprint(arg), -1)
+	}
+
+	return template
+}
+
+/* supportedLocale maps a language tag onto a locale in the catalog, trying the
+ * tag itself and then its primary language (so en-GB becomes en). Returns "" if
+ * neither is supported. */
+func supportedLocale(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+
+	if _, ok := messageCatalog[tag]; ok {
+		return tag
+	}
+
+	if idx := strings.IndexAny(tag, "-_"); idx > 0 {
+		if _, ok := messageCatalog[tag[:idx]]; ok {
+			return tag[:idx]
+		}
+	}
+
+	return ""
+}
+
+/* NegotiateLocale picks the locale to answer in. An explicit locale sent in the
+ * request wins; otherwise the Accept-Language header is consulted, falling back to
+ * DefaultLocale. */
+func NegotiateLocale(requested, acceptLanguage string) string {
+	if locale := supportedLocale(requested); locale != "" {
+		return locale
+	}
+
+	type candidate struct {
+		tag     string
+		quality float64
+	}
+
+	candidates := []candidate{}
+
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(part, ";")
+		c := candidate{
+			tag:     strings.TrimSpace(fields[0]),
+			quality: 1,
+		}
+
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+
+			if strings.HasPrefix(param, "q=") {
+				if q, er := strconv.ParseFloat(param[2:], 64); er == nil {
+					c.quality = q
+				}
+			}
+		}
+
+		if c.tag != "" && c.quality > 0 {
+			candidates = append(candidates, c)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].quality > candidates[j].quality
+	})
+
+	for _, c := range candidates {
+		if locale := supportedLocale(c.tag); locale != "" {
+			return locale
+		}
+	}
+
+	return DefaultLocale
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+/* errorCodes parses errors.go for every ErrCode* constant, so that adding a code
+ * without a message fails this test rather than silently falling back to the key. */
+func errorCodes(t *testing.T) map[string]int64 {
+	file, er := parser.ParseFile(token.NewFileSet(), "errors.go", nil, 0)
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	codes := map[string]int64{}
+
+	for _, decl := range file.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.CONST {
+			continue
+		}
+
+		for _, spec := range genDecl.Specs {
+			valueSpec := spec.(*ast.ValueSpec)
+
+			for idx, name := range valueSpec.Names {
+				if !strings.HasPrefix(name.Name, "ErrCode") {
+					continue
+				}
+
+				lit, ok := valueSpec.Values[idx].(*ast.BasicLit)
+				if !ok {
+					t.Fatalf("%s is not a literal", name.Name)
+				}
+
+				value, er := strconv.ParseInt(lit.Value, 0, 64)
+				if er != nil {
+					t.Fatal(er)
+				}
+
+				codes[name.Name] = value
+			}
+		}
+	}
+
+	if len(codes) == 0 {
+		t.Fatalf("no error codes found in errors.go")
+	}
+
+	return codes
+}
+
+func TestCatalogCoversEveryCode(t *testing.T) {
+	for name, code := range errorCodes(t) {
+		if _, ok := messageCatalog[DefaultLocale][code]; !ok {
+			t.Errorf("%s has no %s message", name, DefaultLocale)
+		}
+
+		if _, ok := errorKeys[code]; !ok {
+			t.Errorf("%s has no status key", name)
+		}
+	}
+}
+
+func TestPredicateForEveryCode(t *testing.T) {
+	file, er := parser.ParseFile(token.NewFileSet(), "errors.go", nil, 0)
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	predicates := map[string]bool{}
+
+	for _, decl := range file.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Recv == nil {
+			predicates[funcDecl.Name.Name] = true
+		}
+	}
+
+	for name := range errorCodes(t) {
+		if name == "ErrCodeNoError" {
+			continue
+		}
+
+		if predicate := "Is" + strings.TrimPrefix(name, "ErrCode"); !predicates[predicate] {
+			t.Errorf("%s has no %s", name, predicate)
+		}
+	}
+}
+
+func TestMessageArgs(t *testing.T) {
+	msg := Message("en", ErrCodeInvalidInputField, []interface{}{"title"})
+
+	if msg != "The field 'title' has an invalid value" {
+		t.Fatalf("unexpected message %q", msg)
+	}
+}
+
+func TestNegotiateLocale(t *testing.T) {
+	cases := []struct {
+		requested      string
+		acceptLanguage string
+		expected       string
+	}{
+		{"", "", "en"},
+		{"", "es-MX,es;q=0.9,en;q=0.8", "es"},
+		{"", "de;q=0.9,en;q=0.5,es;q=0.7", "es"},
+		{"", "de, fr", "en"},
+		{"en", "es", "en"},
+		{"xx", "es", "es"},
+	}
+
+	for _, c := range cases {
+		if locale := NegotiateLocale(c.requested, c.acceptLanguage); locale != c.expected {
+			t.Errorf("NegotiateLocale(%q, %q) = %q, expected %q", c.requested, c.acceptLanguage, locale, c.expected)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// ExecuteFunc is the signature of an operation's execution, possibly already
+// wrapped in middleware.
+type ExecuteFunc func(ctx context.Context, req *Request, db Queryer) (interface{}, error)
+
+// Middleware wraps the execution of op. It may short-circuit by returning an error
+// without calling next.
+type Middleware func(op Operation, next ExecuteFunc) ExecuteFunc
+
+// RequiresAuth rejects requests which weren't sent with a valid token.
+func RequiresAuth(op Operation, next ExecuteFunc) ExecuteFunc {
+	return func(ctx context.Context, req *Request, db Queryer) (interface{}, error) {
+		if req.User == nil {
+			return nil, ErrorRequiresAuthentication()
+		}
+
+		return next(ctx, req, db)
+	}
+}
+
+// RequiresAdmin rejects requests which weren't sent by an administrator.
+func RequiresAdmin(op Operation, next ExecuteFunc) ExecuteFunc {
+	return func(ctx context.Context, req *Request, db Queryer) (interface{}, error) {
+		if req.User == nil {
+			return nil, ErrorRequiresAuthentication()
+		}
+
+		if req.User.IsAdmin == 0 {
+			return nil, ErrorRequiresAdmin()
+		}
+
+		return next(ctx, req, db)
+	}
+}
+
+// Timeout cancels the context of an operation which runs for longer than limit.
+func Timeout(limit time.Duration) Middleware {
+	return func(op Operation, next ExecuteFunc) ExecuteFunc {
+		return func(ctx context.Context, req *Request, db Queryer) (interface{}, error) {
+			ctx, cancel := context.WithTimeout(ctx, limit)
+			defer cancel()
+
+			return next(ctx, req, db)
+		}
+	}
+}
+
+// Logging logs the outcome and duration of every operation it wraps.
+func Logging(op Operation, next ExecuteFunc) ExecuteFunc {
+	return func(ctx context.Context, req *Request, db Queryer) (interface{}, error) {
+		start := time.Now()
+		res, er := next(ctx, req, db)
+
+		if er != nil {
+			Logger(ctx).Warn("operation failed", "operation", op.Name(), "duration", time.Since(start), "error", er)
+		} else {
+			Logger(ctx).Info("operation completed", "operation", op.Name(), "duration", time.Since(start))
+		}
+
+		return res, er
+	}
+}
+
+/* RateLimit allows each caller at most count executions per window, after which
+ * ErrorRateLimitExceeded is returned until the window rolls over. Callers are
+ * identified by user if authenticated, and by remote address otherwise. The limit
+ * is tracked separately for each place the middleware is installed. */
+func RateLimit(count int, window time.Duration) Middleware {
+	limiter := &rateLimiter{
+		count:   count,
+		window:  window,
+		windows: make(map[string]*rateWindow),
+	}
+
+	return func(op Operation, next ExecuteFunc) ExecuteFunc {
+		return func(ctx context.Context, req *Request, db Queryer) (interface{}, error) {
+			if !limiter.allow(rateLimitKey(req), time.Now()) {
+				return nil, ErrorRateLimitExceeded()
+			}
+
+			return next(ctx, req, db)
+		}
+	}
+}
+
+func rateLimitKey(req *Request) string {
+	if req.User != nil {
+		return "user:" + req.User.Pid.String()
+	}
+
+	if host, _, er := net.SplitHostPort(req.RemoteAddr); er == nil {
+		return "addr:" + host
+	}
+
+	return "addr:" + req.RemoteAddr
+}
+
+type rateWindow struct {
+	start time.Time
+	used  int
+}
+
+type rateLimiter struct {
+	count  int
+	window time.Duration
+
+	lock    sync.Mutex
+	windows map[string]*rateWindow
+	pruned  time.Time
+}
+
+func (limiter *rateLimiter) allow(key string, now time.Time) bool {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	current, ok := limiter.windows[key]
+	if !ok || now.Sub(current.start) >= limiter.window {
+		limiter.prune(now)
+
+		current = &rateWindow{start: now}
+		limiter.windows[key] = current
+	}
+
+	if current.used >= limiter.count {
+		return false
+	}
+
+	current.used += 1
+	return true
+}
+
+/* prune drops expired windows so the map doesn't grow with every address ever seen.
+ * It only bothers once per window, since nothing can expire more often than that. */
+func (limiter *rateLimiter) prune(now time.Time) {
+	if now.Sub(limiter.pruned) < limiter.window {
+		return
+	}
+	limiter.pruned = now
+
+	for key, window := range limiter.windows {
+		if now.Sub(window.start) >= limiter.window {
+			delete(limiter.windows, key)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"macrobooru/models"
+)
+
+type guardedPayload struct {
+	echoPayload
+}
+
+func (*guardedPayload) Name() string {
+	return "test_guarded"
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	RegisterOperation(&guardedPayload{}, RequiresAdmin)
+
+	op := &guardedPayload{echoPayload{Value: "hello"}}
+	ctx := context.Background()
+
+	cases := []struct {
+		user *models.User
+		code int64
+	}{
+		{nil, ErrCodeRequiresAuthentication},
+		{&models.User{IsAdmin: 0}, ErrCodeRequiresAdmin},
+		{&models.User{IsAdmin: 1}, ErrCodeNoError},
+	}
+
+	for _, c := range cases {
+		_, er := Execute(ctx, op, &Request{User: c.user}, nil)
+
+		code := int64(ErrCodeNoError)
+		if apiEr, ok := er.(*ApiError); ok {
+			code = apiEr.Code()
+		}
+
+		if code != c.code {
+			t.Errorf("user %#v: expected %d, got %d (%v)", c.user, c.code, code, er)
+		}
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	order := []string{}
+
+	tracer := func(name string) Middleware {
+		return func(op Operation, next ExecuteFunc) ExecuteFunc {
+			return func(ctx context.Context, req *Request, db Queryer) (interface{}, error) {
+				order = append(order, name)
+				return next(ctx, req, db)
+			}
+		}
+	}
+
+	operationRegistryLock.Lock()
+	saved := globalMiddleware
+	globalMiddleware = nil
+	operationRegistryLock.Unlock()
+
+	defer func() {
+		operationRegistryLock.Lock()
+		globalMiddleware = saved
+		operationRegistryLock.Unlock()
+	}()
+
+	Use(tracer("global1"), tracer("global2"))
+	RegisterOperation(&echoPayload{}, tracer("local"))
+	defer RegisterOperation(&echoPayload{})
+
+	if _, er := Execute(context.Background(), &echoPayload{}, &Request{}, nil); er != nil {
+		t.Fatal(er)
+	}
+
+	if len(order) != 3 || order[0] != "global1" || order[1] != "global2" || order[2] != "local" {
+		t.Fatalf("middleware ran in the wrong order: %v", order)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := &rateLimiter{
+		count:   2,
+		window:  time.Minute,
+		windows: make(map[string]*rateWindow),
+	}
+
+	now := time.Now()
+
+	if !limiter.allow("a", now) || !limiter.allow("a", now) {
+		t.Fatalf("first two calls should be allowed")
+	}
+
+	if limiter.allow("a", now) {
+		t.Fatalf("third call inside the window should be refused")
+	}
+
+	if !limiter.allow("b", now) {
+		t.Fatalf("limits should be tracked per key")
+	}
+
+	if !limiter.allow("a", now.Add(time.Minute)) {
+		t.Fatalf("limit should reset once the window rolls over")
+	}
+}
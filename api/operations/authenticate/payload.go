@@ -2,9 +2,8 @@ package authenticate
 
 import (
 	"macrobooru/api"
-	"macrobooru/models"
 
-	"database/sql"
+	"context"
 )
 
 type AuthPayload struct {
@@ -36,7 +35,7 @@ func (ap *AuthPayload) ParseResponse(resWrapper api.ResponseWrapper) (interface{
 	return ParseResponse(resWrapper)
 }
 
-func (ap *AuthPayload) Process(u *models.User, db *sql.DB) (interface{}, error) {
+func (ap *AuthPayload) Execute(ctx context.Context, req *api.Request, db api.Queryer) (interface{}, error) {
 	return AuthResponse{
 		Token: string(""),
 	}, nil
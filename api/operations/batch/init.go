@@ -0,0 +1,9 @@
+package batch
+
+import (
+	"macrobooru/api"
+)
+
+func init() {
+	api.RegisterOperation(&BatchPayload{})
+}
@@ -0,0 +1,211 @@
+package batch
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/ugorji/go/codec"
+
+	"macrobooru/api"
+)
+
+type BatchEntry struct {
+	Operation string         `json:"operation"`
+	RawData   api.RawMessage `json:"data"`
+	Data      api.Operation  `json:"-"`
+}
+
+/* BatchPayload runs several operations in one round trip. They share the token and
+ * attachments of the enclosing request, and are run in the order given. */
+type BatchPayload struct {
+	Operations []BatchEntry `json:"operations"`
+
+	// StopOnError skips the remaining operations once one of them fails.
+	StopOnError bool `json:"stopOnError,omitempty"`
+}
+
+func (entry *BatchEntry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"operation": entry.Operation,
+		"data":      entry.Data,
+	})
+}
+
+type rawBatchEntry struct {
+	Operation string         `codec:"operation"`
+	RawData   api.RawMessage `codec:"data"`
+}
+
+func (entry *BatchEntry) CodecEncodeSelf(e *codec.Encoder) {
+	e.MustEncode(map[string]interface{}{
+		"operation": entry.Operation,
+		"data":      entry.Data,
+	})
+}
+
+func (entry *BatchEntry) CodecDecodeSelf(d *codec.Decoder) {
+	var raw rawBatchEntry
+	d.MustDecode(&raw)
+
+	entry.Operation = raw.Operation
+	entry.RawData = raw.RawData
+}
+
+func (*BatchPayload) Name() string {
+	return "batch"
+}
+
+func (*BatchPayload) Parse(req *api.RequestWrapper) (api.Operation, error) {
+	var payload BatchPayload
+
+	if er := req.UnmarshalData(&payload); er != nil {
+		return nil, api.ErrorInvalidInputFormat(er.Error())
+	}
+
+	for idx := range payload.Operations {
+		entry := &payload.Operations[idx]
+
+		if entry.Operation == payload.Name() {
+			return nil, api.ErrorInvalidInputFormat("batches cannot be nested")
+		}
+
+		sub := api.RequestWrapper{
+			Operation:   entry.Operation,
+			AuthToken:   req.AuthToken,
+			RawData:     entry.RawData,
+			Codec:       req.Codec,
+			Version:     req.Version,
+			Attachments: req.Attachments,
+			MimeTypes:   req.MimeTypes,
+		}
+
+		if er := sub.Parse(); er != nil {
+			return nil, er
+		}
+
+		entry.Data = sub.Data
+	}
+
+	return &payload, nil
+}
+
+func (*BatchPayload) ParseResponse(wrapper api.ResponseWrapper) (interface{}, error) {
+	res := BatchResponse{}
+
+	if er := wrapper.UnmarshalData(&res); er != nil {
+		return nil, api.ErrorGeneric(er)
+	}
+
+	for idx := range res {
+		res[idx].Codec = wrapper.Codec
+	}
+
+	return res, nil
+}
+
+/* transactional reports whether every operation in the batch can share a single
+ * transaction. Operations that still implement the old Processor interface want a
+ * *sql.DB of their own, so they can't. */
+func (payload *BatchPayload) transactional() bool {
+	for _, entry := range payload.Operations {
+		if _, ok := entry.Data.(api.Executor); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+/* Execute runs the batch. If every operation supports it, the whole batch runs in
+ * one transaction and is all-or-nothing: the first failure rolls everything back,
+ * the operations before it are reported as rolled back, and those after it are
+ * skipped whatever StopOnError says, since they couldn't be committed. Otherwise
+ * each operation commits on its own. Either way the response holds an envelope
+ * for every operation. */
+func (payload *BatchPayload) Execute(ctx context.Context, req *api.Request, db api.Queryer) (interface{}, error) {
+	if !payload.transactional() {
+		results, _ := payload.run(ctx, req, db, payload.StopOnError)
+		return results, nil
+	}
+
+	var results BatchResponse
+	var failed error
+
+	er := api.WithTransaction(ctx, db, func(tx api.Queryer) error {
+		results, failed = payload.run(ctx, req, tx, true)
+		return failed
+	})
+
+	/* A failed operation has its own envelope; anything else went wrong with the
+	 * transaction itself */
+	if er != nil && er != failed {
+		return nil, er
+	}
+
+	if failed != nil {
+		payload.rollBack(results, req)
+	}
+
+	return results, nil
+}
+
+/* rollBack replaces the envelopes of the operations that succeeded before a
+ * transactional batch stopped, as their changes weren't committed. */
+func (payload *BatchPayload) rollBack(results BatchResponse, req *api.Request) {
+	stoppedAt := 0
+	for stoppedAt < len(results) && results[stoppedAt].StatusCode == api.ErrCodeNoError {
+		stoppedAt += 1
+	}
+
+	for idx := 0; idx < stoppedAt; idx += 1 {
+		wrapper := api.NewEncodedResponseWrapper(req.Codec, nil, api.ErrorRolledBack(stoppedAt))
+		wrapper.Localize(req.Locale)
+		results[idx] = *wrapper
+	}
+}
+
+/* run executes the operations in order, returning an envelope for each along with
+ * the first error encountered. Operations skipped once stopOnError stops the batch,
+ * or once ctx is cancelled, are given a not_run envelope. */
+func (payload *BatchPayload) run(ctx context.Context, req *api.Request, db api.Queryer, stopOnError bool) (BatchResponse, error) {
+	results := BatchResponse{}
+	var firstEr error
+
+	for idx, entry := range payload.Operations {
+		if er := ctx.Err(); er != nil {
+			return payload.notRun(results, req, idx, idx), api.ErrorGeneric(er)
+		}
+
+		data, er := api.Execute(ctx, entry.Data, req, db)
+
+		wrapper := api.NewEncodedResponseWrapper(req.Codec, data, er)
+		wrapper.Localize(req.Locale)
+		results = append(results, *wrapper)
+
+		if er == nil {
+			continue
+		}
+
+		if firstEr == nil {
+			firstEr = er
+		}
+
+		if stopOnError {
+			return payload.notRun(results, req, idx+1, idx), firstEr
+		}
+	}
+
+	return results, firstEr
+}
+
+/* notRun appends a not_run envelope to results for each operation from index from
+ * on, the batch having stopped at the operation at index stoppedAt. */
+func (payload *BatchPayload) notRun(results BatchResponse, req *api.Request, from, stoppedAt int) BatchResponse {
+	for range payload.Operations[from:] {
+		wrapper := api.NewEncodedResponseWrapper(req.Codec, nil, api.ErrorNotRun(stoppedAt))
+		wrapper.Localize(req.Locale)
+		results = append(results, *wrapper)
+	}
+
+	return results
+}
@@ -0,0 +1,131 @@
+package batch
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"macrobooru/api"
+	"macrobooru/models"
+)
+
+/* testOperation succeeds, or fails with er, cancelling the request if it's given
+ * cancel */
+type testOperation struct {
+	er     error
+	cancel context.CancelFunc
+}
+
+func (*testOperation) Name() string {
+	return "test_batch"
+}
+
+func (*testOperation) Parse(*api.RequestWrapper) (api.Operation, error) {
+	return nil, nil
+}
+
+func (*testOperation) ParseResponse(api.ResponseWrapper) (interface{}, error) {
+	return nil, nil
+}
+
+func (op *testOperation) Execute(context.Context, *api.Request, api.Queryer) (interface{}, error) {
+	if op.cancel != nil {
+		op.cancel()
+	}
+
+	return "done", op.er
+}
+
+/* legacyOperation only implements Processor, so a batch including it isn't run
+ * in a transaction */
+type legacyOperation struct{}
+
+func (*legacyOperation) Name() string {
+	return "test_batch_legacy"
+}
+
+func (*legacyOperation) Parse(*api.RequestWrapper) (api.Operation, error) {
+	return nil, nil
+}
+
+func (*legacyOperation) ParseResponse(api.ResponseWrapper) (interface{}, error) {
+	return nil, nil
+}
+
+func (*legacyOperation) Process(*models.User, *sql.DB) (interface{}, error) {
+	return "done", nil
+}
+
+func expectCodes(t *testing.T, results BatchResponse, codes ...int64) {
+	if len(results) != len(codes) {
+		t.Fatalf("expected %d envelopes, got %d", len(codes), len(results))
+	}
+
+	for idx, code := range codes {
+		if results[idx].StatusCode != code {
+			t.Errorf("envelope %d: expected %s, got %s", idx, api.ErrorKey(code), results[idx].StatusKey)
+		}
+	}
+}
+
+func TestTransactionalBatchFailure(t *testing.T) {
+	payload := &BatchPayload{
+		Operations: []BatchEntry{
+			{Data: &testOperation{}},
+			{Data: &testOperation{er: api.ErrorPermissionDenied("x")}},
+			{Data: &testOperation{}},
+			{Data: &testOperation{}},
+		},
+	}
+
+	res, er := payload.Execute(context.Background(), &api.Request{}, nil)
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	results := res.(BatchResponse)
+	expectCodes(t, results, api.ErrCodeRolledBack, api.ErrCodePermissionDenied, api.ErrCodeNotRun, api.ErrCodeNotRun)
+
+	/* The rolled back and skipped operations name the one that failed */
+	for _, idx := range []int{0, 3} {
+		if args := results[idx].StatusArgs; len(args) != 1 || args[0] != 1 {
+			t.Errorf("envelope %d: unexpected args %v", idx, args)
+		}
+	}
+}
+
+func TestCancelledBatch(t *testing.T) {
+	for _, transactional := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		payload := &BatchPayload{
+			Operations: []BatchEntry{
+				{Data: &testOperation{}},
+				{Data: &testOperation{cancel: cancel}},
+				{Data: &testOperation{}},
+				{Data: &testOperation{}},
+			},
+		}
+
+		if !transactional {
+			payload.Operations[3].Data = &legacyOperation{}
+		}
+
+		res, er := payload.Execute(ctx, &api.Request{}, nil)
+		if er != nil {
+			t.Fatal(er)
+		}
+
+		results := res.(BatchResponse)
+
+		if transactional {
+			expectCodes(t, results, api.ErrCodeRolledBack, api.ErrCodeRolledBack, api.ErrCodeNotRun, api.ErrCodeNotRun)
+		} else {
+			expectCodes(t, results, api.ErrCodeNoError, api.ErrCodeNoError, api.ErrCodeNotRun, api.ErrCodeNotRun)
+		}
+
+		if args := results[3].StatusArgs; len(args) != 1 || args[0] != 2 {
+			t.Errorf("unexpected args %v", args)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+package batch
+
+import (
+	"macrobooru/api"
+)
+
+/* BatchResponse holds one status envelope per executed operation, in the order
+ * they were sent. */
+type BatchResponse []api.ResponseWrapper
@@ -0,0 +1,9 @@
+package describe
+
+import (
+	"macrobooru/api"
+)
+
+func init() {
+	api.RegisterOperation(&DescribePayload{})
+}
@@ -0,0 +1,135 @@
+package describe
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"macrobooru/api"
+	"macrobooru/models"
+)
+
+/* DescribePayload takes no arguments; it documents every registered operation and
+ * every model, as they are actually implemented. */
+type DescribePayload struct{}
+
+func (*DescribePayload) Name() string {
+	return "describe"
+}
+
+func (*DescribePayload) Parse(req *api.RequestWrapper) (api.Operation, error) {
+	return &DescribePayload{}, nil
+}
+
+func (*DescribePayload) ParseResponse(wrapper api.ResponseWrapper) (interface{}, error) {
+	res := DescribeResponse{}
+
+	if er := wrapper.UnmarshalData(&res); er != nil {
+		return nil, api.ErrorGeneric(er)
+	}
+
+	return &res, nil
+}
+
+func (*DescribePayload) Execute(ctx context.Context, req *api.Request, db api.Queryer) (interface{}, error) {
+	builder := newSchemaBuilder()
+	res := &DescribeResponse{
+		Operations: []OperationDescription{},
+		Models:     []ModelDescription{},
+	}
+
+	version := req.Version
+	if version == 0 {
+		version = api.DefaultVersion
+	}
+
+	for _, op := range api.OperationsForVersion(version) {
+		res.Operations = append(res.Operations, OperationDescription{
+			Name:     op.Name(),
+			Payload:  builder.schemaOf(reflect.TypeOf(op).Elem()),
+			Response: builder.schemaOf(responseType(op)),
+		})
+	}
+
+	for _, model := range models.Models() {
+		res.Models = append(res.Models, describeModel(builder, model))
+	}
+
+	res.Definitions = builder.definitions
+	return res, nil
+}
+
+/* responseType works out what an operation responds with by parsing an empty
+ * (null) response; ParseResponse hands back a zero value of its response type,
+ * or nil if the operation never returns anything. */
+func responseType(op api.Operation) reflect.Type {
+	res, er := op.ParseResponse(api.ResponseWrapper{
+		Data: api.RawMessage("null"),
+	})
+
+	if er != nil || res == nil {
+		return nil
+	}
+
+	/* A pointer here is only how the response is handed back, not a sign it can be null */
+	resType := reflect.TypeOf(res)
+	if resType.Kind() == reflect.Ptr {
+		resType = resType.Elem()
+	}
+
+	return resType
+}
+
+func describeModel(builder *schemaBuilder, model models.ModelMeta) ModelDescription {
+	desc := ModelDescription{
+		Name:       model.Name(),
+		Table:      model.TableName(),
+		PrimaryKey: model.PrimaryKey(),
+		Fields:     []FieldDescription{},
+		Relations:  []RelationDescription{},
+	}
+
+	modelType := model.Type()
+
+	for i := 0; i < modelType.NumField(); i += 1 {
+		field := modelType.Field(i)
+
+		name, ok := jsonName(field)
+		if !ok || name == "" {
+			continue
+		}
+
+		column := strings.Split(field.Tag.Get("crud"), ",")[0]
+		if column == "-" {
+			column = ""
+		}
+
+		desc.Fields = append(desc.Fields, FieldDescription{
+			Name:   name,
+			Column: column,
+			Type:   builder.schemaOf(field.Type),
+		})
+	}
+
+	for _, rel := range model.Relations() {
+		if other, self, join, related := model.BridgeRelationMeta(rel); related != nil {
+			desc.Relations = append(desc.Relations, RelationDescription{
+				Name:       rel,
+				Model:      related.Name(),
+				OtherField: other,
+				SelfField:  self,
+				Through:    join.Name(),
+			})
+
+		} else if other, self, related := model.RelationFieldNames(rel); related != nil {
+			desc.Relations = append(desc.Relations, RelationDescription{
+				Name:       rel,
+				Model:      related.Name(),
+				OtherField: other,
+				SelfField:  self,
+			})
+		}
+	}
+
+	return desc
+}
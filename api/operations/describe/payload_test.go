@@ -0,0 +1,64 @@
+package describe
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"macrobooru/api"
+	_ "macrobooru/api/operations/modify"
+	_ "macrobooru/api/operations/query"
+)
+
+func TestDescribe(t *testing.T) {
+	iRes, er := (&DescribePayload{}).Execute(context.Background(), &api.Request{}, nil)
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	res := iRes.(*DescribeResponse)
+
+	ops := map[string]OperationDescription{}
+	for _, op := range res.Operations {
+		ops[op.Name] = op
+	}
+
+	for _, name := range []string{"describe", "modify", "query"} {
+		if _, ok := ops[name]; !ok {
+			t.Errorf("operation %s is not described", name)
+		}
+	}
+
+	if ops["query"].Response["$ref"] != nil || ops["query"].Response["type"] != "object" {
+		t.Errorf("unexpected query response schema %#v", ops["query"].Response)
+	}
+
+	if _, ok := res.Definitions["query.QueryRequest"]; !ok {
+		t.Errorf("query.QueryRequest has no definition")
+	}
+
+	var image *ModelDescription
+	for idx := range res.Models {
+		if res.Models[idx].Name == "Image" {
+			image = &res.Models[idx]
+		}
+	}
+
+	if image == nil {
+		t.Fatalf("Image is not described")
+	}
+
+	if image.PrimaryKey != "pid" || len(image.Relations) != 2 || image.Relations[0].Model != "Comment" {
+		t.Errorf("unexpected description of Image: %#v", image)
+	}
+
+	for _, field := range image.Fields {
+		if field.Name == "pid" && field.Type["pattern"] == nil {
+			t.Errorf("pid is not described as a GUID: %#v", field)
+		}
+	}
+
+	if _, er := json.Marshal(res); er != nil {
+		t.Fatal(er)
+	}
+}
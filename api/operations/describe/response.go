@@ -0,0 +1,45 @@
+package describe
+
+type DescribeResponse struct {
+	Operations []OperationDescription `json:"operations"`
+	Models     []ModelDescription     `json:"models"`
+
+	// Definitions holds the schemas of named types, which the other schemas
+	// refer to as {"$ref": "#/definitions/<name>"}.
+	Definitions map[string]Schema `json:"definitions"`
+}
+
+type OperationDescription struct {
+	Name    string `json:"name"`
+	Payload Schema `json:"payload"`
+
+	// Response is nil for operations which never return data.
+	Response Schema `json:"response"`
+}
+
+type ModelDescription struct {
+	Name       string                `json:"name"`
+	Table      string                `json:"table"`
+	PrimaryKey string                `json:"primaryKey"`
+	Fields     []FieldDescription    `json:"fields"`
+	Relations  []RelationDescription `json:"relations"`
+}
+
+type FieldDescription struct {
+	// Name is the field's name on the wire; Column is where it's stored.
+	Name   string `json:"name"`
+	Column string `json:"column"`
+	Type   Schema `json:"type"`
+}
+
+type RelationDescription struct {
+	Name  string `json:"name"`
+	Model string `json:"model"`
+
+	// The columns the relation joins through, as reported by ModelMeta.
+	OtherField string `json:"otherField"`
+	SelfField  string `json:"selfField"`
+
+	// Through names the bridge model of a many-to-many relation.
+	Through string `json:"through,omitempty"`
+}
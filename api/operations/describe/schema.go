@@ -0,0 +1,187 @@
+package describe
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"macrobooru/api"
+	"macrobooru/models"
+)
+
+/* Schema is a JSON Schema (draft 4) document. */
+type Schema map[string]interface{}
+
+/* Types whose wire form doesn't follow from their fields (because they implement
+ * MarshalJSON themselves, say) describe themselves by implementing schemer. */
+type schemer interface {
+	JSONSchema() map[string]interface{}
+}
+
+var (
+	schemerType        = reflect.TypeOf((*schemer)(nil)).Elem()
+	guidType           = reflect.TypeOf(models.GUID{})
+	timeType           = reflect.TypeOf(time.Time{})
+	rawMessageType     = reflect.TypeOf(api.RawMessage{})
+	jsonRawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+type schemaBuilder struct {
+	definitions map[string]Schema
+}
+
+func newSchemaBuilder() *schemaBuilder {
+	return &schemaBuilder{
+		definitions: map[string]Schema{},
+	}
+}
+
+func (b *schemaBuilder) schemaOf(t reflect.Type) Schema {
+	if t == nil {
+		return nil
+	}
+
+	if reflect.PtrTo(t).Implements(schemerType) {
+		return Schema(reflect.New(t).Interface().(schemer).JSONSchema())
+	}
+
+	switch t {
+	case guidType:
+		return Schema{"type": "string", "pattern": "^[0-9A-Fa-f]{32}$"}
+
+	case timeType:
+		/* Models send timestamps as a string holding UNIX time */
+		return Schema{"type": "string", "pattern": "^-?[0-9]+$"}
+
+	case rawMessageType, jsonRawMessageType:
+		return Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return nullable(b.schemaOf(t.Elem()))
+
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+
+	case reflect.String:
+		return Schema{"type": "string"}
+
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return Schema{"type": "string", "media": Schema{"binaryEncoding": "base64"}}
+		}
+
+		return Schema{"type": "array", "items": b.schemaOf(t.Elem())}
+
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": b.schemaOf(t.Elem())}
+
+	case reflect.Struct:
+		return b.ref(t)
+	}
+
+	/* Interfaces (and anything else) can hold whatever */
+	return Schema{}
+}
+
+/* ref returns a reference to the definition of a named struct type, adding it to
+ * the definitions if this is the first time it's been seen. */
+func (b *schemaBuilder) ref(t reflect.Type) Schema {
+	if t.Name() == "" {
+		return b.objectSchema(t)
+	}
+
+	name := t.String()
+
+	if _, ok := b.definitions[name]; !ok {
+		/* Reserve the name first, so recursive types terminate */
+		b.definitions[name] = Schema{}
+		b.definitions[name] = b.objectSchema(t)
+	}
+
+	return Schema{"$ref": "#/definitions/" + name}
+}
+
+func (b *schemaBuilder) objectSchema(t reflect.Type) Schema {
+	properties := Schema{}
+	b.addProperties(t, properties)
+
+	return Schema{
+		"type":       "object",
+		"properties": properties,
+	}
+}
+
+/* addProperties mirrors the field handling of encoding/json, including the
+ * flattening of embedded structs. */
+func (b *schemaBuilder) addProperties(t reflect.Type, properties Schema) {
+	for i := 0; i < t.NumField(); i += 1 {
+		field := t.Field(i)
+
+		name, ok := jsonName(field)
+		if !ok {
+			continue
+		}
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+
+			if embedded.Kind() == reflect.Struct {
+				b.addProperties(embedded, properties)
+			}
+
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = b.schemaOf(field.Type)
+	}
+}
+
+/* jsonName returns the name encoding/json gives a field ("" for an untagged
+ * one), or false if the field isn't encoded at all. */
+func jsonName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" && !field.Anonymous {
+		return "", false
+	}
+
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+
+	return strings.Split(tag, ",")[0], true
+}
+
+func nullable(schema Schema) Schema {
+	if typeName, ok := schema["type"].(string); ok {
+		result := Schema{}
+		for k, v := range schema {
+			result[k] = v
+		}
+
+		result["type"] = []string{typeName, "null"}
+		return result
+	}
+
+	if len(schema) == 0 {
+		return schema
+	}
+
+	return Schema{"anyOf": []Schema{schema, {"type": "null"}}}
+}
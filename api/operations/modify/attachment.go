@@ -0,0 +1,108 @@
+package modify
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"mime/multipart"
+	"os"
+	"strings"
+
+	"macrobooru/api"
+	"macrobooru/api/pluggable"
+)
+
+/* fileFields maps a model name onto the field which, in a multipart request, holds
+ * the Content-ID of the part carrying the file rather than a literal value. */
+var fileFields = map[string]string{
+	"Static": "path",
+}
+
+/* pendingUpload is an attachment to save in store at path, once the static
+ * referring to it is committed. */
+type pendingUpload struct {
+	store pluggable.StaticStore
+	path  string
+	file  multipart.File
+}
+
+/* verifyAttachment resolves the attachment referenced by the request (if any),
+ * checks it against the declared SHA1Hash, fills in the hash and mime type when
+ * they were omitted, and replaces the Content-ID with the path the registered
+ * static store will keep it at. The file itself is saved by storeUploads. Without
+ * a store, uploads are refused. */
+func (req *ModifyRequest) verifyAttachment(attachments map[string]multipart.File, mimeTypes map[string]string) error {
+	fileField, ok := fileFields[req.ModelName]
+	if !ok {
+		return nil
+	}
+
+	contentId, ok := req.FieldValues[fileField].(string)
+	if !ok {
+		return nil
+	}
+
+	/* Not every write to a file field is an upload; it may simply be the path of a
+	 * file that already exists */
+	attachment, ok := attachments[contentId]
+	if !ok {
+		return nil
+	}
+
+	store := pluggable.RegisteredStaticStore()
+	if store == nil {
+		return api.ErrorInvalidInputField(fileField)
+	}
+
+	hash := sha1.New()
+	if _, er := io.Copy(hash, attachment); er != nil {
+		return api.ErrorGeneric(er)
+	}
+
+	if _, er := attachment.Seek(0, os.SEEK_SET); er != nil {
+		return api.ErrorGeneric(er)
+	}
+
+	digest := hex.EncodeToString(hash.Sum(nil))
+
+	if declared, ok := req.FieldValues["SHA1Hash"].(string); ok && declared != "" {
+		if !strings.EqualFold(declared, digest) {
+			return api.ErrorInvalidInputField("SHA1Hash")
+		}
+
+	} else {
+		req.FieldValues["SHA1Hash"] = digest
+	}
+
+	if mime, ok := req.FieldValues["mime"].(string); !ok || mime == "" {
+		req.FieldValues["mime"] = mimeTypes[contentId]
+	}
+
+	path := store.Path(digest, req.FieldValues["mime"].(string))
+
+	req.FieldValues[fileField] = path
+	req.upload = &pendingUpload{store, path, attachment}
+	return nil
+}
+
+/* storeUploads saves the attachments of reqs in the static store. It runs once
+ * they're committed, so failures can only be logged. */
+func storeUploads(ctx context.Context, reqs []ModifyRequest) {
+	for _, req := range reqs {
+		upload := req.upload
+		if upload == nil {
+			continue
+		}
+
+		/* Several requests may upload the same attachment */
+		_, er := upload.file.Seek(0, os.SEEK_SET)
+		if er == nil {
+			er = upload.store.Store(upload.path, upload.file)
+		}
+
+		if er != nil {
+			api.Logger(ctx).Error("storing upload failed", "model", req.ModelName, "path", upload.path, "error", er)
+		}
+	}
+}
@@ -5,5 +5,9 @@ import (
 )
 
 func init() {
-	api.RegisterOperation(&ModifyPayload{})
+	api.RegisterOperation(&ModifyPayload{}, api.RequiresAuth)
+
+	/* Version 2 payloads are translated, so both versions parse the same way */
+	api.RegisterVersionedOperation(api.Version3, &ModifyPayloadV3{}, api.RequiresAuth)
+	api.RegisterTranslation("modify", api.Version2, translateV2)
 }
@@ -2,10 +2,9 @@ package modify
 
 import (
 	"macrobooru/api"
-	"macrobooru/models"
+	"macrobooru/api/changes"
 
-	"database/sql"
-	"encoding/json"
+	"context"
 )
 
 type ModifyPayload []ModifyRequest
@@ -17,35 +16,61 @@ func (*ModifyPayload) Name() string {
 func (*ModifyPayload) Parse(req *api.RequestWrapper) (api.Operation, error) {
 	var payload ModifyPayload
 
-	if er := json.Unmarshal(req.RawData, &payload); er != nil {
+	if er := req.UnmarshalData(&payload); er != nil {
 		return nil, api.ErrorInvalidInputFormat(er.Error())
 	}
 
 	return &payload, nil
 }
 
-func (payload *ModifyPayload) Process(u *models.User, db *sql.DB) (interface{}, error) {
+func (payload *ModifyPayload) Execute(ctx context.Context, req *api.Request, db api.Queryer) (interface{}, error) {
 	slice := []ModifyRequest(*payload)
 
 	for idx := range slice {
-		if er := slice[idx].verify(db); er != nil {
+		if er := slice[idx].verifyAttachment(req.Attachments, req.MimeTypes); er != nil {
 			return nil, er
 		}
-	}
 
-	for idx := range slice {
-		if er := slice[idx].run(db); er != nil {
+		if er := slice[idx].verify(); er != nil {
 			return nil, er
 		}
 	}
 
+	/* Each modify is all-or-nothing, per the spec */
+	er := api.WithTransaction(ctx, db, func(tx api.Queryer) error {
+		events := []changes.Event{}
+
+		for idx := range slice {
+			event, er := slice[idx].run(ctx, tx)
+			if er != nil {
+				return er
+			}
+
+			if event != nil {
+				events = append(events, *event)
+			}
+		}
+
+		api.AfterCommit(tx, func() {
+			storeUploads(ctx, slice)
+			updateSearchIndexes(ctx, events)
+			changes.Publish(events...)
+		})
+
+		return nil
+	})
+
+	if er != nil {
+		return nil, er
+	}
+
 	return nil, nil
 }
 
 func (*ModifyPayload) ParseResponse(wrapper api.ResponseWrapper) (interface{}, error) {
 	res := ModifyResponse{}
 
-	if er := json.Unmarshal(wrapper.Data, &res); er != nil {
+	if er := wrapper.UnmarshalData(&res); er != nil {
 		return nil, api.ErrorGeneric(er)
 	}
 
@@ -1,7 +1,7 @@
 package modify
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
 	"fmt"
 	"reflect"
@@ -9,7 +9,11 @@ import (
 	"strings"
 	"time"
 
+	"github.com/lye/crud"
+	"github.com/ugorji/go/codec"
+
 	"macrobooru/api"
+	"macrobooru/api/changes"
 	"macrobooru/models"
 )
 
@@ -24,6 +28,7 @@ type ModifyRequest struct {
 
 	sqlFields []string
 	sqlArgs   []interface{}
+	upload    *pendingUpload
 }
 
 func (req *ModifyRequest) UnmarshalJSON(bs []byte) (er error) {
@@ -36,6 +41,10 @@ func (req *ModifyRequest) UnmarshalJSON(bs []byte) (er error) {
 		return er
 	}
 
+	return req.fromValues(rawValues)
+}
+
+func (req *ModifyRequest) fromValues(rawValues map[string]interface{}) (er error) {
 	var ok bool
 
 	/* We need to pull out the metadata first (rather than inline with the rest of the data)
@@ -83,6 +92,10 @@ func (req *ModifyRequest) UnmarshalJSON(bs []byte) (er error) {
 }
 
 func (req *ModifyRequest) MarshalJSON() (bs []byte, er error) {
+	return json.Marshal(req.values())
+}
+
+func (req *ModifyRequest) values() map[string]interface{} {
 	values := map[string]interface{}{
 		"#model":   req.ModelName,
 		"#primary": req.GUID.String(),
@@ -100,10 +113,64 @@ func (req *ModifyRequest) MarshalJSON() (bs []byte, er error) {
 		}
 	}
 
-	return json.Marshal(values)
+	return values
+}
+
+/* JSONSchema describes the wire form for the describe operation, since the field
+ * values of the model are mixed in with the metadata. */
+func (*ModifyRequest) JSONSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type":     "object",
+		"required": []string{"#model"},
+		"properties": map[string]interface{}{
+			"#model":   map[string]interface{}{"type": "string"},
+			"#primary": map[string]interface{}{"type": "string", "pattern": "^[0-9A-Fa-f]{32}$"},
+			"#delete":  map[string]interface{}{"type": "boolean"},
+		},
+		"additionalProperties": true,
+	}
+}
+
+func (req *ModifyRequest) CodecEncodeSelf(e *codec.Encoder) {
+	e.MustEncode(req.values())
+}
+
+func (req *ModifyRequest) CodecDecodeSelf(d *codec.Decoder) {
+	rawValues := map[string]interface{}{}
+	d.MustDecode(&rawValues)
+
+	/* verify() coerces values from the shapes encoding/json produces, so numbers
+	 * (which the binary encodings keep as integers where they can) become float64 */
+	for k, v := range rawValues {
+		rawValues[k] = jsonShaped(v)
+	}
+
+	if er := req.fromValues(rawValues); er != nil {
+		panic(er)
+	}
+}
+
+func jsonShaped(value interface{}) interface{} {
+	switch val := value.(type) {
+	case int64:
+		return float64(val)
+
+	case uint64:
+		return float64(val)
+
+	case float32:
+		return float64(val)
+
+	case []interface{}:
+		for idx := range val {
+			val[idx] = jsonShaped(val[idx])
+		}
+	}
+
+	return value
 }
 
-func (req *ModifyRequest) verify(db *sql.DB) error {
+func (req *ModifyRequest) verify() error {
 	guidType := reflect.TypeOf(models.GUID{})
 	timeType := reflect.TypeOf(time.Time{})
 
@@ -186,21 +253,23 @@ func (req *ModifyRequest) verify(db *sql.DB) error {
 	return nil
 }
 
-func (req *ModifyRequest) run(db *sql.DB) error {
+func (req *ModifyRequest) run(ctx context.Context, db api.Queryer) (*changes.Event, error) {
 	if len(req.sqlFields) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	/* Attempt to get the book first, ugh, why the hell don't we use `ON CONFLICT REPLACE`?! */
-	q := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s = $1", req.Model.TableName(), req.Model.PrimaryKey())
-	rows, er := db.Query(q, req.GUID.String())
+	dialect := api.ActiveDialect()
+
+	q := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", req.table(), req.primaryKeyIs(1))
+	rows, er := db.QueryContext(ctx, q, req.GUID.String())
 	if er != nil {
-		return api.ErrorGeneric(er)
+		return nil, api.ErrorGeneric(er)
 	}
 
 	if !rows.Next() {
 		rows.Close()
-		return api.ErrorGeneric(fmt.Errorf("No rows returned for a COUNT(*)"))
+		return nil, api.ErrorGeneric(fmt.Errorf("No rows returned for a COUNT(*)"))
 	}
 
 	var count int64
@@ -208,25 +277,27 @@ func (req *ModifyRequest) run(db *sql.DB) error {
 	rows.Close()
 
 	if er != nil {
-		return api.ErrorGeneric(er)
+		return nil, api.ErrorGeneric(er)
 	}
 
-	fmt.Printf("%#v\n%#v\n", req.sqlFields, req.sqlArgs)
+	kind := changes.Created
 
 	if count != 0 {
+		kind = changes.Updated
 		fields := []string{}
 
 		for idx, fieldName := range req.sqlFields {
-			fields = append(fields, fmt.Sprintf("%s = $%d", fieldName, idx+1))
+			fields = append(fields, fmt.Sprintf("%s = %s", dialect.QuoteIdentifier(fieldName), dialect.Placeholder(idx+1)))
 		}
 
 		fieldList := strings.Join(fields, ", ")
 		req.sqlArgs = append(req.sqlArgs, req.GUID.String())
 
-		q := fmt.Sprintf("UPDATE %s SET %s WHERE %s = $%d", req.Model.TableName(), fieldList, req.Model.PrimaryKey(), len(req.sqlArgs))
+		q := fmt.Sprintf("UPDATE %s SET %s WHERE %s", req.table(), fieldList, req.primaryKeyIs(len(req.sqlArgs)))
+		api.LogQuery(ctx, q, req.sqlArgs)
 
-		if _, er := db.Exec(q, req.sqlArgs...); er != nil {
-			return api.ErrorGeneric(er)
+		if _, er := db.ExecContext(ctx, q, req.sqlArgs...); er != nil {
+			return nil, api.ErrorGeneric(er)
 		}
 
 	} else {
@@ -234,19 +305,68 @@ func (req *ModifyRequest) run(db *sql.DB) error {
 		placeholders := []string{}
 
 		for idx, fieldName := range req.sqlFields {
-			fields = append(fields, fieldName)
-			placeholders = append(placeholders, fmt.Sprintf("$%d", idx+1))
+			fields = append(fields, dialect.QuoteIdentifier(fieldName))
+			placeholders = append(placeholders, dialect.Placeholder(idx+1))
 		}
 
 		fieldList := strings.Join(fields, ", ")
 		phList := strings.Join(placeholders, ", ")
 
-		q := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", req.Model.TableName(), fieldList, phList)
+		q := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", req.table(), fieldList, phList)
+		api.LogQuery(ctx, q, req.sqlArgs)
 
-		if _, er := db.Exec(q, req.sqlArgs...); er != nil {
-			return api.ErrorGeneric(er)
+		if _, er := db.ExecContext(ctx, q, req.sqlArgs...); er != nil {
+			return nil, api.ErrorGeneric(er)
 		}
 	}
 
-	return nil
+	/* Subscribers get the whole object, not just the fields that were sent */
+	object, er := req.load(ctx, db)
+	if er != nil {
+		return nil, er
+	}
+
+	return req.event(kind, object), nil
+}
+
+/* load fetches the object being modified (as a pointer to its model struct), or
+ * nil if it doesn't exist. */
+func (req *ModifyRequest) load(ctx context.Context, db api.Queryer) (interface{}, error) {
+	q := fmt.Sprintf("SELECT * FROM %s WHERE %s", req.table(), req.primaryKeyIs(1))
+	rows, er := db.QueryContext(ctx, q, req.GUID.String())
+	if er != nil {
+		return nil, api.ErrorGeneric(er)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	object := reflect.New(req.Model.Type()).Interface()
+
+	if er := crud.Scan(rows, object); er != nil {
+		return nil, api.ErrorGeneric(er)
+	}
+
+	return object, nil
+}
+
+func (req *ModifyRequest) table() string {
+	return api.ActiveDialect().QuoteIdentifier(req.Model.TableName())
+}
+
+/* primaryKeyIs compares the primary key with the n'th query argument. */
+func (req *ModifyRequest) primaryKeyIs(n int) string {
+	dialect := api.ActiveDialect()
+	return dialect.QuoteIdentifier(req.Model.PrimaryKey()) + " = " + dialect.Placeholder(n)
+}
+
+func (req *ModifyRequest) event(kind changes.Kind, object interface{}) *changes.Event {
+	return &changes.Event{
+		Kind:   kind,
+		Model:  req.Model.Name(),
+		Pid:    req.GUID,
+		Object: object,
+	}
 }
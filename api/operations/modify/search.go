@@ -0,0 +1,112 @@
+package modify
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/lye/crud"
+
+	"macrobooru/api"
+	"macrobooru/api/changes"
+	"macrobooru/api/pluggable"
+	"macrobooru/models"
+)
+
+/* updateSearchIndexes brings the search indexes of the models changed by events up
+ * to date. It runs once they're committed, so failures can only be logged; the
+ * index is stale until the object is next modified. */
+func updateSearchIndexes(ctx context.Context, events []changes.Event) {
+	for _, event := range events {
+		for field, index := range pluggable.RegisteredSearchIndexes(event.Model) {
+			if er := index.Index(event.Pid, fieldText(event.Object, field)); er != nil {
+				api.Logger(ctx).Error("search index update failed", "model", event.Model, "field", field, "pid", event.Pid.String(), "error", er)
+			}
+		}
+	}
+}
+
+/* RebuildSearchIndex indexes the field (as named in JSON) of every object of the
+ * model with the given name, in the index registered for it. Indexes only hear of
+ * objects as they're modified, so one that starts out empty, or missed changes
+ * while the server was down, should be rebuilt before it's relied on. */
+func RebuildSearchIndex(ctx context.Context, db api.Queryer, modelName, field string) error {
+	model := models.ModelByName(modelName)
+	if model == nil {
+		return fmt.Errorf("no model named %s", modelName)
+	}
+
+	index := pluggable.RegisteredSearchIndex(modelName, field)
+	if index == nil {
+		return fmt.Errorf("no search index is registered for %s.%s", modelName, field)
+	}
+
+	q := fmt.Sprintf("SELECT * FROM %s", api.ActiveDialect().QuoteIdentifier(model.TableName()))
+	rows, er := db.QueryContext(ctx, q)
+	if er != nil {
+		return er
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		object := reflect.New(model.Type()).Interface()
+
+		if er := crud.Scan(rows, object); er != nil {
+			return er
+		}
+
+		if er := index.Index(primaryKeyOf(object, model), fieldText(object, field)); er != nil {
+			return er
+		}
+	}
+
+	return rows.Err()
+}
+
+/* primaryKeyOf is the primary key of object (a pointer to a struct of model). */
+func primaryKeyOf(object interface{}, model models.ModelMeta) models.GUID {
+	val := reflect.Indirect(reflect.ValueOf(object))
+
+	for i := 0; i < val.NumField(); i += 1 {
+		if strings.Split(val.Type().Field(i).Tag.Get("crud"), ",")[0] == model.PrimaryKey() {
+			if guid, ok := val.Field(i).Interface().(models.GUID); ok {
+				return guid
+			}
+		}
+	}
+
+	return models.GUID{}
+}
+
+/* fieldText is the text of the field of object (a pointer to a model struct) named
+ * jsonName in JSON, or "" if it has no such field or it's null. */
+func fieldText(object interface{}, jsonName string) string {
+	val := reflect.Indirect(reflect.ValueOf(object))
+	if val.Kind() != reflect.Struct {
+		return ""
+	}
+
+	for i := 0; i < val.NumField(); i += 1 {
+		if strings.Split(val.Type().Field(i).Tag.Get("json"), ",")[0] != jsonName {
+			continue
+		}
+
+		field := val.Field(i)
+		if field.Kind() == reflect.Ptr {
+			if field.IsNil() {
+				return ""
+			}
+
+			field = field.Elem()
+		}
+
+		if field.Kind() == reflect.String {
+			return field.String()
+		}
+
+		return fmt.Sprint(field.Interface())
+	}
+
+	return ""
+}
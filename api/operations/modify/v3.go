@@ -0,0 +1,135 @@
+package modify
+
+import (
+	"fmt"
+	"strings"
+
+	"macrobooru/api"
+	"macrobooru/models"
+)
+
+/* ModifyEntry is a modify request as version 3 of the API sends it: the model and
+ * primary key are kept apart from the field values, instead of being mixed in with
+ * them under '#'-prefixed keys. */
+type ModifyEntry struct {
+	Model   string                 `json:"model"`
+	Primary string                 `json:"primary,omitempty"`
+	Fields  map[string]interface{} `json:"fields"`
+}
+
+/* ModifyPayloadV3 is the version 3 form of ModifyPayload. It parses into a
+ * ModifyPayload, which is what's executed. */
+type ModifyPayloadV3 []ModifyEntry
+
+func (*ModifyPayloadV3) Name() string {
+	return "modify"
+}
+
+func (*ModifyPayloadV3) Parse(req *api.RequestWrapper) (api.Operation, error) {
+	var entries ModifyPayloadV3
+
+	if er := req.UnmarshalData(&entries); er != nil {
+		return nil, api.ErrorInvalidInputFormat(er.Error())
+	}
+
+	payload := make(ModifyPayload, len(entries))
+
+	for idx, entry := range entries {
+		if er := payload[idx].fromEntry(entry); er != nil {
+			return nil, er
+		}
+	}
+
+	return &payload, nil
+}
+
+func (*ModifyPayloadV3) ParseResponse(wrapper api.ResponseWrapper) (interface{}, error) {
+	return (*ModifyPayload)(nil).ParseResponse(wrapper)
+}
+
+func (req *ModifyRequest) fromEntry(entry ModifyEntry) error {
+	model := models.ModelByName(entry.Model)
+	if model == nil {
+		return api.ErrorInvalidInputField("model")
+	}
+
+	values := map[string]interface{}{"#model": entry.Model}
+
+	for k, v := range entry.Fields {
+		if strings.HasPrefix(k, "#") {
+			return api.ErrorInvalidInputField(k)
+		}
+
+		/* The binary encodings keep integers as integers, as verify() doesn't */
+		values[k] = jsonShaped(v)
+	}
+
+	if entry.Primary != "" {
+		if _, er := models.GUIDFromString(entry.Primary); er != nil {
+			return api.ErrorInvalidInputField("primary")
+		}
+
+		values["#primary"] = entry.Primary
+
+	} else if _, ok := values[model.PrimaryKey()]; !ok {
+		return api.ErrorMissingInputField("primary")
+	}
+
+	return req.fromValues(values)
+}
+
+/* V3 is payload in the form version 3 of the API expects. */
+func (payload ModifyPayload) V3() *ModifyPayloadV3 {
+	entries := make(ModifyPayloadV3, len(payload))
+
+	for idx, req := range payload {
+		entries[idx] = ModifyEntry{
+			Model:   req.ModelName,
+			Primary: req.GUID.String(),
+			Fields:  req.FieldValues,
+		}
+	}
+
+	return &entries
+}
+
+/* translateV2 rewrites a version 2 modify payload into the version 3 form. */
+func translateV2(data interface{}) (interface{}, error) {
+	if data == nil {
+		return nil, nil
+	}
+
+	requests, ok := data.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an array of objects")
+	}
+
+	entries := make([]interface{}, len(requests))
+
+	for idx, request := range requests {
+		values, ok := request.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected an array of objects")
+		}
+
+		fields := map[string]interface{}{}
+		entry := map[string]interface{}{"fields": fields}
+
+		for k, v := range values {
+			switch {
+			case k == "#model":
+				entry["model"] = v
+
+			case k == "#primary":
+				entry["primary"] = v
+
+			case !strings.HasPrefix(k, "#"):
+				fields[k] = v
+			}
+		}
+
+		entries[idx] = entry
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,80 @@
+package modify
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"macrobooru/api"
+	"macrobooru/models"
+)
+
+func parseModify(t *testing.T, version int, data string) (*ModifyPayload, error) {
+	req := &api.RequestWrapper{Operation: "modify", RawData: api.RawMessage(data), Version: version}
+
+	if er := req.Parse(); er != nil {
+		return nil, er
+	}
+
+	payload, ok := req.Data.(*ModifyPayload)
+	if !ok {
+		t.Fatalf("parsed into %T", req.Data)
+	}
+
+	return payload, nil
+}
+
+func TestModifyVersions(t *testing.T) {
+	pid := models.NewGUID().String()
+
+	v2, er := parseModify(t, api.Version2, `[ { "#model" : "Image", "#primary" : "`+pid+`", "mime" : "image/png" } ]`)
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	v3, er := parseModify(t, api.Version3, `[ { "model" : "Image", "primary" : "`+pid+`", "fields" : { "mime" : "image/png" } } ]`)
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	/* What a client sends for version 3 parses the same again */
+	bs, er := json.Marshal(v2.V3())
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	resent, er := parseModify(t, api.Version3, string(bs))
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	for _, payload := range []*ModifyPayload{v3, resent} {
+		if len(*payload) != 1 {
+			t.Fatalf("expected one request, got %d", len(*payload))
+		}
+
+		got, expected := (*payload)[0], (*v2)[0]
+
+		if got.ModelName != expected.ModelName || !got.GUID.Equal(expected.GUID) || !reflect.DeepEqual(got.FieldValues, expected.FieldValues) {
+			t.Errorf("expected %#v, got %#v", expected, got)
+		}
+	}
+
+	tests := []struct {
+		data string
+		code int64
+	}{
+		{`[ { "model" : "Bogus", "primary" : "` + pid + `", "fields" : {} } ]`, api.ErrCodeInvalidInputField},
+		{`[ { "model" : "Image", "primary" : "nope", "fields" : {} } ]`, api.ErrCodeInvalidInputField},
+		{`[ { "model" : "Image", "fields" : { "mime" : "image/png" } } ]`, api.ErrCodeMissingInputField},
+		{`[ { "model" : "Image", "primary" : "` + pid + `", "fields" : { "#model" : "User" } } ]`, api.ErrCodeInvalidInputField},
+	}
+
+	for _, test := range tests {
+		_, er := parseModify(t, api.Version3, test.data)
+
+		if !api.HasCode(er, test.code) {
+			t.Errorf("%s: expected %s, got %v", test.data, api.ErrorKey(test.code), er)
+		}
+	}
+}
@@ -1,11 +1,9 @@
 package nonce
 
 import (
-	"database/sql"
-	"encoding/json"
+	"context"
 
 	"macrobooru/api"
-	"macrobooru/models"
 )
 
 /* Takes no arguments, but has internal state */
@@ -20,7 +18,7 @@ func (np *NoncePayload) Name() string {
 func (np *NoncePayload) Parse(req *api.RequestWrapper) (api.Operation, error) {
 	var payload NoncePayload
 
-	if er := json.Unmarshal(req.RawData, &payload); er != nil {
+	if er := req.UnmarshalData(&payload); er != nil {
 		return nil, er
 	}
 
@@ -31,6 +29,6 @@ func (ap *NoncePayload) ParseResponse(resWrapper api.ResponseWrapper) (interface
 	return nil, nil
 }
 
-func (np *NoncePayload) Process(u *models.User, db *sql.DB) (interface{}, error) {
+func (np *NoncePayload) Execute(ctx context.Context, req *api.Request, db api.Queryer) (interface{}, error) {
 	return nil, nil
 }
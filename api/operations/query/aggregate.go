@@ -0,0 +1,265 @@
+package query
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"macrobooru/api"
+	"macrobooru/models"
+)
+
+/* AggregateClause is the `aggregate` of a query. Rather than the objects matched,
+ * the query returns a summary of each group of them sharing the values of the
+ * Group fields (or of all of them, if there are none). */
+type AggregateClause struct {
+	Group []string `json:"group,omitempty"`
+	Count bool     `json:"count,omitempty"`
+	Sum   []string `json:"sum,omitempty"`
+	Avg   []string `json:"avg,omitempty"`
+	Min   []string `json:"min,omitempty"`
+	Max   []string `json:"max,omitempty"`
+}
+
+/* AggregateRow is the summary of one group of objects. */
+type AggregateRow struct {
+	// Group holds the value each of the group fields has throughout the group.
+	// GUIDs are sent as strings and timestamps as UNIX time.
+	Group map[string]interface{} `json:"group,omitempty"`
+
+	// Count is the number of objects in the group, if it was asked for.
+	Count *int64 `json:"count,omitempty"`
+
+	// Each of these maps a field onto the result of the function over the group.
+	// A field which is null throughout the group is left out.
+	Sum map[string]float64 `json:"sum,omitempty"`
+	Avg map[string]float64 `json:"avg,omitempty"`
+	Min map[string]float64 `json:"min,omitempty"`
+	Max map[string]float64 `json:"max,omitempty"`
+}
+
+type groupField struct {
+	name  string
+	field modelField
+}
+
+type aggregateTerm struct {
+	function string
+	name     string
+	column   string
+}
+
+/* aggregation is an AggregateClause, checked against the model it summarizes. */
+type aggregation struct {
+	group []groupField
+	count bool
+	terms []aggregateTerm
+}
+
+/* compile checks the fields of the clause against model. Any field can be grouped
+ * by; SUM and AVG take numeric fields, and MIN and MAX timestamps as well. */
+func (clause *AggregateClause) compile(model models.ModelMeta) (*aggregation, error) {
+	agg := &aggregation{count: clause.Count}
+
+	for _, name := range clause.Group {
+		field, ok := fieldInfo(model, name)
+		if !ok {
+			return nil, api.ErrorInvalidInputField(name)
+		}
+
+		agg.group = append(agg.group, groupField{name, field})
+	}
+
+	functions := []struct {
+		function   string
+		fields     []string
+		timestamps bool
+	}{
+		{"SUM", clause.Sum, false},
+		{"AVG", clause.Avg, false},
+		{"MIN", clause.Min, true},
+		{"MAX", clause.Max, true},
+	}
+
+	for _, fn := range functions {
+		for _, name := range fn.fields {
+			field, ok := fieldInfo(model, name)
+			if !ok || !isRangeField(field.Type) || (field.Type == timeType && !fn.timestamps) {
+				return nil, api.ErrorInvalidInputField(name)
+			}
+
+			agg.terms = append(agg.terms, aggregateTerm{fn.function, name, field.Column})
+		}
+	}
+
+	if !agg.count && len(agg.terms) == 0 {
+		return nil, api.ErrorInvalidInputField("aggregate")
+	}
+
+	return agg, nil
+}
+
+/* selectList is the group columns, followed by the count and the results of each
+ * function, of the table aliased as alias. */
+func (agg *aggregation) selectList(alias string) string {
+	bits := agg.groupList(alias)
+
+	if agg.count {
+		bits = append(bits, "COUNT(*)")
+	}
+
+	for _, term := range agg.terms {
+		bits = append(bits, fmt.Sprintf("%s(%s)", term.function, column(alias, term.column)))
+	}
+
+	return strings.Join(bits, ", ")
+}
+
+func (agg *aggregation) groupList(alias string) []string {
+	bits := make([]string, len(agg.group))
+
+	for idx, group := range agg.group {
+		bits[idx] = column(alias, group.field.Column)
+	}
+
+	return bits
+}
+
+/* groupBySQL is the GROUP BY clause, if there is one. */
+func (agg *aggregation) groupBySQL(alias string) string {
+	if len(agg.group) == 0 {
+		return ""
+	}
+
+	return " GROUP BY " + strings.Join(agg.groupList(alias), ", ")
+}
+
+/* orderSQL builds the ORDER BY list for the groups. Only group fields may be
+ * ordered by; groups which tie on the fields given are ordered by the rest. */
+func (agg *aggregation) orderSQL(order OrderClause, model models.ModelMeta, alias string) (string, error) {
+	terms, er := parseOrder(order, model)
+	if er != nil {
+		return "", er
+	}
+
+	bits := []string{}
+	ordered := []string{}
+
+	for _, term := range terms {
+		if term.Relevance || !agg.groups(term.Field.Column) {
+			return "", api.ErrorInvalidInputField("order")
+		}
+
+		direction := "ASC"
+		if term.Descending {
+			direction = "DESC"
+		}
+
+		bits = append(bits, fmt.Sprintf("%s %s", column(alias, term.Field.Column), direction))
+		ordered = append(ordered, term.Field.Column)
+	}
+
+	for _, group := range agg.group {
+		if !containsString(ordered, group.field.Column) {
+			bits = append(bits, fmt.Sprintf("%s ASC", column(alias, group.field.Column)))
+			ordered = append(ordered, group.field.Column)
+		}
+	}
+
+	return strings.Join(bits, ", "), nil
+}
+
+func (agg *aggregation) groups(column string) bool {
+	for _, group := range agg.group {
+		if group.field.Column == column {
+			return true
+		}
+	}
+
+	return false
+}
+
+/* scan reads the row rows is on, which was selected by selectList, followed by
+ * any extra columns into extra. */
+func (agg *aggregation) scan(rows *sql.Rows, extra ...interface{}) (AggregateRow, error) {
+	groupValues := make([]interface{}, len(agg.group))
+	results := make([]sql.NullFloat64, len(agg.terms))
+	var count int64
+
+	dest := []interface{}{}
+
+	for idx := range groupValues {
+		dest = append(dest, &groupValues[idx])
+	}
+
+	if agg.count {
+		dest = append(dest, &count)
+	}
+
+	for idx := range results {
+		dest = append(dest, &results[idx])
+	}
+
+	dest = append(dest, extra...)
+
+	if er := rows.Scan(dest...); er != nil {
+		return AggregateRow{}, er
+	}
+
+	row := AggregateRow{}
+
+	if len(agg.group) > 0 {
+		row.Group = make(map[string]interface{})
+
+		for idx, group := range agg.group {
+			row.Group[group.name] = groupValue(groupValues[idx], group.field)
+		}
+	}
+
+	if agg.count {
+		row.Count = &count
+	}
+
+	for idx, term := range agg.terms {
+		if !results[idx].Valid {
+			continue
+		}
+
+		var into *map[string]float64
+
+		switch term.function {
+		case "SUM":
+			into = &row.Sum
+		case "AVG":
+			into = &row.Avg
+		case "MIN":
+			into = &row.Min
+		case "MAX":
+			into = &row.Max
+		}
+
+		if *into == nil {
+			*into = make(map[string]float64)
+		}
+
+		(*into)[term.name] = results[idx].Float64
+	}
+
+	return row, nil
+}
+
+/* groupValue converts a value of a group field, as the driver returns it, into the
+ * form it's sent in. */
+func groupValue(value interface{}, field modelField) interface{} {
+	if bs, ok := value.([]byte); ok {
+		value = string(bs)
+	}
+
+	if str, ok := value.(string); ok && field.Type == guidType {
+		if guid, er := models.GUIDFromString(str); er == nil {
+			return guid.String()
+		}
+	}
+
+	return value
+}
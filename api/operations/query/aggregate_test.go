@@ -0,0 +1,95 @@
+package query
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestAggregateClause(t *testing.T) {
+	frag, er := decomposeTestQuery(t, `
+		{ "ratings" :
+			{ "model" : "Rating"
+			, "where" : { "rating >=" : 1 }
+			, "aggregate" : { "group" : ["image_id"], "avg" : ["rating"], "max" : ["rating"], "count" : true }
+			, "limit" : 10
+			}
+		}`, "ratings")
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	sql, args := frag.toSQL()
+	expected := `SELECT "alias1"."image_id", COUNT(*), AVG("alias1"."rating"), MAX("alias1"."rating") FROM "Rating" AS "alias1" WHERE "alias1"."rating" >= $1 GROUP BY "alias1"."image_id" ORDER BY "alias1"."image_id" ASC LIMIT 10 OFFSET 0`
+
+	if sql != expected {
+		t.Errorf("Incorrect SQL, got\n%s\nexpected\n%s", sql, expected)
+	}
+
+	if !reflect.DeepEqual(args, []interface{}{int64(1)}) {
+		t.Errorf("Incorrect args, got %#v", args)
+	}
+
+	sql, _ = frag.toCountSQL()
+	expected = `SELECT COUNT(*) FROM (SELECT COUNT(*) FROM "Rating" AS "alias1" WHERE "alias1"."rating" >= $1 GROUP BY "alias1"."image_id") AS "groups"`
+
+	if sql != expected {
+		t.Errorf("Incorrect count SQL, got\n%s\nexpected\n%s", sql, expected)
+	}
+
+	/* Without groups, everything matched is summarized */
+	frag, er = decomposeTestQuery(t, `{ "images" : { "model" : "Image", "aggregate" : { "min" : ["uploadedDate"], "sum" : ["ratingsAverage"] } } }`, "images")
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	sql, _ = frag.toSQL()
+	expected = `SELECT SUM("alias1"."ratingsAverage"), MIN("alias1"."uploadedDate") FROM "Image" AS "alias1" LIMIT 200 OFFSET 0`
+
+	if sql != expected {
+		t.Errorf("Incorrect SQL, got\n%s\nexpected\n%s", sql, expected)
+	}
+}
+
+func TestAggregateClauseRejects(t *testing.T) {
+	for _, request := range []string{
+		`{ "model" : "Rating", "aggregate" : {} }`,
+		`{ "model" : "Rating", "aggregate" : { "group" : ["bogus"], "count" : true } }`,
+		`{ "model" : "Rating", "aggregate" : { "sum" : ["raterEmail"] } }`,
+		`{ "model" : "Image", "aggregate" : { "avg" : ["uploadedDate"] } }`,
+		`{ "model" : "Rating", "aggregate" : { "count" : true }, "fields" : ["rating"] }`,
+		`{ "model" : "Rating", "aggregate" : { "group" : ["image_id"], "count" : true }, "order" : "rating" }`,
+	} {
+		if _, er := decomposeTestQuery(t, `{ "r" : `+request+` }`, "r"); er == nil {
+			t.Errorf("%s: expected an error", request)
+		}
+	}
+}
+
+func TestAggregateResponseRoundTrip(t *testing.T) {
+	count := int64(3)
+	part := QueryResponsePart{
+		Total:     1,
+		Slice:     []interface{}{},
+		ModelName: "Rating",
+		Groups: []AggregateRow{{
+			Group: map[string]interface{}{"image_id": "00000000000000010000000000000002"},
+			Count: &count,
+			Avg:   map[string]float64{"rating": 2.5},
+		}},
+	}
+
+	bs, er := json.Marshal(part)
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	var decoded QueryResponsePart
+	if er := json.Unmarshal(bs, &decoded); er != nil {
+		t.Fatal(er)
+	}
+
+	if !reflect.DeepEqual(decoded.Groups, part.Groups) {
+		t.Errorf("expected %#v, got %#v", part.Groups, decoded.Groups)
+	}
+}
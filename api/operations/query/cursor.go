@@ -0,0 +1,279 @@
+package query
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"macrobooru/api"
+	"macrobooru/models"
+)
+
+/* cursorState is what a cursor holds: the order it was made for, and the value the
+ * last row of the page had for each of its terms. Clients treat it as opaque. */
+type cursorState struct {
+	Order string        `json:"order"`
+	Keys  []interface{} `json:"keys"`
+}
+
+/* keyTerms is the order the results of a request come back in: its order clause,
+ * with the primary key as the last resort, as orderSQL sorts them. The primary key
+ * makes the terms unique to each row, so a row's values for them mark its place. */
+func keyTerms(order OrderClause, model models.ModelMeta) ([]orderTerm, error) {
+	terms, er := parseOrder(order, model)
+	if er != nil {
+		return nil, er
+	}
+
+	for _, term := range terms {
+		if !term.Relevance && term.Field.Column == model.PrimaryKey() {
+			return terms, nil
+		}
+	}
+
+	primary, _ := fieldInfo(model, "#primary")
+	return append(terms, orderTerm{Field: primary}), nil
+}
+
+/* orderSignature identifies an order, so that a cursor made for one isn't used to
+ * resume another. */
+func orderSignature(terms []orderTerm) string {
+	bits := make([]string, len(terms))
+
+	for idx, term := range terms {
+		name := term.Field.Column
+		if term.Relevance {
+			name = relevanceTerm
+		}
+
+		direction := "ASC"
+		if term.Descending {
+			direction = "DESC"
+		}
+
+		bits[idx] = name + " " + direction
+	}
+
+	return strings.Join(bits, ",")
+}
+
+/* cursor is the cursor of the page ending with row, which is of frag's Target. */
+func (frag *SqlFragment) cursor(row interface{}) (string, error) {
+	val := reflect.Indirect(reflect.ValueOf(row))
+	primary, _ := fieldInfo(frag.Target, "#primary")
+
+	state := cursorState{
+		Order: orderSignature(frag.Keys),
+		Keys:  make([]interface{}, len(frag.Keys)),
+	}
+
+	for idx, term := range frag.Keys {
+		if !term.Relevance {
+			state.Keys[idx] = keyValue(val, term.Field)
+			continue
+		}
+
+		/* A row's relevance is its rank among what the search found */
+		pid := keyValue(val, primary)
+		rank := len(frag.Search.Pids)
+
+		for pos, found := range frag.Search.Pids {
+			if found == pid {
+				rank = pos
+				break
+			}
+		}
+
+		state.Keys[idx] = rank
+	}
+
+	bs, er := json.Marshal(state)
+	if er != nil {
+		return "", er
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bs), nil
+}
+
+/* keyValue is the value of field in the model val, as a cursor holds it. Integers
+ * are kept as strings so JSON doesn't round them, and timestamps as RFC 3339. */
+func keyValue(val reflect.Value, field modelField) interface{} {
+	idx, _ := columnField(val.Type(), field.Column)
+	fieldVal := val.Field(idx)
+
+	if fieldVal.Kind() == reflect.Ptr {
+		if fieldVal.IsNil() {
+			return nil
+		}
+
+		fieldVal = fieldVal.Elem()
+	}
+
+	switch value := fieldVal.Interface().(type) {
+	case models.GUID:
+		return value.String()
+
+	case time.Time:
+		return value.UTC().Format(time.RFC3339Nano)
+	}
+
+	switch fieldVal.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(fieldVal.Int(), 10)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(fieldVal.Uint(), 10)
+	}
+
+	return fieldVal.Interface()
+}
+
+/* afterClause parses cursor, and builds the clause holding for the rows that come
+ * after the row it was made from. Any cursor that wasn't made for the order of frag
+ * is refused. */
+func (frag *SqlFragment) afterClause(cursor string) (WhereClause, error) {
+	keys, er := frag.parseCursor(cursor)
+	if er != nil {
+		return WhereClause{}, api.ErrorInvalidInputField("after")
+	}
+
+	/* Rows after (a, b, c) are those after a, or equal to a and after b, or... */
+	after := WhereClause{Operand: whereOr}
+	equal := []WhereClause{}
+
+	for idx, term := range frag.Keys {
+		later, same := frag.keyClauses(term, keys[idx])
+
+		group := append(append([]WhereClause{}, equal...), later)
+		after.Clauses = append(after.Clauses, WhereClause{Operand: whereAnd, Clauses: group})
+
+		equal = append(equal, same)
+	}
+
+	return after, nil
+}
+
+func (frag *SqlFragment) parseCursor(cursor string) ([]interface{}, error) {
+	bs, er := base64.RawURLEncoding.DecodeString(cursor)
+	if er != nil {
+		return nil, er
+	}
+
+	var state cursorState
+	if er := json.Unmarshal(bs, &state); er != nil {
+		return nil, er
+	}
+
+	if state.Order != orderSignature(frag.Keys) || len(state.Keys) != len(frag.Keys) {
+		return nil, errInvalidValue
+	}
+
+	keys := make([]interface{}, len(frag.Keys))
+
+	for idx, term := range frag.Keys {
+		key := state.Keys[idx]
+
+		switch {
+		case term.Relevance:
+			rank, ok := numberValue(key)
+			if !ok || rank != math.Trunc(rank) || rank < 0 || rank > float64(len(frag.Search.Pids)) {
+				return nil, errInvalidValue
+			}
+
+			keys[idx] = int(rank)
+
+		case key == nil:
+			if term.Field.Type.Kind() != reflect.Ptr {
+				return nil, errInvalidValue
+			}
+
+		default:
+			if keys[idx], er = coerceValue(key, term.Field); er != nil {
+				return nil, er
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+/* keyClauses builds the clauses holding for the rows that come after a row whose
+ * value for term is key, and for those that tie with it. */
+func (frag *SqlFragment) keyClauses(term orderTerm, key interface{}) (WhereClause, WhereClause) {
+	if term.Relevance {
+		return frag.rankClauses(term, key.(int))
+	}
+
+	name := term.Field.Column
+	none := WhereClause{Operand: whereOr}
+
+	/* Where NULLs sort depends on the database */
+	nullsLater := api.ActiveDialect().NullsFirst() == term.Descending
+
+	if key == nil {
+		same := WhereClause{Field: name, Operand: whereNull}
+
+		if nullsLater {
+			return none, same
+		}
+
+		return WhereClause{Field: name, Operand: whereNotNull}, same
+	}
+
+	operand := whereGreater
+	if term.Descending {
+		operand = whereLess
+	}
+
+	later := WhereClause{Field: name, Value: key, Operand: operand}
+
+	if term.Field.Type.Kind() == reflect.Ptr && nullsLater {
+		later = WhereClause{Operand: whereOr, Clauses: []WhereClause{later, {Field: name, Operand: whereNull}}}
+	}
+
+	return later, WhereClause{Field: name, Value: key, Operand: whereEqual}
+}
+
+/* rankClauses is keyClauses for `#relevance`. Ranks are positions among the pids
+ * the search found, so the rows on either side of one are the pids before or after
+ * it. */
+func (frag *SqlFragment) rankClauses(term orderTerm, rank int) (WhereClause, WhereClause) {
+	pids := frag.Search.Pids
+	primary := frag.Target.PrimaryKey()
+
+	/* Relevance descends as rank ascends */
+	later := pids[:rank]
+	if term.Descending {
+		later = pids[minInt(rank+1, len(pids)):]
+	}
+
+	same := pids[rank:minInt(rank+1, len(pids))]
+
+	return WhereClause{Field: primary, Value: later, Operand: whereIn}, WhereClause{Field: primary, Value: same, Operand: whereIn}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+/* withKeyColumns adds the columns of any keys missing from a projection, which a
+ * cursor needs the values of. */
+func (frag *SqlFragment) withKeyColumns() {
+	if len(frag.Columns) == 0 {
+		return
+	}
+
+	for _, term := range frag.Keys {
+		if !term.Relevance && !containsString(frag.Columns, term.Field.Column) {
+			frag.Columns = append(frag.Columns, term.Field.Column)
+		}
+	}
+}
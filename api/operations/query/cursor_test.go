@@ -0,0 +1,158 @@
+package query
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"macrobooru/api"
+	"macrobooru/api/pluggable"
+	"macrobooru/api/pluggable/searchindexes/memory"
+	"macrobooru/models"
+)
+
+func TestCursor(t *testing.T) {
+	request := `{ "images" : { "model" : "Image", "order" : "uploadedDate DESC", "limit" : 2%s } }`
+
+	frag, er := decomposeTestQuery(t, strings.Replace(request, "%s", "", 1), "images")
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	last := models.Image{Pid: models.NewGUID(), UploadedDate: time.Unix(1000, 0)}
+
+	cursor, er := frag.cursor(last)
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	frag, er = decomposeTestQuery(t, strings.Replace(request, "%s", `, "after" : "`+cursor+`"`, 1), "images")
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	sql, args := frag.toSQL()
+	expected := `SELECT "alias1".* FROM "Image" AS "alias1" WHERE ("alias1"."uploadedDate" < $1 OR ("alias1"."uploadedDate" = $2 AND "alias1"."pid" > $3)) ORDER BY "alias1"."uploadedDate" DESC, "alias1"."pid" ASC LIMIT 2 OFFSET 0`
+
+	if sql != expected {
+		t.Errorf("Incorrect SQL, got\n%s\nexpected\n%s", sql, expected)
+	}
+
+	if !reflect.DeepEqual(args, []interface{}{int64(1000), int64(1000), last.Pid.String()}) {
+		t.Errorf("Incorrect args, got %#v", args)
+	}
+
+	/* The total is of everything, not just what follows the cursor */
+	if sql, _ := frag.toCountSQL(); strings.Contains(sql, "WHERE") {
+		t.Errorf("unexpected count SQL %s", sql)
+	}
+
+	/* Cursors only resume the order they were made for */
+	for _, bad := range []string{
+		`, "after" : "` + cursor + `", "order" : "uploadedDate"`,
+		`, "after" : "not a cursor"`,
+		`, "after" : "` + cursor + `", "aggregate" : { "count" : true }`,
+	} {
+		_, er := decomposeTestQuery(t, strings.Replace(request, "%s", bad, 1), "images")
+
+		if apiEr, ok := er.(*api.ApiError); !ok || apiEr.Code() != api.ErrCodeInvalidInputField {
+			t.Errorf("%s: expected invalid_input_field, got %v", bad, er)
+		}
+	}
+}
+
+func TestCursorNulls(t *testing.T) {
+	/* Only User has nullable fields, and clients can't name it, so the fragments
+	 * are built by hand */
+	fragment := func(after string) *SqlFragment {
+		frag := &SqlFragment{
+			Table:   models.UserMeta.TableName(),
+			Alias:   "alias1",
+			Target:  models.UserMeta,
+			Columns: []string{"pid", "username"},
+		}
+
+		var er error
+		if frag.Keys, er = keyTerms(OrderClause{"twitterID"}, models.UserMeta); er != nil {
+			t.Fatal(er)
+		}
+
+		frag.withKeyColumns()
+
+		if after != "" {
+			clause, er := frag.afterClause(after)
+			if er != nil {
+				t.Fatal(er)
+			}
+
+			frag.After = []WhereClause{clause}
+		}
+
+		return frag
+	}
+
+	frag := fragment("")
+
+	/* The order needs selecting to make cursors from */
+	if sql, _ := frag.toSQL(); !strings.HasPrefix(sql, `SELECT "alias1"."pid", "alias1"."username", "alias1"."twitterID" FROM`) {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+
+	twitter := "someone"
+	cases := []struct {
+		twitterID *string
+		where     string
+	}{
+		{&twitter, `WHERE (("alias1"."twitterID" > $1 OR "alias1"."twitterID" IS NULL) OR ("alias1"."twitterID" = $2 AND "alias1"."pid" > $3))`},
+		{nil, `WHERE (0 = 1 OR ("alias1"."twitterID" IS NULL AND "alias1"."pid" > $1))`},
+	}
+
+	for _, c := range cases {
+		cursor, er := frag.cursor(&models.User{Pid: models.NewGUID(), TwitterID: c.twitterID})
+		if er != nil {
+			t.Fatal(er)
+		}
+
+		if sql, _ := fragment(cursor).toSQL(); !strings.Contains(sql, c.where) {
+			t.Errorf("expected %s, got %s", c.where, sql)
+		}
+	}
+}
+
+func TestCursorRelevance(t *testing.T) {
+	index := memory.NewSearchIndex()
+	pluggable.RegisterSearchIndex("Comment", "contents", index)
+	defer pluggable.RegisterSearchIndex("Comment", "contents", nil)
+
+	best, worst := models.NewGUID(), models.NewGUID()
+	index.Index(best, "cat cat cat")
+	index.Index(worst, "cat and dog")
+
+	request := `{ "c" : { "model" : "Comment", "search" : { "contents" : "cat" }, "order" : "#relevance"%s } }`
+
+	frag, er := decomposeTestQuery(t, strings.Replace(request, "%s", "", 1), "c")
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	cursor, er := frag.cursor(models.Comment{Pid: best})
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	frag, er = decomposeTestQuery(t, strings.Replace(request, "%s", `, "after" : "`+cursor+`"`, 1), "c")
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	/* Following the best match is everything worse, or as good with a greater pid */
+	after := frag.After[0].Clauses
+	if len(after) != 2 || !reflect.DeepEqual(after[0].Clauses[0].Value, []interface{}{worst.String()}) {
+		t.Errorf("unexpected clause %#v", frag.After)
+	}
+
+	if !reflect.DeepEqual(after[1].Clauses[0].Value, []interface{}{best.String()}) {
+		t.Errorf("unexpected clause %#v", frag.After)
+	}
+}
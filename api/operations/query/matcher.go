@@ -0,0 +1,373 @@
+package query
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"macrobooru/api"
+	"macrobooru/models"
+)
+
+/* Matcher evaluates where clauses against objects in memory, with the same meaning
+ * they have when run as SQL. It's used to decide which changes a subscription
+ * should hear about. */
+type Matcher struct {
+	Model models.ModelMeta
+	where []WhereClause
+}
+
+func NewMatcher(modelName string, where map[string]interface{}) (*Matcher, error) {
+	model := models.ModelByName(modelName)
+	if model == nil {
+		return nil, api.ErrorInvalidInputField("model")
+	}
+
+	clauses, er := desugarWhere(where, model)
+	if er != nil {
+		return nil, er
+	}
+
+	return &Matcher{
+		Model: model,
+		where: clauses,
+	}, nil
+}
+
+/* Match reports whether obj (a model struct, or a pointer to one) satisfies every
+ * where clause. */
+func (m *Matcher) Match(obj interface{}) bool {
+	val := reflect.Indirect(reflect.ValueOf(obj))
+	if !val.IsValid() || val.Type() != m.Model.Type() {
+		return false
+	}
+
+	return allMatch(m.where, val)
+}
+
+func allMatch(clauses []WhereClause, val reflect.Value) bool {
+	for _, clause := range clauses {
+		if !objectMatches(clause, val) {
+			return false
+		}
+	}
+
+	return true
+}
+
+/* objectMatches evaluates one where clause, which may be a group, against val. A
+ * clause on a column the model doesn't have never holds. */
+func objectMatches(clause WhereClause, val reflect.Value) bool {
+	switch clause.Operand {
+	case whereAnd:
+		return allMatch(clause.Clauses, val)
+
+	case whereOr:
+		for _, child := range clause.Clauses {
+			if objectMatches(child, val) {
+				return true
+			}
+		}
+
+		return false
+
+	case whereNot:
+		return !allMatch(clause.Clauses, val)
+	}
+
+	idx, ok := columnField(val.Type(), clause.Field)
+	if !ok {
+		return false
+	}
+
+	return clauseMatches(clause, val.Field(idx))
+}
+
+/* columnField finds the struct field stored in the given column. */
+func columnField(modelType reflect.Type, column string) (int, bool) {
+	for i := 0; i < modelType.NumField(); i += 1 {
+		crudTag := strings.Split(modelType.Field(i).Tag.Get("crud"), ",")[0]
+
+		if crudTag == column {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+func clauseMatches(clause WhereClause, field reflect.Value) bool {
+	switch clause.Operand {
+	case whereNotIn:
+		for _, value := range clause.Value.([]interface{}) {
+			/* A value that can't be compared is NULL, which NOT IN never holds for */
+			if cmp, ok := compareField(field, value); !ok || cmp == 0 {
+				return false
+			}
+		}
+
+		return true
+
+	case whereBetween:
+		bounds := clause.Value.([]interface{})
+
+		low, ok := compareField(field, bounds[0])
+		if !ok {
+			return false
+		}
+
+		high, ok := compareField(field, bounds[1])
+		return ok && low >= 0 && high <= 0
+
+	case whereLike, whereILike:
+		return likeMatches(field, clause.Value.(string), clause.Operand == whereILike)
+	}
+
+	/* As in SQL, a list of values means IN */
+	if slice, ok := clause.Value.([]interface{}); ok {
+		for _, value := range slice {
+			if cmp, ok := compareField(field, value); ok && cmp == 0 {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	switch clause.Operand {
+	case whereNull:
+		return isNullField(field)
+
+	case whereNotNull:
+		return !isNullField(field)
+	}
+
+	cmp, ok := compareField(field, clause.Value)
+	if !ok {
+		return false
+	}
+
+	switch clause.Operand {
+	case whereGreater:
+		return cmp > 0
+
+	case whereGreaterEqual:
+		return cmp >= 0
+
+	case whereLess:
+		return cmp < 0
+
+	case whereLessEqual:
+		return cmp <= 0
+
+	case whereNotEqual:
+		return cmp != 0
+	}
+
+	return cmp == 0
+}
+
+var (
+	guidType = reflect.TypeOf(models.GUID{})
+	timeType = reflect.TypeOf(time.Time{})
+)
+
+func isNullField(field reflect.Value) bool {
+	switch field.Type() {
+	case guidType:
+		return !field.Interface().(models.GUID).IsValid()
+
+	case timeType:
+		return field.Interface().(time.Time).IsZero()
+	}
+
+	return field.Kind() == reflect.Ptr && field.IsNil()
+}
+
+/* compareField compares a field with a value from a where clause, in whatever way
+ * the database would compare the column with it. ok is false if they can't be
+ * compared. */
+func compareField(field reflect.Value, value interface{}) (cmp int, ok bool) {
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			return 0, false
+		}
+
+		field = field.Elem()
+	}
+
+	switch field.Type() {
+	case guidType:
+		str, ok := value.(string)
+		if !ok {
+			return 0, false
+		}
+
+		guid, er := models.GUIDFromString(str)
+		if er != nil {
+			return 0, false
+		}
+
+		return strings.Compare(field.Interface().(models.GUID).String(), guid.String()), true
+
+	case timeType:
+		if t, ok := value.(time.Time); ok {
+			return compareTimes(field.Interface().(time.Time), t), true
+		}
+
+		/* Otherwise, the timestamp is stored as UNIX time */
+		unix, ok := numericValue(value)
+		if !ok {
+			return 0, false
+		}
+
+		return compareFloats(float64(field.Interface().(time.Time).Unix()), unix), true
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		str, ok := value.(string)
+		if !ok {
+			str = fmt.Sprint(value)
+		}
+
+		return strings.Compare(field.String(), str), true
+
+	case reflect.Bool:
+		other, ok := numericValue(value)
+		if !ok {
+			return 0, false
+		}
+
+		mine := 0.0
+		if field.Bool() {
+			mine = 1
+		}
+
+		return compareFloats(mine, other), true
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		other, ok := numericValue(value)
+		if !ok {
+			return 0, false
+		}
+
+		return compareFloats(float64(field.Int()), other), true
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		other, ok := numericValue(value)
+		if !ok {
+			return 0, false
+		}
+
+		return compareFloats(float64(field.Uint()), other), true
+
+	case reflect.Float32, reflect.Float64:
+		other, ok := numericValue(value)
+		if !ok {
+			return 0, false
+		}
+
+		return compareFloats(field.Float(), other), true
+	}
+
+	return 0, false
+}
+
+/* likeMatches matches a string field against a LIKE pattern, or an ILIKE pattern
+ * if fold is set. */
+func likeMatches(field reflect.Value, pattern string, fold bool) bool {
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			return false
+		}
+
+		field = field.Elem()
+	}
+
+	if field.Kind() != reflect.String {
+		return false
+	}
+
+	expr := "(?s)^"
+	if fold {
+		expr = "(?is)^"
+	}
+
+	for idx := 0; idx < len(pattern); idx += 1 {
+		switch c := pattern[idx]; {
+		case c == likeEscape && idx+1 < len(pattern):
+			idx += 1
+			expr += regexp.QuoteMeta(pattern[idx : idx+1])
+
+		case c == '%':
+			expr += ".*"
+
+		case c == '_':
+			expr += "."
+
+		default:
+			expr += regexp.QuoteMeta(pattern[idx : idx+1])
+		}
+	}
+
+	re, er := regexp.Compile(expr + "$")
+	return er == nil && re.MatchString(field.String())
+}
+
+func numericValue(value interface{}) (float64, bool) {
+	switch val := value.(type) {
+	case float64:
+		return val, true
+
+	case float32:
+		return float64(val), true
+
+	case int64:
+		return float64(val), true
+
+	case int:
+		return float64(val), true
+
+	case uint64:
+		return float64(val), true
+
+	case bool:
+		if val {
+			return 1, true
+		}
+
+		return 0, true
+
+	case string:
+		f, er := strconv.ParseFloat(val, 64)
+		return f, er == nil
+	}
+
+	return 0, false
+}
+
+func compareTimes(a, b time.Time) int {
+	if a.Before(b) {
+		return -1
+
+	} else if a.After(b) {
+		return 1
+	}
+
+	return 0
+}
+
+func compareFloats(a, b float64) int {
+	if a < b {
+		return -1
+
+	} else if a > b {
+		return 1
+	}
+
+	return 0
+}
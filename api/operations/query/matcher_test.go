@@ -0,0 +1,92 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"macrobooru/models"
+)
+
+func TestMatcher(t *testing.T) {
+	image := &models.Image{
+		Pid:            models.NewGUID(),
+		Mime:           "image/png",
+		UploadedDate:   time.Unix(1000, 0),
+		RatingsAverage: 3.5,
+	}
+
+	tests := []struct {
+		where map[string]interface{}
+		match bool
+	}{
+		{map[string]interface{}{}, true},
+		{map[string]interface{}{"mime": "image/png"}, true},
+		{map[string]interface{}{"mime": "image/gif"}, false},
+		{map[string]interface{}{"mime !=": "image/gif"}, true},
+		{map[string]interface{}{"mime": []interface{}{"image/gif", "image/png"}}, true},
+		{map[string]interface{}{"ratingsAverage >": 3.0}, true},
+		{map[string]interface{}{"ratingsAverage >=": 3.5, "mime": "image/png"}, true},
+		{map[string]interface{}{"ratingsAverage <": 3.5}, false},
+		{map[string]interface{}{"uploadedDate <=": 1000.0}, true},
+		{map[string]interface{}{"#primary": image.Pid.String()}, true},
+		{map[string]interface{}{"pid": models.NewGUID().String()}, false},
+		{map[string]interface{}{"$or": []interface{}{
+			map[string]interface{}{"mime": "image/gif"},
+			map[string]interface{}{"ratingsAverage >": 3.0},
+		}}, true},
+		{map[string]interface{}{"$or": []interface{}{
+			map[string]interface{}{"mime": "image/gif"},
+			map[string]interface{}{"ratingsAverage >": 3.0, "mime": "image/jpeg"},
+		}}, false},
+		{map[string]interface{}{"$not": map[string]interface{}{"mime": "image/gif"}}, true},
+		{map[string]interface{}{"$not": map[string]interface{}{"mime": "image/png", "ratingsAverage": 3.5}}, false},
+		{map[string]interface{}{"mime LIKE": "image/%"}, true},
+		{map[string]interface{}{"mime LIKE": "IMAGE/%"}, false},
+		{map[string]interface{}{"mime ILIKE": "IMAGE/_NG"}, true},
+		{map[string]interface{}{"mime PREFIX": "image/p"}, true},
+		{map[string]interface{}{"mime PREFIX": "image_"}, false},
+		{map[string]interface{}{"mime IN": []interface{}{"image/gif", "image/png"}}, true},
+		{map[string]interface{}{"mime NOTIN": []interface{}{"image/gif", "image/png"}}, false},
+		{map[string]interface{}{"mime NOTIN": []interface{}{"image/gif"}}, true},
+		{map[string]interface{}{"ratingsAverage BETWEEN": []interface{}{3.0, 3.5}}, true},
+		{map[string]interface{}{"ratingsAverage": "BETWEEN 1 AND 3"}, false},
+		{map[string]interface{}{"uploadedDate BETWEEN": []interface{}{"1970-01-01T00:00:00Z", "1970-01-01T00:20:00Z"}}, true},
+	}
+
+	for _, test := range tests {
+		matcher, er := NewMatcher("Image", test.where)
+		if er != nil {
+			t.Fatalf("%v: %s", test.where, er)
+		}
+
+		if match := matcher.Match(image); match != test.match {
+			t.Errorf("%v: expected %v, got %v", test.where, test.match, match)
+		}
+	}
+
+	/* Objects of other models never match */
+	matcher, _ := NewMatcher("Image", nil)
+	if matcher.Match(&models.Tag{}) {
+		t.Errorf("matched an object of the wrong model")
+	}
+}
+
+func TestMatcherRejectsUnknownFields(t *testing.T) {
+	if _, er := NewMatcher("Image", map[string]interface{}{"bogus": 1.0}); er == nil {
+		t.Errorf("expected an error for an unknown field")
+	}
+
+	if _, er := NewMatcher("Bogus", nil); er == nil {
+		t.Errorf("expected an error for an unknown model")
+	}
+
+	/* A clause that somehow names a missing column doesn't fall back on another */
+	matcher := &Matcher{
+		Model: models.ModelByName("Image"),
+		where: []WhereClause{{Field: "bogus", Value: nil, Operand: whereNotNull}},
+	}
+
+	if matcher.Match(&models.Image{}) {
+		t.Errorf("matched on a missing column")
+	}
+}
@@ -0,0 +1,264 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/ugorji/go/codec"
+
+	"macrobooru/api"
+	"macrobooru/models"
+)
+
+/* OrderClause is the `order` of a query: a list of "field ASC|DESC" terms, most
+ * significant first. On the wire a single term may be sent as a plain string
+ * rather than an array. */
+type OrderClause []string
+
+func (order OrderClause) value() interface{} {
+	if len(order) == 1 {
+		return order[0]
+	}
+
+	return []string(order)
+}
+
+func (order OrderClause) MarshalJSON() ([]byte, error) {
+	return json.Marshal(order.value())
+}
+
+func (order *OrderClause) UnmarshalJSON(bs []byte) error {
+	var raw interface{}
+
+	if er := json.Unmarshal(bs, &raw); er != nil {
+		return er
+	}
+
+	return order.fromValue(raw)
+}
+
+func (order *OrderClause) CodecEncodeSelf(e *codec.Encoder) {
+	e.MustEncode(order.value())
+}
+
+func (order *OrderClause) CodecDecodeSelf(d *codec.Decoder) {
+	var raw interface{}
+	d.MustDecode(&raw)
+
+	if er := order.fromValue(raw); er != nil {
+		panic(er)
+	}
+}
+
+func (order *OrderClause) fromValue(raw interface{}) error {
+	switch val := raw.(type) {
+	case nil:
+		*order = nil
+
+	case string:
+		*order = OrderClause{val}
+
+	case []interface{}:
+		terms := OrderClause{}
+
+		for _, term := range val {
+			str, ok := term.(string)
+			if !ok {
+				return api.ErrorInvalidInputField("order")
+			}
+
+			terms = append(terms, str)
+		}
+
+		*order = terms
+
+	default:
+		return api.ErrorInvalidInputField("order")
+	}
+
+	return nil
+}
+
+type orderTerm struct {
+	Field      modelField
+	Descending bool
+
+	// Relevance is set for `#relevance`, which orders by how well objects match
+	// the search clauses. Unlike other terms it's descending unless told otherwise.
+	Relevance bool
+}
+
+const relevanceTerm = "#relevance"
+
+/* parseOrder resolves each term of order to a column of model. */
+func parseOrder(order OrderClause, model models.ModelMeta) ([]orderTerm, error) {
+	terms := []orderTerm{}
+
+	for _, clause := range order {
+		parts := strings.Fields(clause)
+		if len(parts) == 0 || len(parts) > 2 {
+			return nil, api.ErrorInvalidInputField("order")
+		}
+
+		term := orderTerm{}
+
+		if parts[0] == relevanceTerm {
+			term.Relevance, term.Descending = true, true
+
+		} else if field, ok := fieldInfo(model, parts[0]); ok {
+			term.Field = field
+
+		} else {
+			return nil, api.ErrorInvalidInputField(parts[0])
+		}
+
+		if len(parts) == 2 {
+			switch strings.ToUpper(parts[1]) {
+			case "ASC":
+				term.Descending = false
+
+			case "DESC":
+				term.Descending = true
+
+			default:
+				return nil, api.ErrorInvalidInputField("order")
+			}
+		}
+
+		terms = append(terms, term)
+	}
+
+	return terms, nil
+}
+
+/* fieldColumn maps the name a client uses for a field (its JSON name, or
+ * `#primary`) onto its column. This is the only way a client-supplied name makes
+ * it into SQL; anything the model doesn't have is refused. */
+func fieldColumn(model models.ModelMeta, name string) (string, bool) {
+	field, ok := fieldInfo(model, name)
+	return field.Column, ok
+}
+
+/* modelField describes the column behind a field a client named. */
+type modelField struct {
+	Column string
+	Type   reflect.Type
+
+	// Unix is set for timestamps stored as UNIX time (`crud:",unix"`).
+	Unix bool
+}
+
+/* fieldInfo is fieldColumn, along with what's stored in the column. */
+func fieldInfo(model models.ModelMeta, name string) (modelField, bool) {
+	column := model.PrimaryKey()
+
+	if name != "#primary" {
+		var ok bool
+
+		if column, _, ok = models.JsonFieldInfo(model, name); !ok {
+			return modelField{}, false
+		}
+	}
+
+	idx, ok := columnField(model.Type(), column)
+	if !ok {
+		return modelField{}, false
+	}
+
+	structField := model.Type().Field(idx)
+	return modelField{
+		Column: column,
+		Type:   structField.Type,
+		Unix:   isUnixField(structField),
+	}, true
+}
+
+/* isUnixField is whether a struct field is a timestamp stored as UNIX time. */
+func isUnixField(structField reflect.StructField) bool {
+	for _, option := range strings.Split(structField.Tag.Get("crud"), ",")[1:] {
+		if option == "unix" {
+			return true
+		}
+	}
+
+	return false
+}
+
+/* orderSQL builds the ORDER BY list for a fragment over model, along with its
+ * arguments. The primary key is always the last resort, so that rows which tie on
+ * everything else (or queries with no order at all) still come back in the same
+ * order every time, which is what makes paging with limit/offset work. */
+func orderSQL(terms []orderTerm, model models.ModelMeta, alias string, search *searchResult) (string, []interface{}) {
+	bits := []string{}
+	args := []interface{}{}
+	sawPrimary := false
+
+	for _, term := range terms {
+		direction := "ASC"
+		if term.Descending {
+			direction = "DESC"
+		}
+
+		if term.Relevance {
+			/* With nothing found, there's nothing to order */
+			if len(search.Pids) == 0 {
+				continue
+			}
+
+			/* Ranks count up from the best match, so relevance falls as they rise */
+			direction = "DESC"
+			if term.Descending {
+				direction = "ASC"
+			}
+
+			rank, rankArgs := rankSQL(column(alias, model.PrimaryKey()), search.Pids)
+
+			bits = append(bits, fmt.Sprintf("%s %s", rank, direction))
+			args = append(args, rankArgs...)
+			continue
+		}
+
+		bits = append(bits, fmt.Sprintf("%s %s", column(alias, term.Field.Column), direction))
+
+		if term.Field.Column == model.PrimaryKey() {
+			sawPrimary = true
+		}
+	}
+
+	if !sawPrimary {
+		bits = append(bits, fmt.Sprintf("%s ASC", column(alias, model.PrimaryKey())))
+	}
+
+	return strings.Join(bits, ", "), args
+}
+
+/* rankSQL numbers each row by the position of its primary key among pids. */
+func rankSQL(primary string, pids []interface{}) (string, []interface{}) {
+	bits := make([]string, len(pids))
+
+	for idx := range pids {
+		bits[idx] = fmt.Sprintf("WHEN ? THEN %d", idx)
+	}
+
+	return fmt.Sprintf("CASE %s %s ELSE %d END", primary, strings.Join(bits, " "), len(pids)), pids
+}
+
+/* orderFor builds the ORDER BY list for the results of qr, which are of model, and
+ * were found by search (nil if the results weren't searched for). */
+func (qr *QueryRequest) orderFor(model models.ModelMeta, alias string, search *searchResult) (string, []interface{}, error) {
+	terms, er := parseOrder(qr.Order, model)
+	if er != nil {
+		return "", nil, er
+	}
+
+	for _, term := range terms {
+		if term.Relevance && search == nil {
+			return "", nil, api.ErrorInvalidInputField(relevanceTerm)
+		}
+	}
+
+	order, args := orderSQL(terms, model, alias, search)
+	return order, args, nil
+}
@@ -0,0 +1,100 @@
+package query
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func decomposeTestQuery(t *testing.T, jsonBytes string, name string) (*SqlFragment, error) {
+	var payload QueryPayload
+	if er := json.Unmarshal([]byte(jsonBytes), &payload); er != nil {
+		t.Fatal(er)
+	}
+
+	request, ok := payload.GetNamedRequest(name)
+	if !ok {
+		t.Fatalf("unable to fetch named request")
+	}
+
+	return request.Decompose(payload)
+}
+
+func TestOrderClause(t *testing.T) {
+	cases := []struct {
+		order    string
+		expected string
+	}{
+		{`null`, "\"alias1\".\"pid\" ASC"},
+		{`"mime"`, "\"alias1\".\"mime\" ASC, \"alias1\".\"pid\" ASC"},
+		{`"uploadedDate desc"`, "\"alias1\".\"uploadedDate\" DESC, \"alias1\".\"pid\" ASC"},
+		{`["mime DESC", "ratingsAverage"]`, "\"alias1\".\"mime\" DESC, \"alias1\".\"ratingsAverage\" ASC, \"alias1\".\"pid\" ASC"},
+		{`["mime", "#primary DESC"]`, "\"alias1\".\"mime\" ASC, \"alias1\".\"pid\" DESC"},
+	}
+
+	for _, c := range cases {
+		frag, er := decomposeTestQuery(t, `{ "images" : { "model" : "Image", "order" : `+c.order+` } }`, "images")
+		if er != nil {
+			t.Fatalf("%s: %s", c.order, er)
+		}
+
+		if frag.Order != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.order, c.expected, frag.Order)
+		}
+
+		sql, _ := frag.toSQL()
+		if !strings.Contains(sql, " ORDER BY "+c.expected+" LIMIT") {
+			t.Errorf("%s: order missing from %s", c.order, sql)
+		}
+	}
+}
+
+func TestOrderClauseRejectsUnknownFields(t *testing.T) {
+	for _, order := range []string{`"bogus"`, `"mime SIDEWAYS"`, `"mime ASC DESC"`, `""`, `[1]`, `"ID"`, `"mime; DROP TABLE Image"`} {
+		var payload QueryPayload
+		er := json.Unmarshal([]byte(`{ "images" : { "model" : "Image", "order" : `+order+` } }`), &payload)
+
+		if er == nil {
+			request, _ := payload.GetNamedRequest("images")
+			_, er = request.Decompose(payload)
+		}
+
+		if er == nil {
+			t.Errorf("%s: expected an error", order)
+		}
+	}
+}
+
+func TestOrderClauseOnRelation(t *testing.T) {
+	frag, er := decomposeTestQuery(t, `
+		{ "image" : { "model" : "Image", "transient" : true }
+		, "comments" : { "subgraph" : "image", "relation" : "comments", "order" : "dateCreated DESC" }
+		}`, "comments")
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	expected := column(frag.Alias, "dateCreated") + " DESC, " + column(frag.Alias, "pid") + " ASC"
+	if frag.Order != expected {
+		t.Errorf("expected %q, got %q", expected, frag.Order)
+	}
+}
+
+func TestOrderClauseRoundTrip(t *testing.T) {
+	for _, order := range []string{`"mime DESC"`, `["mime DESC","pid"]`} {
+		var clause OrderClause
+
+		if er := json.Unmarshal([]byte(order), &clause); er != nil {
+			t.Fatal(er)
+		}
+
+		bs, er := json.Marshal(clause)
+		if er != nil {
+			t.Fatal(er)
+		}
+
+		if string(bs) != order {
+			t.Errorf("expected %s, got %s", order, bs)
+		}
+	}
+}
@@ -1,15 +1,10 @@
 package query
 
 import (
-	"fmt"
 	"macrobooru/api"
 	"macrobooru/models"
 
-	"github.com/lye/crud"
-
-	"database/sql"
-	"encoding/json"
-	"reflect"
+	"context"
 )
 
 type QueryPayload map[string]QueryRequest
@@ -26,7 +21,7 @@ func (qp *QueryPayload) requests() map[string]QueryRequest {
 func (*QueryPayload) Parse(req *api.RequestWrapper) (api.Operation, error) {
 	var payload QueryPayload
 
-	if er := json.Unmarshal(req.RawData, &payload); er != nil {
+	if er := req.UnmarshalData(&payload); er != nil {
 		return nil, api.ErrorInvalidInputFormat(er.Error())
 	}
 
@@ -34,16 +29,28 @@ func (*QueryPayload) Parse(req *api.RequestWrapper) (api.Operation, error) {
 }
 
 func (*QueryPayload) ParseResponse(wrapper api.ResponseWrapper) (interface{}, error) {
-	res := QueryResponse{}
+	rawRes := map[string]rawQueryResponsePart{}
 
-	if er := json.Unmarshal(wrapper.Data, &res); er != nil {
+	if er := wrapper.UnmarshalData(&rawRes); er != nil {
 		return nil, api.ErrorGeneric(er)
 	}
 
+	res := QueryResponse{}
+
+	for name, rawPart := range rawRes {
+		var part QueryResponsePart
+
+		if er := part.fromRaw(&rawPart, wrapper.UnmarshalNested); er != nil {
+			return nil, api.ErrorGeneric(er)
+		}
+
+		res[name] = part
+	}
+
 	return res, nil
 }
 
-func (qp *QueryPayload) Process(u *models.User, db *sql.DB) (interface{}, error) {
+func (qp *QueryPayload) Execute(ctx context.Context, request *api.Request, db api.Queryer) (interface{}, error) {
 	responseMap := map[string]QueryResponsePart{}
 
 	for name, req := range qp.requests() {
@@ -51,56 +58,134 @@ func (qp *QueryPayload) Process(u *models.User, db *sql.DB) (interface{}, error)
 			continue
 		}
 
-		sqlFrag, apiEr := req.Decompose(*qp)
+		sqlFrag, apiEr := req.DecomposeContext(ctx, *qp)
 		if apiEr != nil {
 			return nil, apiEr
 		}
 
-		sql, params := sqlFrag.toCountSQL()
-		rows, er := db.Query(sql, params...)
+		part, er := executeFragment(ctx, db, sqlFrag)
 		if er != nil {
 			return nil, api.ErrorGeneric(er)
 		}
-		defer rows.Close()
 
-		var totalCount int64
+		responseMap[name] = part
+	}
 
-		if rows.Next() {
-			if er := rows.Scan(&totalCount); er != nil {
-				return nil, api.ErrorGeneric(er)
-			}
+	return QueryResponse(responseMap), nil
+}
+
+/* executeFragment fetches the results of frag. Where it can, the total comes along
+ * with the rows in a single query; otherwise it's counted first, and if there's
+ * nothing to fetch, that's all. */
+func executeFragment(ctx context.Context, db api.Queryer, frag *SqlFragment) (QueryResponsePart, error) {
+	part := QueryResponsePart{
+		Total:     -1,
+		Slice:     []interface{}{},
+		ModelName: frag.Target.Type().Name(),
+	}
+
+	counted := frag.countsOver()
+
+	if frag.CountTotal && !counted {
+		total, er := countFragment(ctx, db, frag)
+		if er != nil {
+			return part, er
+		}
+
+		part.Total = total
+		if total == 0 {
+			return part, nil
 		}
+	}
 
-		zeroVal := reflect.Zero(sqlFrag.Target.Type()).Interface()
-		slice := []interface{}{}
+	sql, params := frag.toSQL()
+	if counted {
+		sql, params = frag.toCountedSQL()
+	}
 
-		if totalCount > 0 {
-			sql, params = sqlFrag.toSQL()
-			fmt.Printf("C: %d\nQ: %s\nA: %#v\n", totalCount, sql, params)
+	api.LogQuery(ctx, sql, params)
 
-			rows, er := db.Query(sql, params...)
+	rows, er := db.QueryContext(ctx, sql, params...)
+	if er != nil {
+		return part, er
+	}
+	defer rows.Close()
+
+	/* The total is the last column of each row, if it was selected */
+	var total int64
+	var totalDest *int64
+	extra := []interface{}{}
+
+	if counted {
+		totalDest = &total
+		extra = append(extra, totalDest)
+	}
+
+	var last interface{}
+	groups := []AggregateRow{}
+
+	for rows.Next() {
+		if frag.Aggregate != nil {
+			row, er := frag.Aggregate.scan(rows, extra...)
 			if er != nil {
-				return nil, api.ErrorGeneric(er)
+				return part, er
 			}
-			defer rows.Close()
 
-			for rows.Next() {
-				if er := crud.Scan(rows, &zeroVal); er != nil {
-					return nil, api.ErrorGeneric(er)
-				}
+			groups = append(groups, row)
+			continue
+		}
+
+		if last, er = scanObject(rows, frag.Target.Type(), totalDest); er != nil {
+			return part, er
+		}
+
+		if len(frag.Fields) > 0 {
+			part.Slice = append(part.Slice, projection{last, frag.Fields})
+		} else {
+			part.Slice = append(part.Slice, models.WireForm(last))
+		}
+	}
 
-				slice = append(slice, zeroVal)
+	if er := rows.Err(); er != nil {
+		return part, er
+	}
+
+	/* Done with the rows before any count below */
+	rows.Close()
+
+	if counted {
+		part.Total = total
+
+		/* Past the end, there are no rows to carry the total */
+		if len(part.Slice) == 0 && len(groups) == 0 && frag.Offset > 0 {
+			if part.Total, er = countFragment(ctx, db, frag); er != nil {
+				return part, er
 			}
 		}
+	}
+
+	if frag.Aggregate != nil {
+		part.Groups = groups
 
-		responseMap[name] = QueryResponsePart{
-			Total:     totalCount,
-			Slice:     slice,
-			ModelName: sqlFrag.Target.Type().Name(),
+	} else if int64(len(part.Slice)) == frag.Limit {
+		/* A full page may not be the last, so can be resumed from */
+		if part.Cursor, er = frag.cursor(last); er != nil {
+			return part, er
 		}
 	}
 
-	return QueryResponse(responseMap), nil
+	return part, nil
+}
+
+/* countFragment counts the results of frag, before the limit and offset. */
+func countFragment(ctx context.Context, db api.Queryer, frag *SqlFragment) (int64, error) {
+	sql, params := frag.toCountSQL()
+	api.LogQuery(ctx, sql, params)
+
+	var total int64
+	er := db.QueryRowContext(ctx, sql, params...).Scan(&total)
+
+	return total, er
 }
 
 func (qp *QueryPayload) GetNamedRequest(name string) (QueryRequest, bool) {
@@ -0,0 +1,69 @@
+package query
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/ugorji/go/codec"
+
+	"macrobooru/api"
+	"macrobooru/models"
+)
+
+/* projectFields checks the fields a request asks for against model, returning
+ * their JSON names and columns; nothing is returned if fields is empty, meaning
+ * every field. The primary key is always included, whether asked for or not. */
+func projectFields(fields []string, model models.ModelMeta) ([]string, []string, error) {
+	if len(fields) == 0 {
+		return nil, nil, nil
+	}
+
+	primary := model.PrimaryKey()
+	idx, _ := columnField(model.Type(), primary)
+
+	names := []string{strings.Split(model.Type().Field(idx).Tag.Get("json"), ",")[0]}
+	columns := []string{primary}
+
+	for _, name := range fields {
+		field, ok := fieldInfo(model, name)
+		if !ok {
+			return nil, nil, api.ErrorInvalidInputField(name)
+		}
+
+		if !containsString(columns, field.Column) {
+			names = append(names, name)
+			columns = append(columns, field.Column)
+		}
+	}
+
+	return names, columns, nil
+}
+
+func containsString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+
+	return false
+}
+
+/* projection is an object of a query's results, sent with only some of its
+ * fields. It's never decoded; clients decode the part as the model it is. */
+type projection struct {
+	object interface{}
+	fields []string
+}
+
+func (p projection) MarshalJSON() ([]byte, error) {
+	return json.Marshal(models.ProjectFields(p.object, p.fields))
+}
+
+func (p projection) CodecEncodeSelf(e *codec.Encoder) {
+	e.MustEncode(models.ProjectFields(p.object, p.fields))
+}
+
+func (p projection) CodecDecodeSelf(d *codec.Decoder) {
+	panic("query: projections are only ever encoded")
+}
@@ -0,0 +1,86 @@
+package query
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"macrobooru/api"
+	"macrobooru/models"
+)
+
+func TestFieldsClause(t *testing.T) {
+	frag, er := decomposeTestQuery(t, `{ "images" : { "model" : "Image", "fields" : ["mime", "filehash", "mime"] } }`, "images")
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	sql, _ := frag.toSQL()
+	if !strings.HasPrefix(sql, `SELECT "alias1"."pid", "alias1"."mime", "alias1"."filehash" FROM "Image" AS "alias1"`) {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+
+	if strings.Join(frag.Fields, ",") != "pid,mime,filehash" {
+		t.Errorf("unexpected fields %v", frag.Fields)
+	}
+
+	/* Subgraphs joined with aren't projected */
+	frag, er = decomposeTestQuery(t, `
+		{ "image" : { "model" : "Image", "fields" : ["mime"], "transient" : true }
+		, "comments" : { "subgraph" : "image", "relation" : "comments", "fields" : ["contents"] }
+		}`, "comments")
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	if sql, _ := frag.toSQL(); !strings.HasPrefix(sql, `SELECT "alias2"."pid", "alias2"."contents" FROM`) {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+
+	for _, fields := range []string{`["mime", "bogus"]`, `["ID"]`, `["pid; DROP TABLE Image"]`} {
+		_, er := decomposeTestQuery(t, `{ "images" : { "model" : "Image", "fields" : `+fields+` } }`, "images")
+
+		if apiEr, ok := er.(*api.ApiError); !ok || apiEr.Code() != api.ErrCodeInvalidInputField {
+			t.Errorf("%s: expected invalid_input_field, got %v", fields, er)
+		}
+	}
+}
+
+func TestProjectionEncoding(t *testing.T) {
+	image := models.Image{
+		Pid:          models.NewGUID(),
+		Mime:         "image/png",
+		UploadedDate: time.Unix(1000, 0),
+	}
+
+	for _, codec := range []api.Codec{api.JSONCodec, api.MsgpackCodec, api.CBORCodec} {
+		bs, er := codec.Marshal(projection{image, []string{"pid", "mime"}})
+		if er != nil {
+			t.Fatalf("%s: %s", codec.ContentType(), er)
+		}
+
+		var fields map[string]interface{}
+		if er := codec.Unmarshal(bs, &fields); er != nil {
+			t.Fatalf("%s: %s", codec.ContentType(), er)
+		}
+
+		if len(fields) != 2 || fields["mime"] != "image/png" {
+			t.Errorf("%s: unexpected fields %v", codec.ContentType(), fields)
+		}
+
+		/* Clients decode projections as the whole model, with the rest left zero */
+		var decoded models.Image
+		if er := codec.Unmarshal(bs, &decoded); er != nil {
+			t.Fatalf("%s: %s", codec.ContentType(), er)
+		}
+
+		if !decoded.Pid.Equal(image.Pid) || decoded.Mime != image.Mime || !decoded.UploadedDate.IsZero() {
+			t.Errorf("%s: unexpected decoding %#v", codec.ContentType(), decoded)
+		}
+	}
+
+	if _, er := json.Marshal(projection{&image, []string{"pid"}}); er != nil {
+		t.Error(er)
+	}
+}
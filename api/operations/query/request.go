@@ -1,8 +1,8 @@
 package query
 
 import (
+	"context"
 	"fmt"
-	"strings"
 
 	"macrobooru/api"
 	"macrobooru/models"
@@ -15,81 +15,89 @@ type QueryRequest struct {
 	Relation      string                 `json:"relation,omitempty"`
 	Subgraph      string                 `json:"subgraph,omitempty"`
 	Transient     bool                   `json:"transient,omitempty"`
-	Order         string                 `json:"order,omitempty"`
+	Order         OrderClause            `json:"order,omitempty"`
 	Limit         int64                  `json:"limit,omitempty"`
 	Offset        int64                  `json:"offset,omitempty"`
 	SearchClauses map[string]string      `json:"search,omitempty"`
+	Fields        []string               `json:"fields,omitempty"`
+	Aggregate     *AggregateClause       `json:"aggregate,omitempty"`
+	After         string                 `json:"after,omitempty"`
+
+	// CountTotal, if false, skips counting the results; the total sent is -1.
+	CountTotal *bool `json:"total,omitempty"`
 }
 
 func (qr *QueryRequest) Decompose(payload QueryPayload) (*SqlFragment, error) {
-	seenSubgraphs := []string{}
-	return qr.decompose(payload, &seenSubgraphs)
+	return qr.DecomposeContext(context.Background(), payload)
 }
 
-func desugarWhere(clauses map[string]interface{}, model models.ModelMeta) []WhereClause {
-	whereClauses := []WhereClause{}
+/* DecomposeContext is Decompose, with the search clauses run under ctx. */
+func (qr *QueryRequest) DecomposeContext(ctx context.Context, payload QueryPayload) (*SqlFragment, error) {
+	seenSubgraphs := []string{}
 
-	if val, ok := clauses["#primary"]; ok {
-		delete(clauses, "#primary")
-		clauses[model.PrimaryKey()] = val
+	frag, er := qr.decompose(ctx, payload, &seenSubgraphs)
+	if er != nil {
+		return nil, er
 	}
 
-	for field, value := range clauses {
-		field = strings.TrimSpace(field)
-		fieldParts := strings.Split(field, " ")
+	frag.CountTotal = qr.CountTotal == nil || *qr.CountTotal
 
-		operand := whereEqual
-		if len(fieldParts) > 1 {
-			operandPart := fieldParts[len(fieldParts)-1]
+	/* Only the results are projected, not the subgraphs they're joined with */
+	if frag.Fields, frag.Columns, er = projectFields(qr.Fields, frag.Target); er != nil {
+		return nil, er
+	}
 
-			switch operandPart {
-			case ">":
-				operand = whereGreater
+	if qr.Aggregate != nil {
+		if len(qr.Fields) > 0 {
+			return nil, api.ErrorInvalidInputField("fields")
+		}
+
+		if qr.After != "" {
+			return nil, api.ErrorInvalidInputField("after")
+		}
 
-			case ">=":
-				operand = whereGreaterEqual
-			case "<":
-				operand = whereLess
+		if frag.Aggregate, er = qr.Aggregate.compile(frag.Target); er != nil {
+			return nil, er
+		}
 
-			case "<=":
-				operand = whereLessEqual
+		/* Groups have no primary key to fall back on, so are ordered differently */
+		if frag.Order, er = frag.Aggregate.orderSQL(qr.Order, frag.Target, frag.Alias); er != nil {
+			return nil, er
+		}
 
-			case "=":
-				fallthrough
-			case "==":
-				operand = whereEqual
+		frag.OrderArgs = nil
+		return frag, nil
+	}
 
-			case "!=":
-				operand = whereNotEqual
+	/* Each page of results carries a cursor, made of the keys it's ordered by */
+	if frag.Keys, er = keyTerms(qr.Order, frag.Target); er != nil {
+		return nil, er
+	}
 
-			case "NULL":
-				operand = whereNull
+	frag.withKeyColumns()
 
-			case "NOTNULL":
-				operand = whereNotNull
-			}
+	if qr.After != "" {
+		after, er := frag.afterClause(qr.After)
+		if er != nil {
+			return nil, er
 		}
 
-		whereClauses = append(whereClauses, WhereClause{
-			Field:   fieldParts[0],
-			Value:   value,
-			Operand: operand,
-		})
+		frag.After = []WhereClause{after}
 	}
 
-	return whereClauses
+	return frag, nil
 }
 
-func (qr *QueryRequest) decompose(payload QueryPayload, seenSubgraphs *[]string) (*SqlFragment, error) {
+func (qr *QueryRequest) decompose(ctx context.Context, payload QueryPayload, seenSubgraphs *[]string) (*SqlFragment, error) {
 	if qr.Relation != "" {
 		if qr.Subgraph != "" {
-			return qr.decomposeRelationSubgraph(payload, seenSubgraphs)
+			return qr.decomposeRelationSubgraph(ctx, payload, seenSubgraphs)
 		} else {
-			return qr.decomposeRelation(payload, seenSubgraphs)
+			return qr.decomposeRelation(ctx, payload, seenSubgraphs)
 		}
 
 	} else if qr.ModelName != "" {
-		return qr.decomposeDirect(payload, seenSubgraphs)
+		return qr.decomposeDirect(ctx, payload, seenSubgraphs)
 	}
 
 	return nil, api.ErrorInvalidInputFormat("must supply either model or subgraph fields")
@@ -100,26 +108,40 @@ func nextUnnamedAlias(seenSubgraphs *[]string) string {
 	return fmt.Sprintf("alias%d", len(*seenSubgraphs))
 }
 
-func (qr *QueryRequest) decomposeDirect(payload QueryPayload, seenSubgraphs *[]string) (*SqlFragment, error) {
+func (qr *QueryRequest) decomposeDirect(ctx context.Context, payload QueryPayload, seenSubgraphs *[]string) (*SqlFragment, error) {
 	model := models.ModelByName(qr.ModelName)
 	if model == nil {
 		return nil, api.ErrorInvalidInputFormat("model does not exist")
 	}
 
+	where, search, er := qr.constraints(ctx, model)
+	if er != nil {
+		return nil, er
+	}
+
+	alias := nextUnnamedAlias(seenSubgraphs)
+
+	order, orderArgs, er := qr.orderFor(model, alias, search)
+	if er != nil {
+		return nil, er
+	}
+
 	frag := &SqlFragment{
-		Table:  model.TableName(),
-		Alias:  nextUnnamedAlias(seenSubgraphs),
-		Where:  desugarWhere(qr.WhereClauses, model),
-		Limit:  qr.Limit,
-		Offset: qr.Offset,
-		Target: model,
-		/* XXX: ORDER MUST BE CONFIRMED VIA MODEL METADATA */
+		Table:     model.TableName(),
+		Alias:     alias,
+		Where:     where,
+		Limit:     qr.Limit,
+		Offset:    qr.Offset,
+		Order:     order,
+		OrderArgs: orderArgs,
+		Target:    model,
+		Search:    search,
 	}
 
 	return frag, nil
 }
 
-func (qr *QueryRequest) decomposeRelation(payload QueryPayload, seenSubgraphs *[]string) (*SqlFragment, error) {
+func (qr *QueryRequest) decomposeRelation(ctx context.Context, payload QueryPayload, seenSubgraphs *[]string) (*SqlFragment, error) {
 	relModel := models.ModelByName(qr.ModelName)
 	if relModel == nil {
 		return nil, api.ErrorInvalidInputFormat(fmt.Sprintf("related model (%s) does not exist", qr.ModelName))
@@ -131,18 +153,31 @@ func (qr *QueryRequest) decomposeRelation(payload QueryPayload, seenSubgraphs *[
 		return nil, api.ErrorInvalidInputFormat(fmt.Sprintf("relation (%s) does not exist", qr.Relation))
 	}
 
-	joinFragment, er := qr.decomposeDirect(payload, seenSubgraphs)
+	/* The order is of the related objects, not the ones they're related through */
+	joinReq := *qr
+	joinReq.Order = nil
+
+	joinFragment, er := joinReq.decomposeDirect(ctx, payload, seenSubgraphs)
+	if er != nil {
+		return nil, er
+	}
+
+	alias := nextUnnamedAlias(seenSubgraphs)
+
+	/* The search was of the objects related through, so can't order these */
+	order, orderArgs, er := qr.orderFor(model, alias, nil)
 	if er != nil {
 		return nil, er
 	}
 
 	frag := &SqlFragment{
-		Table:  model.TableName(),
-		Alias:  nextUnnamedAlias(seenSubgraphs),
-		Limit:  qr.Limit,
-		Offset: qr.Offset,
-		Target: model,
-		/* XXX: ORDER */
+		Table:     model.TableName(),
+		Alias:     alias,
+		Limit:     qr.Limit,
+		Offset:    qr.Offset,
+		Order:     order,
+		OrderArgs: orderArgs,
+		Target:    model,
 	}
 
 	frag.JoinOn(joinFragment, targetField, joinField)
@@ -150,13 +185,13 @@ func (qr *QueryRequest) decomposeRelation(payload QueryPayload, seenSubgraphs *[
 	return frag, nil
 }
 
-func (qr *QueryRequest) decomposeRelationSubgraph(payload QueryPayload, seenSubgraphs *[]string) (*SqlFragment, error) {
+func (qr *QueryRequest) decomposeRelationSubgraph(ctx context.Context, payload QueryPayload, seenSubgraphs *[]string) (*SqlFragment, error) {
 	subgraphReq, ok := payload.GetNamedRequest(qr.Subgraph)
 	if !ok {
 		return nil, api.ErrorInvalidInputFormat(fmt.Sprintf("referenced subgraph (%s) does not exist", qr.Subgraph))
 	}
 
-	subgraphJoin, er := subgraphReq.decompose(payload, seenSubgraphs)
+	subgraphJoin, er := subgraphReq.decompose(ctx, payload, seenSubgraphs)
 	if er != nil {
 		return nil, er
 	}
@@ -181,26 +216,52 @@ func (qr *QueryRequest) decomposeRelationSubgraph(payload QueryPayload, seenSubg
 
 		bridgeFrag.JoinOn(subgraphJoin, foreignField, subgraphModel.PrimaryKey())
 
+		where, search, er := qr.constraints(ctx, model)
+		if er != nil {
+			return nil, er
+		}
+
+		order, orderArgs, er := qr.orderFor(model, alias, search)
+		if er != nil {
+			return nil, er
+		}
+
 		frag := &SqlFragment{
-			Table:  model.TableName(),
-			Alias:  alias,
-			Where:  desugarWhere(qr.WhereClauses, model),
-			Limit:  qr.Limit,
-			Offset: qr.Offset,
-			Target: model,
+			Table:     model.TableName(),
+			Alias:     alias,
+			Where:     where,
+			Limit:     qr.Limit,
+			Offset:    qr.Offset,
+			Order:     order,
+			OrderArgs: orderArgs,
+			Target:    model,
+			Search:    search,
 		}
 
 		frag.JoinOn(bridgeFrag, model.PrimaryKey(), joinField)
 		return frag, nil
 
 	} else if joinField, targetField, model := subgraphModel.RelationFieldNames(qr.Relation); model != nil {
+		where, search, er := qr.constraints(ctx, model)
+		if er != nil {
+			return nil, er
+		}
+
+		order, orderArgs, er := qr.orderFor(model, alias, search)
+		if er != nil {
+			return nil, er
+		}
+
 		frag := &SqlFragment{
-			Table:  model.TableName(),
-			Alias:  alias,
-			Where:  desugarWhere(qr.WhereClauses, model),
-			Limit:  qr.Limit,
-			Offset: qr.Offset,
-			Target: model,
+			Table:     model.TableName(),
+			Alias:     alias,
+			Where:     where,
+			Limit:     qr.Limit,
+			Offset:    qr.Offset,
+			Order:     order,
+			OrderArgs: orderArgs,
+			Target:    model,
+			Search:    search,
 		}
 
 		frag.JoinOn(subgraphJoin, targetField, joinField)
@@ -3,22 +3,31 @@ package query
 import (
 	"encoding/json"
 	"fmt"
+	"macrobooru/api"
 	"macrobooru/models"
 	"reflect"
 )
 
 type QueryResponse map[string]QueryResponsePart
 
+/* QueryResponsePart is the result of one named query: the objects matched, or if
+ * it was an aggregate query, the groups. Total is how many there are in all, before
+ * the limit and offset, or -1 if the request asked not to count them. Cursor, sent with full pages, is given as `after` to fetch
+ * the objects following the slice. */
 type QueryResponsePart struct {
-	Total     int64         `json:"total"`
-	Slice     []interface{} `json:"slice"`
-	ModelName string        `json:"model,omitempty"`
+	Total     int64          `json:"total"`
+	Slice     []interface{}  `json:"slice"`
+	Groups    []AggregateRow `json:"groups,omitempty"`
+	Cursor    string         `json:"cursor,omitempty"`
+	ModelName string         `json:"model,omitempty"`
 }
 
 type rawQueryResponsePart struct {
-	Total     int64             `json:"total"`
-	Slice     []json.RawMessage `json:"slice"`
-	ModelName string            `json:"model"`
+	Total     int64            `json:"total"`
+	Slice     []api.RawMessage `json:"slice"`
+	Groups    []AggregateRow   `json:"groups"`
+	Cursor    string           `json:"cursor"`
+	ModelName string           `json:"model"`
 }
 
 func (part *QueryResponsePart) UnmarshalJSON(bs []byte) error {
@@ -28,6 +37,14 @@ func (part *QueryResponsePart) UnmarshalJSON(bs []byte) error {
 		return er
 	}
 
+	return part.fromRaw(&rawPart, func(msg api.RawMessage, v interface{}) error {
+		return json.Unmarshal(msg, v)
+	})
+}
+
+/* fromRaw decodes each object in the slice as the model the part is tagged with,
+ * using unmarshal (which must match the encoding the part arrived in). */
+func (part *QueryResponsePart) fromRaw(rawPart *rawQueryResponsePart, unmarshal func(api.RawMessage, interface{}) error) error {
 	modelMeta := models.ModelByName(rawPart.ModelName)
 	if modelMeta == nil {
 		return fmt.Errorf("No such model (%s)", rawPart.ModelName)
@@ -38,7 +55,7 @@ func (part *QueryResponsePart) UnmarshalJSON(bs []byte) error {
 	for _, msg := range rawPart.Slice {
 		zeroVal := reflect.New(modelMeta.Type())
 
-		if er := json.Unmarshal(msg, zeroVal.Interface()); er != nil {
+		if er := unmarshal(msg, zeroVal.Interface()); er != nil {
 			return er
 		}
 
@@ -47,6 +64,8 @@ func (part *QueryResponsePart) UnmarshalJSON(bs []byte) error {
 
 	part.Total = rawPart.Total
 	part.Slice = slice
+	part.Groups = rawPart.Groups
+	part.Cursor = rawPart.Cursor
 	part.ModelName = rawPart.ModelName
 	return nil
 }
@@ -0,0 +1,57 @@
+package query
+
+import (
+	"database/sql"
+	"reflect"
+	"time"
+)
+
+/* scanObject reads the row rows is on into a new object of modelType, and the
+ * total column into total, if it was selected. Columns are matched with fields by
+ * their crud tags, as crud.Scan does; it can't be handed the total as well. */
+func scanObject(rows *sql.Rows, modelType reflect.Type, total *int64) (interface{}, error) {
+	columns, er := rows.Columns()
+	if er != nil {
+		return nil, er
+	}
+
+	object := reflect.New(modelType).Elem()
+	dest := make([]interface{}, len(columns))
+
+	/* Timestamps stored as UNIX time are read as numbers, then converted */
+	unixFields := map[int]*sql.NullInt64{}
+
+	for idx, name := range columns {
+		if name == totalColumn && total != nil {
+			dest[idx] = total
+			continue
+		}
+
+		fieldIdx, ok := columnField(modelType, name)
+		if !ok {
+			dest[idx] = new(interface{})
+			continue
+		}
+
+		if isUnixField(modelType.Field(fieldIdx)) {
+			unix := &sql.NullInt64{}
+			unixFields[fieldIdx] = unix
+			dest[idx] = unix
+			continue
+		}
+
+		dest[idx] = object.Field(fieldIdx).Addr().Interface()
+	}
+
+	if er := rows.Scan(dest...); er != nil {
+		return nil, er
+	}
+
+	for fieldIdx, unix := range unixFields {
+		if unix.Valid {
+			object.Field(fieldIdx).Set(reflect.ValueOf(time.Unix(unix.Int64, 0).UTC()))
+		}
+	}
+
+	return object.Interface(), nil
+}
@@ -0,0 +1,105 @@
+package query
+
+import (
+	"context"
+	"sort"
+
+	"macrobooru/api"
+	"macrobooru/api/pluggable"
+	"macrobooru/models"
+)
+
+/* MaxSearchHits is how many of the best matches of each search clause are kept.
+ * Objects matching worse than that aren't found at all. */
+const MaxSearchHits = 500
+
+/* searchResult is what the search clauses of a request found. */
+type searchResult struct {
+	// Pids are the primary keys of the objects matching every clause, best match
+	// first.
+	Pids []interface{}
+}
+
+/* search runs each search clause of qr against the index of its field of model,
+ * returning nil if there are none. An object's relevance is the sum of its scores
+ * from each index. Search clauses were ignored before indexes could be registered,
+ * so they still are on models without any. */
+func (qr *QueryRequest) search(ctx context.Context, model models.ModelMeta) (*searchResult, error) {
+	if len(qr.SearchClauses) == 0 || len(pluggable.RegisteredSearchIndexes(model.Name())) == 0 {
+		return nil, nil
+	}
+
+	/* Scores by the string form of each pid */
+	var scores map[string]float64
+
+	for field, query := range qr.SearchClauses {
+		index := pluggable.RegisteredSearchIndex(model.Name(), field)
+		if index == nil {
+			return nil, api.ErrorInvalidInputField(field)
+		}
+
+		terms, er := pluggable.ParseSearchQuery(query)
+		if er != nil {
+			return nil, api.ErrorSphinxSyntaxError(er)
+		}
+
+		hits, er := index.Search(ctx, terms, MaxSearchHits)
+		if _, ok := er.(*pluggable.SearchSyntaxError); ok {
+			return nil, api.ErrorSphinxSyntaxError(er)
+
+		} else if er != nil {
+			return nil, api.ErrorSphinxOtherError(er)
+		}
+
+		matched := make(map[string]float64)
+
+		for _, hit := range hits {
+			pid := hit.Pid.String()
+
+			if score, ok := scores[pid]; ok || scores == nil {
+				matched[pid] = score + hit.Score
+			}
+		}
+
+		scores = matched
+	}
+
+	pids := make([]string, 0, len(scores))
+	for pid := range scores {
+		pids = append(pids, pid)
+	}
+
+	sort.Slice(pids, func(i, j int) bool {
+		if scores[pids[i]] != scores[pids[j]] {
+			return scores[pids[i]] > scores[pids[j]]
+		}
+
+		return pids[i] < pids[j]
+	})
+
+	result := &searchResult{Pids: make([]interface{}, len(pids))}
+	for idx, pid := range pids {
+		result.Pids[idx] = pid
+	}
+
+	return result, nil
+}
+
+/* constraints gathers the where and search clauses of qr, on model. */
+func (qr *QueryRequest) constraints(ctx context.Context, model models.ModelMeta) ([]WhereClause, *searchResult, error) {
+	where, er := desugarWhere(qr.WhereClauses, model)
+	if er != nil {
+		return nil, nil, er
+	}
+
+	search, er := qr.search(ctx, model)
+	if er != nil {
+		return nil, nil, er
+	}
+
+	if search != nil {
+		where = append(where, WhereClause{Field: model.PrimaryKey(), Value: search.Pids, Operand: whereIn})
+	}
+
+	return where, search, nil
+}
@@ -0,0 +1,93 @@
+package query
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"macrobooru/api"
+	"macrobooru/api/pluggable"
+	"macrobooru/api/pluggable/searchindexes/memory"
+	"macrobooru/models"
+)
+
+func TestSearchClause(t *testing.T) {
+	index := memory.NewSearchIndex()
+	pluggable.RegisterSearchIndex("Comment", "contents", index)
+	defer pluggable.RegisterSearchIndex("Comment", "contents", nil)
+
+	cat, catDog, dog := models.NewGUID(), models.NewGUID(), models.NewGUID()
+	index.Index(cat, "A cat, sitting")
+	index.Index(catDog, "The cat chased the dog, the cat won")
+	index.Index(dog, "Dogs and dogs")
+
+	frag, er := decomposeTestQuery(t, `{ "c" : { "model" : "Comment", "search" : { "contents" : "cat -sitting" }, "order" : "#relevance" } }`, "c")
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	sql, args := frag.toSQL()
+	if !strings.Contains(sql, `WHERE "alias1"."pid" IN ($1) ORDER BY CASE "alias1"."pid" WHEN $2 THEN 0 ELSE 1 END ASC, "alias1"."pid" ASC`) {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+
+	if !reflect.DeepEqual(args, []interface{}{catDog.String(), catDog.String()}) {
+		t.Errorf("unexpected args %#v", args)
+	}
+
+	/* Prefixes, and the best match first */
+	frag, er = decomposeTestQuery(t, `{ "c" : { "model" : "Comment", "search" : { "contents" : "do*" }, "order" : "#relevance" } }`, "c")
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	if !reflect.DeepEqual(frag.OrderArgs, []interface{}{dog.String(), catDog.String()}) {
+		t.Errorf("unexpected ranking %#v", frag.OrderArgs)
+	}
+
+	index.Remove(dog)
+
+	frag, er = decomposeTestQuery(t, `{ "c" : { "model" : "Comment", "search" : { "contents" : "dogs" } } }`, "c")
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	if sql, _ := frag.toSQL(); !strings.Contains(sql, "WHERE 0 = 1") {
+		t.Errorf("expected to match nothing, got %s", sql)
+	}
+}
+
+func TestSearchClauseErrors(t *testing.T) {
+	pluggable.RegisterSearchIndex("Comment", "contents", memory.NewSearchIndex())
+	defer pluggable.RegisterSearchIndex("Comment", "contents", nil)
+
+	tests := []struct {
+		request string
+		code    int64
+	}{
+		{`{ "model" : "Comment", "search" : { "contents" : "-cat" } }`, api.ErrCodeSphinxSyntaxError},
+		{`{ "model" : "Comment", "search" : { "contents" : "cat - *" } }`, api.ErrCodeSphinxSyntaxError},
+		{`{ "model" : "Comment", "search" : { "parent_id" : "cat" } }`, api.ErrCodeInvalidInputField},
+		{`{ "model" : "Comment", "order" : "#relevance" }`, api.ErrCodeInvalidInputField},
+	}
+
+	for _, test := range tests {
+		_, er := decomposeTestQuery(t, `{ "c" : `+test.request+` }`, "c")
+
+		if apiEr, ok := er.(*api.ApiError); !ok || apiEr.Code() != test.code {
+			t.Errorf("%s: expected code %x, got %v", test.request, test.code, er)
+		}
+	}
+}
+
+func TestSearchWithoutIndexes(t *testing.T) {
+	/* As before indexes could be registered, the clause is ignored */
+	frag, er := decomposeTestQuery(t, `{ "c" : { "model" : "Comment", "search" : { "contents" : "cat" } } }`, "c")
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	if sql, _ := frag.toSQL(); strings.Contains(sql, "WHERE") {
+		t.Errorf("search clause was not ignored: %s", sql)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strings"
 
+	"macrobooru/api"
 	"macrobooru/models"
 )
 
@@ -19,12 +20,28 @@ const (
 	whereLessEqual
 	whereNull
 	whereNotNull
+	whereLike
+	whereILike
+	whereIn
+	whereNotIn
+	whereBetween
+
+	/* Becomes a whereLike while the clause is parsed */
+	wherePrefix
+
+	/* Groups of Clauses, rather than tests of Field */
+	whereAnd
+	whereOr
+	whereNot
 )
 
 type WhereClause struct {
 	Field   string
 	Value   interface{}
 	Operand int
+
+	// Clauses are what a whereAnd, whereOr or whereNot group combines.
+	Clauses []WhereClause
 }
 
 type SqlFragment struct {
@@ -37,13 +54,63 @@ type SqlFragment struct {
 	Offset int64
 	Order  string
 
+	// OrderArgs are the arguments of the placeholders in Order.
+	OrderArgs []interface{}
+
 	Join   *SqlFragment
 	On     map[string]string
 	Target models.ModelMeta
+
+	// Columns are those of Target selected, or all of them if empty. Fields are
+	// the JSON names of the same fields.
+	Columns []string
+	Fields  []string
+
+	// Aggregate, if set, makes the fragment select a summary of each group of rows
+	// instead of the rows themselves.
+	Aggregate *aggregation
+
+	// Keys are the terms the rows are ordered by, which cursors are made of, and
+	// Search is what the search clauses found (nil if there were none).
+	Keys   []orderTerm
+	Search *searchResult
+
+	// After holds for the rows following a cursor. Unlike Where, it doesn't limit
+	// the total.
+	After []WhereClause
+
+	// CountTotal is whether the total is wanted at all.
+	CountTotal bool
 }
 
-func (frag *SqlFragment) whereSQL() (string, []interface{}) {
-	bits, args := frag.rawWhereSQL("")
+/* totalColumn is what the total is selected as, alongside the rows. */
+const totalColumn = "#total"
+
+/* quote quotes an identifier for the active dialect. */
+func quote(name string) string {
+	return api.ActiveDialect().QuoteIdentifier(name)
+}
+
+/* column names a column of the table aliased as alias, or of the sole table if
+ * alias is empty. */
+func column(alias, name string) string {
+	if alias == "" {
+		return quote(name)
+	}
+
+	return quote(alias) + "." + quote(name)
+}
+
+/* whereSQL renders the where clauses, and any extra clauses, of the fragment. */
+func (frag *SqlFragment) whereSQL(extra ...WhereClause) (string, []interface{}) {
+	bits, args := frag.rawWhereSQL(frag.Alias)
+
+	for _, clause := range extra {
+		bit, clauseArgs := clauseSQL(frag.Alias, clause)
+
+		bits = append(bits, bit)
+		args = append(args, clauseArgs...)
+	}
 
 	if len(bits) == 0 {
 		return "", nil
@@ -52,71 +119,155 @@ func (frag *SqlFragment) whereSQL() (string, []interface{}) {
 	return " WHERE " + strings.Join(bits, " AND "), args
 }
 
-func (frag *SqlFragment) rawWhereSQL(prefix string) ([]string, []interface{}) {
+/* rawWhereSQL renders the where clauses, with the columns qualified by alias if
+ * it's not empty. The fields must already have been checked against the model;
+ * they're quoted regardless. */
+func (frag *SqlFragment) rawWhereSQL(alias string) ([]string, []interface{}) {
 	bits := []string{}
 	args := []interface{}{}
 
 	for _, whereClause := range frag.Where {
-		/* Special case to handle `#primary : [pid, pid]`; it falls over to other
-		 * fields, but that's an acceptable generalization */
-		if slice, ok := whereClause.Value.([]interface{}); ok {
-			placeholders := []string{}
+		bit, clauseArgs := clauseSQL(alias, whereClause)
 
-			for _, val := range slice {
-				placeholders = append(placeholders, "?")
-				args = append(args, val)
-			}
+		bits = append(bits, bit)
+		args = append(args, clauseArgs...)
+	}
 
-			placeholder := strings.Join(placeholders, ", ")
+	return bits, args
+}
 
-			if len(placeholder) > 0 {
-				bits = append(bits, fmt.Sprintf("%s%s IN (%s)", prefix, whereClause.Field, placeholder))
+/* clauseSQL renders a single where clause. The arguments are returned in the
+ * order their placeholders appear. */
+func clauseSQL(alias string, whereClause WhereClause) (string, []interface{}) {
+	switch whereClause.Operand {
+	case whereAnd:
+		return groupSQL(alias, whereClause.Clauses, " AND ", "1 = 1")
 
-			} else {
-				bits = append(bits, "0 = 1")
-			}
+	case whereOr:
+		return groupSQL(alias, whereClause.Clauses, " OR ", "0 = 1")
 
-			continue
+	case whereNot:
+		if len(whereClause.Clauses) == 0 {
+			return "0 = 1", nil
 		}
 
-		switch whereClause.Operand {
-		case whereGreater:
-			bits = append(bits, fmt.Sprintf("%s%s > ?", prefix, whereClause.Field))
-			args = append(args, whereClause.Value)
+		bit, args := groupSQL(alias, whereClause.Clauses, " AND ", "")
+		if !strings.HasPrefix(bit, "(") {
+			bit = "(" + bit + ")"
+		}
 
-		case whereGreaterEqual:
-			bits = append(bits, fmt.Sprintf("%s%s >= ?", prefix, whereClause.Field))
-			args = append(args, whereClause.Value)
+		return "NOT " + bit, args
+	}
 
-		case whereLess:
-			bits = append(bits, fmt.Sprintf("%s%s < ?", prefix, whereClause.Field))
-			args = append(args, whereClause.Value)
+	field := column(alias, whereClause.Field)
+	slice, isSlice := whereClause.Value.([]interface{})
 
-		case whereLessEqual:
-			bits = append(bits, fmt.Sprintf("%s%s <= ?", prefix, whereClause.Field))
-			args = append(args, whereClause.Value)
+	switch whereClause.Operand {
+	case whereNotIn:
+		if len(slice) == 0 {
+			return "1 = 1", nil
+		}
 
-		case whereEqual:
-			bits = append(bits, fmt.Sprintf("%s%s = ?", prefix, whereClause.Field))
-			args = append(args, whereClause.Value)
+		return fmt.Sprintf("%s NOT IN (%s)", field, placeholders(len(slice))), slice
 
-		case whereNotEqual:
-			bits = append(bits, fmt.Sprintf("%s%s != ?", prefix, whereClause.Field))
-			args = append(args, whereClause.Value)
+	case whereBetween:
+		return fmt.Sprintf("%s BETWEEN ? AND ?", field), slice
 
-		case whereNull:
-			bits = append(bits, fmt.Sprintf("%s%s IS NULL", prefix, whereClause.Field))
+	case whereLike:
+		return fmt.Sprintf("%s LIKE ? ESCAPE '%c'", field, likeEscape), []interface{}{whereClause.Value}
 
-		case whereNotNull:
-			bits = append(bits, fmt.Sprintf("%s%s IS NOT NULL", prefix, whereClause.Field))
+	case whereILike:
+		return fmt.Sprintf("LOWER(%s) LIKE LOWER(?) ESCAPE '%c'", field, likeEscape), []interface{}{whereClause.Value}
+	}
+
+	/* Special case to handle `#primary : [pid, pid]`; it falls over to other
+	 * fields, but that's an acceptable generalization */
+	if isSlice {
+		if len(slice) == 0 {
+			return "0 = 1", nil
 		}
+
+		return fmt.Sprintf("%s IN (%s)", field, placeholders(len(slice))), slice
 	}
 
-	return bits, args
+	switch whereClause.Operand {
+	case whereGreater:
+		return fmt.Sprintf("%s > ?", field), []interface{}{whereClause.Value}
+
+	case whereGreaterEqual:
+		return fmt.Sprintf("%s >= ?", field), []interface{}{whereClause.Value}
+
+	case whereLess:
+		return fmt.Sprintf("%s < ?", field), []interface{}{whereClause.Value}
+
+	case whereLessEqual:
+		return fmt.Sprintf("%s <= ?", field), []interface{}{whereClause.Value}
+
+	case whereNotEqual:
+		return fmt.Sprintf("%s != ?", field), []interface{}{whereClause.Value}
+
+	case whereNull:
+		return fmt.Sprintf("%s IS NULL", field), nil
+
+	case whereNotNull:
+		return fmt.Sprintf("%s IS NOT NULL", field), nil
+	}
+
+	return fmt.Sprintf("%s = ?", field), []interface{}{whereClause.Value}
+}
+
+/* placeholders is a list of n argument placeholders. */
+func placeholders(n int) string {
+	bits := make([]string, n)
+	for idx := range bits {
+		bits[idx] = "?"
+	}
+
+	return strings.Join(bits, ", ")
+}
+
+/* groupSQL joins clauses with op, parenthesized so the group can be nested inside
+ * any other. empty is what a group of no clauses comes to. */
+func groupSQL(alias string, clauses []WhereClause, op, empty string) (string, []interface{}) {
+	if len(clauses) == 1 {
+		return clauseSQL(alias, clauses[0])
+	}
+
+	if len(clauses) == 0 {
+		return empty, nil
+	}
+
+	bits := make([]string, len(clauses))
+	args := []interface{}{}
+
+	for idx, clause := range clauses {
+		bit, clauseArgs := clauseSQL(alias, clause)
+
+		bits[idx] = bit
+		args = append(args, clauseArgs...)
+	}
+
+	return "(" + strings.Join(bits, op) + ")", args
 }
 
 func (frag *SqlFragment) toSQL() (string, []interface{}) {
-	whereClauses, whereArgs := frag.whereSQL()
+	return frag.selectSQL(false)
+}
+
+/* countsOver is whether the total can be selected alongside the rows, by toCountedSQL.
+ * It can't once a cursor has left rows out of them. */
+func (frag *SqlFragment) countsOver() bool {
+	return frag.CountTotal && len(frag.After) == 0 && api.ActiveDialect().WindowFunctions()
+}
+
+/* toCountedSQL is toSQL, with the total (before the limit and offset) selected as
+ * the last column of every row, so the two take a single query. */
+func (frag *SqlFragment) toCountedSQL() (string, []interface{}) {
+	return frag.selectSQL(true)
+}
+
+func (frag *SqlFragment) selectSQL(counted bool) (string, []interface{}) {
+	whereClauses, whereArgs := frag.whereSQL(frag.After...)
 	joinClauses, joinArgs := frag.joinSQL()
 
 	if frag.Limit < 1 || frag.Limit > MaxLimit {
@@ -131,27 +282,66 @@ func (frag *SqlFragment) toSQL() (string, []interface{}) {
 		orderClause = " ORDER BY " + frag.Order
 	}
 
-	args := make([]interface{}, len(whereArgs)+len(joinArgs))
-	copy(args[0:], whereArgs)
-	copy(args[len(whereArgs):], joinArgs)
+	/* The JOIN comes before the WHERE, so its arguments do too */
+	args := make([]interface{}, 0, len(joinArgs)+len(whereArgs)+len(frag.OrderArgs))
+	args = append(args, joinArgs...)
+	args = append(args, whereArgs...)
+	args = append(args, frag.OrderArgs...)
+
+	selectList := frag.selectList()
+	if counted {
+		selectList += ", COUNT(*) OVER () AS " + quote(totalColumn)
+	}
 
-	sql := fmt.Sprintf(`SELECT %s.* FROM %s AS %s%s%s%s%s%s`, frag.Alias, frag.Table, frag.Alias, joinClauses, whereClauses, orderClause, limitClause, offsetClause)
+	sql := fmt.Sprintf(`SELECT %s FROM %s AS %s%s%s%s%s%s%s`, selectList, quote(frag.Table), quote(frag.Alias), joinClauses, whereClauses, frag.groupBySQL(), orderClause, limitClause, offsetClause)
 
 	return frag.anonToOrderedPlaceholders(sql), args
 }
 
+/* selectList is the columns selected by toSQL. */
+func (frag *SqlFragment) selectList() string {
+	if frag.Aggregate != nil {
+		return frag.Aggregate.selectList(frag.Alias)
+	}
+
+	if len(frag.Columns) == 0 {
+		return quote(frag.Alias) + ".*"
+	}
+
+	columns := make([]string, len(frag.Columns))
+	for idx, name := range frag.Columns {
+		columns[idx] = column(frag.Alias, name)
+	}
+
+	return strings.Join(columns, ", ")
+}
+
 func (frag *SqlFragment) toCountSQL() (string, []interface{}) {
 	whereClauses, whereArgs := frag.whereSQL()
 	joinClauses, joinArgs := frag.joinSQL()
 
-	args := make([]interface{}, len(whereArgs)+len(joinArgs))
-	copy(args[0:], whereArgs)
-	copy(args[len(whereArgs):], joinArgs)
+	args := make([]interface{}, 0, len(joinArgs)+len(whereArgs))
+	args = append(args, joinArgs...)
+	args = append(args, whereArgs...)
+
+	sql := fmt.Sprintf(`SELECT COUNT(*) FROM %s AS %s%s%s`, quote(frag.Table), quote(frag.Alias), joinClauses, whereClauses)
+
+	/* The total of an aggregate is how many groups there are; one, with no groups */
+	if frag.Aggregate != nil {
+		sql = fmt.Sprintf(`SELECT COUNT(*) FROM (SELECT COUNT(*) FROM %s AS %s%s%s%s) AS %s`, quote(frag.Table), quote(frag.Alias), joinClauses, whereClauses, frag.groupBySQL(), quote("groups"))
+	}
 
-	sql := fmt.Sprintf(`SELECT COUNT(*) FROM %s AS %s%s%s`, frag.Table, frag.Alias, joinClauses, whereClauses)
 	return frag.anonToOrderedPlaceholders(sql), args
 }
 
+func (frag *SqlFragment) groupBySQL() string {
+	if frag.Aggregate == nil {
+		return ""
+	}
+
+	return frag.Aggregate.groupBySQL(frag.Alias)
+}
+
 func (frag *SqlFragment) joinSQL() (string, []interface{}) {
 	if frag.Join == nil {
 		return "", nil
@@ -159,10 +349,10 @@ func (frag *SqlFragment) joinSQL() (string, []interface{}) {
 
 	onClauses := []string{}
 	for k, v := range frag.On {
-		onClauses = append(onClauses, fmt.Sprintf("%s.%s = %s.%s", frag.Alias, k, frag.Join.Alias, v))
+		onClauses = append(onClauses, fmt.Sprintf("%s = %s", column(frag.Alias, k), column(frag.Join.Alias, v)))
 	}
 
-	additionalClauses, args := frag.Join.rawWhereSQL(frag.Join.Alias + ".")
+	additionalClauses, args := frag.Join.rawWhereSQL(frag.Join.Alias)
 	for _, clause := range additionalClauses {
 		onClauses = append(onClauses, clause)
 	}
@@ -172,11 +362,12 @@ func (frag *SqlFragment) joinSQL() (string, []interface{}) {
 		onClause = "ON " + strings.Join(onClauses, " AND ")
 	}
 
-	join := fmt.Sprintf(" INNER JOIN %s %s %s", frag.Join.Table, frag.Join.Alias, onClause)
+	join := fmt.Sprintf(" INNER JOIN %s %s %s", quote(frag.Join.Table), quote(frag.Join.Alias), onClause)
 
 	if frag.Join.Join != nil {
-		newJoin, _ := frag.Join.joinSQL()
+		newJoin, newArgs := frag.Join.joinSQL()
 		join += newJoin
+		args = append(args, newArgs...)
 	}
 
 	return join, args
@@ -193,14 +384,15 @@ func (frag *SqlFragment) JoinOn(join *SqlFragment, myField, joinField string) {
 }
 
 func (frag *SqlFragment) anonToOrderedPlaceholders(sql string) string {
-	/* Fix argument placeholders to actually work */
+	/* Fix argument placeholders to actually work. Identifiers are checked against
+	 * the models before they get here, so the only '?'s are placeholders. */
 	var buf bytes.Buffer
 	seenPlaceholders := 1
+	dialect := api.ActiveDialect()
 
 	for _, b := range []byte(sql) {
 		if b == '?' {
-			placeholder := fmt.Sprintf("$%d", seenPlaceholders)
-			buf.WriteString(placeholder)
+			buf.WriteString(dialect.Placeholder(seenPlaceholders))
 			seenPlaceholders += 1
 
 		} else {
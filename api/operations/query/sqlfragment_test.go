@@ -1,8 +1,14 @@
 package query
 
 import (
+	"encoding/json"
 	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+
+	"macrobooru/api"
 )
 
 func makeVerifier(t *testing.T, sql, expected *string, args *[]interface{}) func(int, string) {
@@ -29,17 +35,17 @@ func TestSimple(t *testing.T) {
 	}
 
 	sql, args = frag.toSQL()
-	expected = fmt.Sprintf("SELECT alias.* FROM table AS alias LIMIT %d OFFSET 0", MaxLimit)
+	expected = fmt.Sprintf("SELECT \"alias\".* FROM \"table\" AS \"alias\" LIMIT %d OFFSET 0", MaxLimit)
 	verify(0, "vanilla")
 
 	frag.Limit = 10
 	sql, args = frag.toSQL()
-	expected = "SELECT alias.* FROM table AS alias LIMIT 10 OFFSET 0"
+	expected = "SELECT \"alias\".* FROM \"table\" AS \"alias\" LIMIT 10 OFFSET 0"
 	verify(0, "limit")
 
 	frag.Offset = 10
 	sql, args = frag.toSQL()
-	expected = "SELECT alias.* FROM table AS alias LIMIT 10 OFFSET 10"
+	expected = "SELECT \"alias\".* FROM \"table\" AS \"alias\" LIMIT 10 OFFSET 10"
 	verify(0, "offset")
 
 	frag.Where = append(frag.Where, WhereClause{
@@ -47,7 +53,7 @@ func TestSimple(t *testing.T) {
 		Value: "value",
 	})
 	sql, args = frag.toSQL()
-	expected = "SELECT alias.* FROM table AS alias WHERE foo = $1 LIMIT 10 OFFSET 10"
+	expected = "SELECT \"alias\".* FROM \"table\" AS \"alias\" WHERE \"alias\".\"foo\" = $1 LIMIT 10 OFFSET 10"
 	verify(1, "single where")
 
 	if arg, ok := args[0].(string); !ok {
@@ -72,7 +78,7 @@ func TestOrder(t *testing.T) {
 	}
 
 	sql, args = frag.toSQL()
-	expected = "SELECT alias.* FROM table AS alias ORDER BY bar LIMIT 10 OFFSET 12"
+	expected = "SELECT \"alias\".* FROM \"table\" AS \"alias\" ORDER BY bar LIMIT 10 OFFSET 12"
 	verify(0, "vanilla")
 }
 
@@ -95,8 +101,8 @@ func TestMultipleWhere(t *testing.T) {
 	}
 
 	sql, args := frag.toSQL()
-	expected1 := "SELECT alias.* FROM table AS alias WHERE foo = $1 AND bar = $2 LIMIT 10 OFFSET 12"
-	expected2 := "SELECT alias.* FROM table AS alias WHERE bar = $1 AND foo = $2 LIMIT 10 OFFSET 12"
+	expected1 := "SELECT \"alias\".* FROM \"table\" AS \"alias\" WHERE \"alias\".\"foo\" = $1 AND \"alias\".\"bar\" = $2 LIMIT 10 OFFSET 12"
+	expected2 := "SELECT \"alias\".* FROM \"table\" AS \"alias\" WHERE \"alias\".\"bar\" = $1 AND \"alias\".\"foo\" = $2 LIMIT 10 OFFSET 12"
 
 	if len(args) != 2 {
 		t.Fatalf("where2: Incorrect arg length, got %#v", args)
@@ -152,27 +158,27 @@ func TestCannedJoin(t *testing.T) {
 	}
 
 	sql, args = frag.toSQL()
-	expected = fmt.Sprintf("SELECT alias1.* FROM target AS alias1 INNER JOIN subquery alias2 ON alias1.targetId = alias2.subqueryId LIMIT %d OFFSET 0", MaxLimit)
+	expected = fmt.Sprintf("SELECT \"alias1\".* FROM \"target\" AS \"alias1\" INNER JOIN \"subquery\" \"alias2\" ON \"alias1\".\"targetId\" = \"alias2\".\"subqueryId\" LIMIT %d OFFSET 0", MaxLimit)
 	verify(0, "vanilla")
 
 	frag.Join.Limit = 10
 	sql, args = frag.toSQL()
-	expected = fmt.Sprintf("SELECT alias1.* FROM target AS alias1 INNER JOIN subquery alias2 ON alias1.targetId = alias2.subqueryId LIMIT %d OFFSET 0", MaxLimit)
+	expected = fmt.Sprintf("SELECT \"alias1\".* FROM \"target\" AS \"alias1\" INNER JOIN \"subquery\" \"alias2\" ON \"alias1\".\"targetId\" = \"alias2\".\"subqueryId\" LIMIT %d OFFSET 0", MaxLimit)
 	verify(0, "should ignore join limit")
 
 	frag.Join.Offset = 10
 	sql, args = frag.toSQL()
-	expected = fmt.Sprintf("SELECT alias1.* FROM target AS alias1 INNER JOIN subquery alias2 ON alias1.targetId = alias2.subqueryId LIMIT %d OFFSET 0", MaxLimit)
+	expected = fmt.Sprintf("SELECT \"alias1\".* FROM \"target\" AS \"alias1\" INNER JOIN \"subquery\" \"alias2\" ON \"alias1\".\"targetId\" = \"alias2\".\"subqueryId\" LIMIT %d OFFSET 0", MaxLimit)
 	verify(0, "should ignore join offset")
 
 	frag.Limit = 10
 	sql, args = frag.toSQL()
-	expected = "SELECT alias1.* FROM target AS alias1 INNER JOIN subquery alias2 ON alias1.targetId = alias2.subqueryId LIMIT 10 OFFSET 0"
+	expected = "SELECT \"alias1\".* FROM \"target\" AS \"alias1\" INNER JOIN \"subquery\" \"alias2\" ON \"alias1\".\"targetId\" = \"alias2\".\"subqueryId\" LIMIT 10 OFFSET 0"
 	verify(0, "should use base limit")
 
 	frag.Offset = 12
 	sql, args = frag.toSQL()
-	expected = "SELECT alias1.* FROM target AS alias1 INNER JOIN subquery alias2 ON alias1.targetId = alias2.subqueryId LIMIT 10 OFFSET 12"
+	expected = "SELECT \"alias1\".* FROM \"target\" AS \"alias1\" INNER JOIN \"subquery\" \"alias2\" ON \"alias1\".\"targetId\" = \"alias2\".\"subqueryId\" LIMIT 10 OFFSET 12"
 	verify(0, "should use base offset")
 
 	frag.Join.Join = &SqlFragment{
@@ -185,7 +191,7 @@ func TestCannedJoin(t *testing.T) {
 	}
 
 	sql, args = frag.toSQL()
-	expected = "SELECT alias1.* FROM target AS alias1 INNER JOIN subquery alias2 ON alias1.targetId = alias2.subqueryId INNER JOIN moresub alias3 ON alias2.subqueryPants = alias3.subsubId LIMIT 10 OFFSET 12"
+	expected = "SELECT \"alias1\".* FROM \"target\" AS \"alias1\" INNER JOIN \"subquery\" \"alias2\" ON \"alias1\".\"targetId\" = \"alias2\".\"subqueryId\" INNER JOIN \"moresub\" \"alias3\" ON \"alias2\".\"subqueryPants\" = \"alias3\".\"subsubId\" LIMIT 10 OFFSET 12"
 	verify(0, "should use base offset")
 }
 
@@ -210,7 +216,7 @@ func TestJoin(t *testing.T) {
 	frag1.JoinOn(frag2, "target_id", "subquery_id")
 
 	sql, args = frag1.toSQL()
-	expected = "SELECT alias1.* FROM target AS alias1 INNER JOIN subquery alias2 ON alias1.target_id = alias2.subquery_id LIMIT 10 OFFSET 12"
+	expected = "SELECT \"alias1\".* FROM \"target\" AS \"alias1\" INNER JOIN \"subquery\" \"alias2\" ON \"alias1\".\"target_id\" = \"alias2\".\"subquery_id\" LIMIT 10 OFFSET 12"
 	verify(0, "vanilla")
 }
 
@@ -241,7 +247,7 @@ func TestDoubleJoin(t *testing.T) {
 	frag1.JoinOn(frag2, "frag1_id", "frag2_id")
 
 	sql, args = frag1.toSQL()
-	expected = "SELECT alias1.* FROM target AS alias1 INNER JOIN subquery1 alias2 ON alias1.frag1_id = alias2.frag2_id INNER JOIN subquery2 alias3 ON alias2.frag2_id = alias3.frag3_id LIMIT 10 OFFSET 12"
+	expected = "SELECT \"alias1\".* FROM \"target\" AS \"alias1\" INNER JOIN \"subquery1\" \"alias2\" ON \"alias1\".\"frag1_id\" = \"alias2\".\"frag2_id\" INNER JOIN \"subquery2\" \"alias3\" ON \"alias2\".\"frag2_id\" = \"alias3\".\"frag3_id\" LIMIT 10 OFFSET 12"
 	verify(0, "vanilla")
 
 	frag1.Where = []WhereClause{
@@ -252,6 +258,236 @@ func TestDoubleJoin(t *testing.T) {
 	}
 
 	sql, args = frag1.toSQL()
-	expected = "SELECT alias1.* FROM target AS alias1 INNER JOIN subquery1 alias2 ON alias1.frag1_id = alias2.frag2_id INNER JOIN subquery2 alias3 ON alias2.frag2_id = alias3.frag3_id WHERE field = $1 LIMIT 10 OFFSET 12"
+	expected = "SELECT \"alias1\".* FROM \"target\" AS \"alias1\" INNER JOIN \"subquery1\" \"alias2\" ON \"alias1\".\"frag1_id\" = \"alias2\".\"frag2_id\" INNER JOIN \"subquery2\" \"alias3\" ON \"alias2\".\"frag2_id\" = \"alias3\".\"frag3_id\" WHERE \"alias1\".\"field\" = $1 LIMIT 10 OFFSET 12"
 	verify(1, "where")
 }
+
+func TestWhereGroups(t *testing.T) {
+	frag := &SqlFragment{
+		Table: "table",
+		Alias: "alias",
+		Limit: 10,
+		Where: []WhereClause{
+			{Operand: whereOr, Clauses: []WhereClause{
+				{Field: "tag", Value: "cat"},
+				{Operand: whereAnd, Clauses: []WhereClause{
+					{Field: "tag", Value: "dog"},
+					{Field: "rating", Value: 3, Operand: whereGreater},
+				}},
+			}},
+			{Operand: whereNot, Clauses: []WhereClause{
+				{Field: "hidden", Operand: whereNotNull},
+			}},
+			{Field: "owner", Value: "me"},
+		},
+	}
+
+	sql, args := frag.toSQL()
+	expected := `SELECT "alias".* FROM "table" AS "alias" WHERE ("alias"."tag" = $1 OR ("alias"."tag" = $2 AND "alias"."rating" > $3)) AND NOT ("alias"."hidden" IS NOT NULL) AND "alias"."owner" = $4 LIMIT 10 OFFSET 0`
+
+	if sql != expected {
+		t.Fatalf("Incorrect SQL, got\n%s\nexpected\n%s", sql, expected)
+	}
+
+	if !reflect.DeepEqual(args, []interface{}{"cat", "dog", 3, "me"}) {
+		t.Errorf("Incorrect args, got %#v", args)
+	}
+
+	frag.Where = []WhereClause{
+		{Operand: whereOr, Clauses: []WhereClause{}},
+		{Operand: whereAnd, Clauses: []WhereClause{}},
+	}
+
+	sql, _ = frag.toSQL()
+	expected = `SELECT "alias".* FROM "table" AS "alias" WHERE 0 = 1 AND 1 = 1 LIMIT 10 OFFSET 0`
+
+	if sql != expected {
+		t.Fatalf("Incorrect SQL, got\n%s\nexpected\n%s", sql, expected)
+	}
+}
+
+func TestExtendedOperators(t *testing.T) {
+	frag := &SqlFragment{
+		Table: "table",
+		Alias: "alias",
+		Limit: 10,
+		Where: []WhereClause{
+			{Field: "title", Value: "cat!%%", Operand: whereLike},
+			{Field: "title", Value: "%DOG%", Operand: whereILike},
+			{Field: "tag", Value: []interface{}{"a", "b"}, Operand: whereIn},
+			{Field: "owner", Value: []interface{}{"me"}, Operand: whereNotIn},
+			{Field: "rating", Value: []interface{}{1, 3}, Operand: whereBetween},
+			{Field: "owner", Value: []interface{}{}, Operand: whereNotIn},
+		},
+	}
+
+	sql, args := frag.toSQL()
+	expected := `SELECT "alias".* FROM "table" AS "alias" WHERE "alias"."title" LIKE $1 ESCAPE '!' AND LOWER("alias"."title") LIKE LOWER($2) ESCAPE '!' AND "alias"."tag" IN ($3, $4) AND "alias"."owner" NOT IN ($5) AND "alias"."rating" BETWEEN $6 AND $7 AND 1 = 1 LIMIT 10 OFFSET 0`
+
+	if sql != expected {
+		t.Fatalf("Incorrect SQL, got\n%s\nexpected\n%s", sql, expected)
+	}
+
+	if !reflect.DeepEqual(args, []interface{}{"cat!%%", "%DOG%", "a", "b", "me", 1, 3}) {
+		t.Errorf("Incorrect args, got %#v", args)
+	}
+}
+
+/* The arguments of the JOIN ON clauses (including those of joins of joins) come
+ * before those of the WHERE, as their placeholders do. */
+func TestJoinArgumentOrder(t *testing.T) {
+	frag1 := &SqlFragment{
+		Table: "target",
+		Alias: "alias1",
+		Limit: 10,
+		Where: []WhereClause{{Field: "a", Value: "where"}},
+	}
+
+	frag2 := &SqlFragment{
+		Table: "subquery1",
+		Alias: "alias2",
+		Where: []WhereClause{{Operand: whereOr, Clauses: []WhereClause{
+			{Field: "b", Value: "join1"},
+			{Field: "b", Value: "join2"},
+		}}},
+	}
+
+	frag3 := &SqlFragment{
+		Table: "subquery2",
+		Alias: "alias3",
+		Where: []WhereClause{{Field: "c", Value: "join3"}},
+	}
+
+	frag2.JoinOn(frag3, "frag2_id", "frag3_id")
+	frag1.JoinOn(frag2, "frag1_id", "frag2_id")
+
+	for _, build := range []func() (string, []interface{}){frag1.toSQL, frag1.toCountSQL} {
+		sql, args := build()
+
+		expected := `INNER JOIN "subquery1" "alias2" ON "alias1"."frag1_id" = "alias2"."frag2_id" AND ("alias2"."b" = $1 OR "alias2"."b" = $2) INNER JOIN "subquery2" "alias3" ON "alias2"."frag2_id" = "alias3"."frag3_id" AND "alias3"."c" = $3 WHERE "alias1"."a" = $4`
+		if !strings.Contains(sql, expected) {
+			t.Errorf("Incorrect SQL, got\n%s\nexpected it to contain\n%s", sql, expected)
+		}
+
+		if !reflect.DeepEqual(args, []interface{}{"join1", "join2", "join3", "where"}) {
+			t.Errorf("Incorrect args, got %#v", args)
+		}
+	}
+}
+
+func TestCountedSQL(t *testing.T) {
+	frag := &SqlFragment{
+		Table:      "table",
+		Alias:      "alias",
+		Limit:      10,
+		Where:      []WhereClause{{Field: "foo", Value: "value"}},
+		CountTotal: true,
+	}
+
+	sql, args := frag.toCountedSQL()
+	expected := `SELECT "alias".*, COUNT(*) OVER () AS "#total" FROM "table" AS "alias" WHERE "alias"."foo" = $1 LIMIT 10 OFFSET 0`
+
+	if sql != expected || len(args) != 1 {
+		t.Errorf("Incorrect SQL, got\n%s\nexpected\n%s", sql, expected)
+	}
+
+	if !frag.countsOver() {
+		t.Errorf("expected the total to be selected with the rows")
+	}
+
+	/* Following a cursor leaves rows out of the count */
+	frag.After = []WhereClause{{Field: "foo", Value: "after", Operand: whereGreater}}
+	if frag.countsOver() {
+		t.Errorf("expected the total to be counted separately after a cursor")
+	}
+
+	frag.After = nil
+	frag.CountTotal = false
+	if frag.countsOver() {
+		t.Errorf("expected no total")
+	}
+
+	api.SetDialect(api.MySQLDialect)
+	defer api.SetDialect(nil)
+
+	frag.CountTotal = true
+	if frag.countsOver() {
+		t.Errorf("expected the total to be counted separately without window functions")
+	}
+}
+
+func TestTotalOptOut(t *testing.T) {
+	for request, expected := range map[string]bool{
+		`{ "model" : "Image" }`:                  true,
+		`{ "model" : "Image", "total" : true }`:  true,
+		`{ "model" : "Image", "total" : false }`: false,
+	} {
+		frag, er := decomposeTestQuery(t, `{ "images" : `+request+` }`, "images")
+		if er != nil {
+			t.Fatal(er)
+		}
+
+		if frag.CountTotal != expected {
+			t.Errorf("%s: expected CountTotal to be %v", request, expected)
+		}
+	}
+}
+
+var quotedIdentifier = regexp.MustCompile(`"(?:[^"]|"")*"`)
+
+/* FuzzIdentifiers throws arbitrary where keys and order terms at a query and checks
+ * that whatever SQL comes out only ever names things the model has. */
+func FuzzIdentifiers(f *testing.F) {
+	f.Add("mime", "uploadedDate DESC")
+	f.Add("#primary", "#primary")
+	f.Add("mime >=", "mime asc")
+	f.Add(`mime" = '' OR 1=1 --`, "mime; DROP TABLE Image")
+	f.Add("mime NULL", `ratingsAverage" DESC, "x`)
+	f.Add("ID", "orm_id")
+
+	known := map[string]bool{
+		"Image": true, "pid": true, "filehash": true, "mime": true, "uploadedDate": true, "ratingsAverage": true,
+	}
+
+	f.Fuzz(func(t *testing.T, key, order string) {
+		bs, er := json.Marshal(map[string]interface{}{
+			"images": map[string]interface{}{
+				"model": "Image",
+				"where": map[string]interface{}{key: "x"},
+				"order": order,
+			},
+		})
+		if er != nil {
+			return
+		}
+
+		var payload QueryPayload
+		if er := json.Unmarshal(bs, &payload); er != nil {
+			return
+		}
+
+		request, _ := payload.GetNamedRequest("images")
+
+		frag, er := request.Decompose(payload)
+		if er != nil {
+			return
+		}
+
+		sql, _ := frag.toSQL()
+
+		for _, ident := range quotedIdentifier.FindAllString(sql, -1) {
+			name := strings.Replace(ident[1:len(ident)-1], `""`, `"`, -1)
+
+			if !known[name] && name != frag.Alias {
+				t.Fatalf("%q, %q: unexpected identifier %s in %s", key, order, ident, sql)
+			}
+		}
+
+		rest := quotedIdentifier.ReplaceAllString(sql, "")
+		for _, bad := range []string{`"`, "'", ";", "--", "/*"} {
+			if strings.Contains(rest, bad) {
+				t.Fatalf("%q, %q: %q outside an identifier in %s", key, order, bad, sql)
+			}
+		}
+	})
+}
@@ -0,0 +1,674 @@
+package query
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"macrobooru/api"
+	"macrobooru/models"
+)
+
+/* A where clause may put its operator in either of two places: after the field
+ * name in the key (`"ratingsAverage >=" : 2.5`), in which case the value is taken
+ * literally, or at the front of the value (`"ratingsAverage" : ">= 2.5"`), in which
+ * case the value is a small expression parsed by parseCondition. */
+
+type whereOperator struct {
+	text    string
+	operand int
+}
+
+/* whereOperators maps the spelling of each operator onto its operand. Operators
+ * that are prefixes of others must come after them, since the tokenizer takes the
+ * first that matches. */
+var whereOperators = []whereOperator{
+	{">=", whereGreaterEqual},
+	{"<=", whereLessEqual},
+	{"!=", whereNotEqual},
+	{"==", whereEqual},
+	{"=", whereEqual},
+	{">", whereGreater},
+	{"<", whereLess},
+}
+
+/* whereKeywords are the operators that take no test value. */
+var whereKeywords = map[string]int{
+	"NULL":    whereNull,
+	"NOTNULL": whereNotNull,
+}
+
+/* whereWords are the operators spelled as words that take a test value. BETWEEN
+ * takes two, as `BETWEEN low AND high`. */
+var whereWords = map[string]int{
+	"LIKE":    whereLike,
+	"ILIKE":   whereILike,
+	"PREFIX":  wherePrefix,
+	"BETWEEN": whereBetween,
+}
+
+/* whereListWords are the operators whose test is a list of values, so they can
+ * only be put in the key. */
+var whereListWords = map[string]int{
+	"IN":    whereIn,
+	"NOTIN": whereNotIn,
+}
+
+/* likeEscape is the escape character of LIKE patterns. It's not a backslash, since
+ * that means something different in the string literals of each database. */
+const likeEscape = '!'
+
+const (
+	tokenOperator = iota
+	tokenKeyword
+	tokenString
+	tokenBare
+)
+
+type whereToken struct {
+	kind    int
+	text    string
+	operand int
+}
+
+/* tokenizeCondition splits a query string into operators (including those in
+ * whereWords), NULL/NOTNULL, single quoted strings and bare words. Inside quotes,
+ * a quote is written as \' or '', and a backslash as \\. ok is false for an
+ * unterminated string or a stray escape. */
+func tokenizeCondition(str string) (tokens []whereToken, ok bool) {
+	for idx := 0; idx < len(str); {
+		switch c := str[idx]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			idx += 1
+
+		case c == '\'':
+			text, end, ok := scanQuoted(str, idx)
+			if !ok {
+				return nil, false
+			}
+
+			tokens = append(tokens, whereToken{kind: tokenString, text: text})
+			idx = end
+
+		default:
+			if op, ok := operatorAt(str, idx); ok {
+				tokens = append(tokens, whereToken{kind: tokenOperator, text: op.text, operand: op.operand})
+				idx += len(op.text)
+				continue
+			}
+
+			end := idx
+			for end < len(str) && !strings.ContainsRune(" \t\n\r'", rune(str[end])) {
+				end += 1
+			}
+
+			word := str[idx:end]
+			if operand, ok := whereKeywords[word]; ok {
+				tokens = append(tokens, whereToken{kind: tokenKeyword, text: word, operand: operand})
+			} else if operand, ok := whereWords[word]; ok {
+				tokens = append(tokens, whereToken{kind: tokenOperator, text: word, operand: operand})
+			} else {
+				tokens = append(tokens, whereToken{kind: tokenBare, text: word})
+			}
+
+			idx = end
+		}
+	}
+
+	return tokens, true
+}
+
+func operatorAt(str string, idx int) (whereOperator, bool) {
+	for _, op := range whereOperators {
+		if strings.HasPrefix(str[idx:], op.text) {
+			return op, true
+		}
+	}
+
+	return whereOperator{}, false
+}
+
+/* scanQuoted reads the quoted string starting at str[start], returning its
+ * contents and the index just past the closing quote. */
+func scanQuoted(str string, start int) (string, int, bool) {
+	var buf []byte
+
+	for idx := start + 1; idx < len(str); idx += 1 {
+		switch str[idx] {
+		case '\\':
+			if idx+1 == len(str) || (str[idx+1] != '\'' && str[idx+1] != '\\') {
+				return "", 0, false
+			}
+
+			buf = append(buf, str[idx+1])
+			idx += 1
+
+		case '\'':
+			if idx+1 < len(str) && str[idx+1] == '\'' {
+				buf = append(buf, '\'')
+				idx += 1
+				continue
+			}
+
+			return string(buf), idx + 1, true
+
+		default:
+			buf = append(buf, str[idx])
+		}
+	}
+
+	return "", 0, false
+}
+
+/* startsWithOperator reports whether a query string is an expression, as opposed
+ * to a bare value to test equality against. */
+func startsWithOperator(str string) bool {
+	str = strings.TrimLeft(str, " \t\n\r")
+
+	if _, ok := operatorAt(str, 0); ok {
+		return true
+	}
+
+	words := strings.Fields(str)
+	if len(words) == 0 {
+		return false
+	}
+
+	_, isKeyword := whereKeywords[words[0]]
+	_, isWord := whereWords[words[0]]
+	return isKeyword || isWord
+}
+
+/* parseCondition parses a query string, `[operator] test`, against field. A string
+ * without an operator is a test for equality with the whole string (quotes around
+ * it are optional). With an operator, the test of a string field must be quoted.
+ * ok is false if str doesn't follow that format; er is set if it does, but the
+ * test isn't a valid value for the field. */
+func parseCondition(str string, field modelField) (operand int, value interface{}, ok bool, er error) {
+	if !startsWithOperator(str) {
+		if tokens, ok := tokenizeCondition(str); ok && len(tokens) == 1 && tokens[0].kind == tokenString {
+			str = tokens[0].text
+		}
+
+		value, er = coerceValue(str, field)
+		return whereEqual, value, true, er
+	}
+
+	tokens, ok := tokenizeCondition(str)
+	if !ok {
+		return 0, nil, false, nil
+	}
+
+	switch {
+	case len(tokens) == 1 && tokens[0].kind == tokenKeyword:
+		return tokens[0].operand, nil, true, nil
+
+	case len(tokens) == 2 && tokens[0].kind == tokenOperator && tokens[0].operand != whereBetween:
+		if !isTest(tokens[1], field) {
+			return 0, nil, false, nil
+		}
+
+		operand, value, er = coerceOperand(tokens[0].operand, tokens[1].text, field)
+		return operand, value, true, er
+
+	case len(tokens) == 4 && tokens[0].kind == tokenOperator && tokens[0].operand == whereBetween:
+		if !isTest(tokens[1], field) || tokens[2].kind != tokenBare || tokens[2].text != "AND" || !isTest(tokens[3], field) {
+			return 0, nil, false, nil
+		}
+
+		operand, value, er = coerceOperand(whereBetween, []interface{}{tokens[1].text, tokens[3].text}, field)
+		return operand, value, true, er
+	}
+
+	return 0, nil, false, nil
+}
+
+/* isTest reports whether token may be the value an operator tests field against. */
+func isTest(token whereToken, field modelField) bool {
+	switch token.kind {
+	case tokenString:
+		return true
+
+	case tokenBare:
+		return !isStringField(field.Type)
+	}
+
+	return false
+}
+
+func isStringField(fieldType reflect.Type) bool {
+	if fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+
+	return fieldType.Kind() == reflect.String
+}
+
+/* isRangeField reports whether BETWEEN can be used on a field of fieldType, which
+ * it can for numbers and timestamps. */
+func isRangeField(fieldType reflect.Type) bool {
+	if fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+
+	switch fieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+
+	return fieldType == timeType
+}
+
+/* coerceOperand converts the test of a clause on field with operand into what the
+ * column is compared with, as coerceValue does. LIKE and ILIKE take a pattern for
+ * a string field; PREFIX takes a string, and becomes a LIKE of it with its
+ * wildcards escaped. BETWEEN takes a list of the two bounds, IN and NOTIN a list
+ * of values (or a single value). */
+func coerceOperand(operand int, value interface{}, field modelField) (int, interface{}, error) {
+	switch operand {
+	case whereLike, whereILike, wherePrefix:
+		str, ok := value.(string)
+		if !ok || !isStringField(field.Type) {
+			return 0, nil, errInvalidValue
+		}
+
+		if operand == wherePrefix {
+			return whereLike, escapeLike(str) + "%", nil
+		}
+
+		return operand, str, nil
+
+	case whereBetween:
+		bounds, ok := value.([]interface{})
+		if !ok || len(bounds) != 2 || !isRangeField(field.Type) {
+			return 0, nil, errInvalidValue
+		}
+
+		coerced, er := coerceValues(bounds, field)
+		return operand, coerced, er
+
+	case whereIn, whereNotIn:
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+
+		coerced, er := coerceValues(values, field)
+		return operand, coerced, er
+	}
+
+	coerced, er := coerceValue(value, field)
+	return operand, coerced, er
+}
+
+/* escapeLike escapes the wildcards in str, so that a LIKE of it matches only str
+ * itself. */
+func escapeLike(str string) string {
+	var buf []byte
+
+	for idx := 0; idx < len(str); idx += 1 {
+		switch str[idx] {
+		case '%', '_', likeEscape:
+			buf = append(buf, likeEscape)
+		}
+
+		buf = append(buf, str[idx])
+	}
+
+	return string(buf)
+}
+
+func coerceValues(values []interface{}, field modelField) ([]interface{}, error) {
+	coerced := make([]interface{}, len(values))
+
+	for idx, val := range values {
+		var er error
+		if coerced[idx], er = coerceValue(val, field); er != nil {
+			return nil, er
+		}
+	}
+
+	return coerced, nil
+}
+
+var errInvalidValue = errors.New("invalid value for field")
+
+/* coerceValue converts a value from a where clause into what the column behind
+ * field is compared with: numbers for numeric fields, booleans for boolean fields
+ * and strings for everything else. Strings are parsed as whatever the field holds.
+ * Timestamps are given as RFC 3339 strings or UNIX time, and compared as UNIX time
+ * if that's how the column stores them. */
+func coerceValue(value interface{}, field modelField) (interface{}, error) {
+	fieldType := field.Type
+	if fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+
+	switch fieldType {
+	case guidType:
+		/* GUIDs are stored in upper case, so lower-case input wouldn't match */
+		if str, ok := value.(string); ok {
+			if guid, er := models.GUIDFromString(str); er == nil {
+				return guid.String(), nil
+			}
+		}
+
+		return nil, errInvalidValue
+
+	case timeType:
+		var t time.Time
+
+		if str, ok := value.(string); ok {
+			t, _ = time.Parse(time.RFC3339, str)
+		}
+
+		if unix, ok := numberValue(value); t.IsZero() && ok && unix == math.Trunc(unix) && math.Abs(unix) < 1<<63 {
+			t = time.Unix(int64(unix), 0).UTC()
+
+		} else if t.IsZero() {
+			return nil, errInvalidValue
+		}
+
+		if field.Unix {
+			return t.Unix(), nil
+		}
+
+		return t, nil
+	}
+
+	switch fieldType.Kind() {
+	case reflect.String:
+		switch val := value.(type) {
+		case string:
+			return val, nil
+
+		case bool:
+			return strconv.FormatBool(val), nil
+		}
+
+		if f, ok := numberValue(value); ok {
+			return strconv.FormatFloat(f, 'f', -1, 64), nil
+		}
+
+	case reflect.Bool:
+		switch val := value.(type) {
+		case bool:
+			return val, nil
+
+		case string:
+			if b, er := strconv.ParseBool(val); er == nil {
+				return b, nil
+			}
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch val := value.(type) {
+		case int64:
+			return val, nil
+
+		case string:
+			if i, er := strconv.ParseInt(val, 10, 64); er == nil {
+				return i, nil
+			}
+
+		default:
+			if f, ok := numberValue(val); ok && f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+				return int64(f), nil
+			}
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		switch val := value.(type) {
+		case uint64:
+			return val, nil
+
+		case string:
+			if u, er := strconv.ParseUint(val, 10, 64); er == nil {
+				return u, nil
+			}
+
+		default:
+			if f, ok := numberValue(val); ok && f >= 0 && f == math.Trunc(f) && f < 1<<64 {
+				return uint64(f), nil
+			}
+		}
+
+	case reflect.Float32, reflect.Float64:
+		if f, ok := numberValue(value); ok && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return f, nil
+		}
+	}
+
+	return nil, errInvalidValue
+}
+
+/* numberValue is numericValue without the leniency towards booleans. */
+func numberValue(value interface{}) (float64, bool) {
+	if _, ok := value.(bool); ok {
+		return 0, false
+	}
+
+	return numericValue(value)
+}
+
+/* maxWhereDepth bounds how deeply $and, $or and $not groups may nest. */
+const maxWhereDepth = 8
+
+/* desugarWhere turns the where map of a request into clauses on model's columns,
+ * refusing fields the model doesn't have and operators that don't exist. */
+func desugarWhere(clauses map[string]interface{}, model models.ModelMeta) ([]WhereClause, error) {
+	return desugarWhereDepth(clauses, model, 0)
+}
+
+func desugarWhereDepth(clauses map[string]interface{}, model models.ModelMeta, depth int) ([]WhereClause, error) {
+	whereClauses := []WhereClause{}
+
+	for key, value := range clauses {
+		if strings.HasPrefix(key, "$") {
+			group, er := desugarGroup(key, value, model, depth)
+			if er != nil {
+				return nil, er
+			}
+
+			whereClauses = append(whereClauses, group)
+			continue
+		}
+
+		fieldParts := strings.Fields(key)
+		if len(fieldParts) == 0 || len(fieldParts) > 2 {
+			return nil, api.ErrorInvalidInputField(key)
+		}
+
+		field, ok := fieldInfo(model, fieldParts[0])
+		if !ok {
+			return nil, api.ErrorInvalidInputField(fieldParts[0])
+		}
+
+		var (
+			parsed []WhereClause
+			er     error
+		)
+
+		if len(fieldParts) == 2 {
+			parsed, er = desugarKeyOperator(field, fieldParts[1], value)
+		} else {
+			parsed, er = desugarValue(field, value)
+		}
+
+		if er != nil {
+			return nil, api.ErrorInvalidInputField(key)
+		}
+
+		whereClauses = append(whereClauses, parsed...)
+	}
+
+	return whereClauses, nil
+}
+
+/* desugarGroup handles the `$and`, `$or` and `$not` keys. `$and` and `$or` take a
+ * list of where maps, each of which holds if all of its constraints do; `$not`
+ * takes a single where map (or a list, which it treats as `$and` would). */
+func desugarGroup(key string, value interface{}, model models.ModelMeta, depth int) (WhereClause, error) {
+	if depth >= maxWhereDepth {
+		return WhereClause{}, api.ErrorInvalidInputField(key)
+	}
+
+	var operand int
+
+	switch key {
+	case "$and":
+		operand = whereAnd
+
+	case "$or":
+		operand = whereOr
+
+	case "$not":
+		operand = whereNot
+
+		if single, ok := value.(map[string]interface{}); ok {
+			value = []interface{}{single}
+		}
+
+	default:
+		return WhereClause{}, api.ErrorInvalidInputField(key)
+	}
+
+	maps, ok := value.([]interface{})
+	if !ok {
+		return WhereClause{}, api.ErrorInvalidInputField(key)
+	}
+
+	group := WhereClause{
+		Operand: operand,
+		Clauses: []WhereClause{},
+	}
+
+	for _, item := range maps {
+		where, ok := item.(map[string]interface{})
+		if !ok {
+			return WhereClause{}, api.ErrorInvalidInputField(key)
+		}
+
+		clauses, er := desugarWhereDepth(where, model, depth+1)
+		if er != nil {
+			return WhereClause{}, er
+		}
+
+		group.Clauses = append(group.Clauses, WhereClause{
+			Operand: whereAnd,
+			Clauses: clauses,
+		})
+	}
+
+	return group, nil
+}
+
+/* desugarKeyOperator handles the `"field operator" : value` form, where value is
+ * the literal to test against. For the operators taking a list, value is that
+ * list; for the others, a list of values tests for equality with any of them. */
+func desugarKeyOperator(field modelField, operator string, value interface{}) ([]WhereClause, error) {
+	if operand, ok := whereKeywords[operator]; ok {
+		return []WhereClause{{Field: field.Column, Operand: operand}}, nil
+	}
+
+	operand, ok := whereWords[operator]
+	if !ok {
+		operand, ok = whereListWords[operator]
+	}
+
+	if ok {
+		operand, coerced, er := coerceOperand(operand, value, field)
+		if er != nil {
+			return nil, er
+		}
+
+		return []WhereClause{{Field: field.Column, Value: coerced, Operand: operand}}, nil
+	}
+
+	for _, op := range whereOperators {
+		if op.text == operator {
+			operand, ok = op.operand, true
+			break
+		}
+	}
+
+	if !ok {
+		return nil, errInvalidValue
+	}
+
+	if values, isSlice := value.([]interface{}); isSlice {
+		coerced, er := coerceValues(values, field)
+		if er != nil {
+			return nil, er
+		}
+
+		return []WhereClause{{Field: field.Column, Value: coerced, Operand: operand}}, nil
+	}
+
+	coerced, er := coerceValue(value, field)
+	if er != nil {
+		return nil, er
+	}
+
+	return []WhereClause{{Field: field.Column, Value: coerced, Operand: operand}}, nil
+}
+
+/* desugarValue handles the `"field" : value` form, where value is a query string,
+ * a number or boolean to test equality against, or a list of those. In a list,
+ * the values without an operator are gathered into one test for equality with any
+ * of them (so a list of GUIDs matches any of those objects); the rest must each
+ * hold. A query string that doesn't follow the format is an invalid value. */
+func desugarValue(field modelField, value interface{}) ([]WhereClause, error) {
+	values, isSlice := value.([]interface{})
+	if !isSlice {
+		values = []interface{}{value}
+	}
+
+	whereClauses := []WhereClause{}
+	equalTo := []interface{}{}
+
+	for _, val := range values {
+		switch v := val.(type) {
+		case string:
+			operand, parsed, ok, er := parseCondition(v, field)
+			if er != nil {
+				return nil, er
+
+			} else if !ok {
+				return nil, errInvalidValue
+
+			} else if operand == whereEqual && !startsWithOperator(v) {
+				equalTo = append(equalTo, parsed)
+
+			} else {
+				whereClauses = append(whereClauses, WhereClause{Field: field.Column, Value: parsed, Operand: operand})
+			}
+
+		case nil, []interface{}, map[string]interface{}, map[interface{}]interface{}:
+			return nil, errInvalidValue
+
+		default:
+			coerced, er := coerceValue(v, field)
+			if er != nil {
+				return nil, er
+			}
+
+			equalTo = append(equalTo, coerced)
+		}
+	}
+
+	switch {
+	case isSlice && (len(values) == 0 || len(equalTo) > 1):
+		whereClauses = append(whereClauses, WhereClause{Field: field.Column, Value: equalTo, Operand: whereEqual})
+
+	case len(equalTo) == 1:
+		whereClauses = append(whereClauses, WhereClause{Field: field.Column, Value: equalTo[0], Operand: whereEqual})
+	}
+
+	return whereClauses, nil
+}
@@ -0,0 +1,296 @@
+package query
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"macrobooru/models"
+)
+
+func TestTokenizeCondition(t *testing.T) {
+	tests := []struct {
+		input  string
+		tokens []whereToken
+		ok     bool
+	}{
+		{"", nil, true},
+		{">= 2.5", []whereToken{{tokenOperator, ">=", whereGreaterEqual}, {tokenBare, "2.5", 0}}, true},
+		{">=2.5", []whereToken{{tokenOperator, ">=", whereGreaterEqual}, {tokenBare, "2.5", 0}}, true},
+		{"== 'foo'", []whereToken{{tokenOperator, "==", whereEqual}, {tokenString, "foo", 0}}, true},
+		{"!= 'it''s'", []whereToken{{tokenOperator, "!=", whereNotEqual}, {tokenString, "it's", 0}}, true},
+		{`< 'it\'s \\ here'`, []whereToken{{tokenOperator, "<", whereLess}, {tokenString, `it's \ here`, 0}}, true},
+		{"'a = b'", []whereToken{{tokenString, "a = b", 0}}, true},
+		{"NULL", []whereToken{{tokenKeyword, "NULL", whereNull}}, true},
+		{" NOTNULL ", []whereToken{{tokenKeyword, "NOTNULL", whereNotNull}}, true},
+		{"PREFIX 'a%'", []whereToken{{tokenOperator, "PREFIX", wherePrefix}, {tokenString, "a%", 0}}, true},
+		{"BETWEEN 1 AND 2", []whereToken{{tokenOperator, "BETWEEN", whereBetween}, {tokenBare, "1", 0}, {tokenBare, "AND", 0}, {tokenBare, "2", 0}}, true},
+		{"= 'unterminated", nil, false},
+		{`= 'bad \escape'`, nil, false},
+		{`= 'trailing \`, nil, false},
+	}
+
+	for _, test := range tests {
+		tokens, ok := tokenizeCondition(test.input)
+
+		if ok != test.ok {
+			t.Errorf("%q: expected ok=%v, got %v", test.input, test.ok, ok)
+			continue
+		}
+
+		if !reflect.DeepEqual(tokens, test.tokens) {
+			t.Errorf("%q: expected %v, got %v", test.input, test.tokens, tokens)
+		}
+	}
+}
+
+func TestParseCondition(t *testing.T) {
+	stringType := reflect.TypeOf("")
+	floatType := reflect.TypeOf(float32(0))
+	intType := reflect.TypeOf(int64(0))
+	boolType := reflect.TypeOf(false)
+
+	guid := models.NewGUID().String()
+	date := time.Date(2014, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		input     string
+		fieldType reflect.Type
+		operand   int
+		value     interface{}
+		ok        bool
+		invalid   bool
+	}{
+		{">= 2.5", floatType, whereGreaterEqual, 2.5, true, false},
+		{"< '3'", floatType, whereLess, 3.0, true, false},
+		{"2.5", floatType, whereEqual, 2.5, true, false},
+		{"== 7", intType, whereEqual, int64(7), true, false},
+		{"= 7.5", intType, 0, nil, true, true},
+		{"!= true", boolType, whereNotEqual, true, true, false},
+		{"!= 'foo'", stringType, whereNotEqual, "foo", true, false},
+		{"= 'it''s'", stringType, whereEqual, "it's", true, false},
+		{"foo", stringType, whereEqual, "foo", true, false},
+		{"'foo'", stringType, whereEqual, "foo", true, false},
+		{"'foo' bar", stringType, whereEqual, "'foo' bar", true, false},
+		{"two words", stringType, whereEqual, "two words", true, false},
+		{"NULL", stringType, whereNull, nil, true, false},
+		{"NOTNULL", floatType, whereNotNull, nil, true, false},
+		{"NULLABLE", stringType, whereEqual, "NULLABLE", true, false},
+		{guid, guidType, whereEqual, guid, true, false},
+		{"!= " + guid, guidType, whereNotEqual, guid, true, false},
+		{strings.ToLower(guid), guidType, whereEqual, guid, true, false},
+		{"= not-a-guid", guidType, 0, nil, true, true},
+		{"> 1000", timeType, whereGreater, int64(1000), true, false},
+		{"> 2014-03-01T12:00:00Z", timeType, whereGreater, date.Unix(), true, false},
+		{"> '2014-03-01T13:00:00+01:00'", timeType, whereGreater, date.Unix(), true, false},
+		{"> yesterday", timeType, 0, nil, true, true},
+		{"LIKE 'image/%'", stringType, whereLike, "image/%", true, false},
+		{"ILIKE 'IMAGE/%'", stringType, whereILike, "IMAGE/%", true, false},
+		{"PREFIX '50%_off!'", stringType, whereLike, "50!%!_off!!%", true, false},
+		{"LIKE 2", floatType, 0, nil, true, true},
+		{"BETWEEN 1 AND 2.5", floatType, whereBetween, []interface{}{1.0, 2.5}, true, false},
+		{"BETWEEN 1000 AND 2014-03-01T12:00:00Z", timeType, whereBetween, []interface{}{int64(1000), date.Unix()}, true, false},
+		{"BETWEEN 'a' AND 'b'", stringType, 0, nil, true, true},
+
+		/* Not in the format, so ignored */
+		{"!= foo", stringType, 0, nil, false, false},
+		{">= 'unterminated", stringType, 0, nil, false, false},
+		{">=", floatType, 0, nil, false, false},
+		{"> 1 2", floatType, 0, nil, false, false},
+		{"NULL 2", floatType, 0, nil, false, false},
+		{"= = 2", floatType, 0, nil, false, false},
+		{"LIKE", stringType, 0, nil, false, false},
+		{"LIKE foo", stringType, 0, nil, false, false},
+		{"BETWEEN 1", floatType, 0, nil, false, false},
+		{"BETWEEN 1 OR 2", floatType, 0, nil, false, false},
+	}
+
+	for _, test := range tests {
+		field := modelField{Type: test.fieldType, Unix: test.fieldType == timeType}
+		operand, value, ok, er := parseCondition(test.input, field)
+
+		if ok != test.ok {
+			t.Errorf("%q: expected ok=%v, got %v", test.input, test.ok, ok)
+			continue
+		}
+
+		if (er != nil) != test.invalid {
+			t.Errorf("%q: expected invalid=%v, got %v", test.input, test.invalid, er)
+			continue
+		}
+
+		if !ok || er != nil {
+			continue
+		}
+
+		if operand != test.operand || !reflect.DeepEqual(value, test.value) {
+			t.Errorf("%q: expected %d %#v, got %d %#v", test.input, test.operand, test.value, operand, value)
+		}
+	}
+}
+
+func TestDesugarWhere(t *testing.T) {
+	model := models.ModelByName("Image")
+	guid1, guid2 := models.NewGUID().String(), models.NewGUID().String()
+
+	tests := []struct {
+		where   map[string]interface{}
+		clauses []WhereClause
+	}{
+		{
+			map[string]interface{}{"ratingsAverage": ">= 2.5"},
+			[]WhereClause{{Field: "ratingsAverage", Value: 2.5, Operand: whereGreaterEqual}},
+		},
+		{
+			map[string]interface{}{"ratingsAverage >=": 2.5},
+			[]WhereClause{{Field: "ratingsAverage", Value: 2.5, Operand: whereGreaterEqual}},
+		},
+		{
+			map[string]interface{}{"ratingsAverage >=": "2.5"},
+			[]WhereClause{{Field: "ratingsAverage", Value: 2.5, Operand: whereGreaterEqual}},
+		},
+		{
+			map[string]interface{}{"mime ==": "image/png"},
+			[]WhereClause{{Field: "mime", Value: "image/png", Operand: whereEqual}},
+		},
+		{
+			/* With the operator in the key, the value is taken literally */
+			map[string]interface{}{"mime !=": "> 'x'"},
+			[]WhereClause{{Field: "mime", Value: "> 'x'", Operand: whereNotEqual}},
+		},
+		{
+			map[string]interface{}{"mime": "NULL"},
+			[]WhereClause{{Field: "mime", Value: nil, Operand: whereNull}},
+		},
+		{
+			map[string]interface{}{"mime NOTNULL": true},
+			[]WhereClause{{Field: "mime", Value: nil, Operand: whereNotNull}},
+		},
+		{
+			map[string]interface{}{"uploadedDate": []interface{}{"> 100", "<= 200"}},
+			[]WhereClause{{Field: "uploadedDate", Value: int64(100), Operand: whereGreater}, {Field: "uploadedDate", Value: int64(200), Operand: whereLessEqual}},
+		},
+		{
+			map[string]interface{}{"#primary": []interface{}{guid1, guid2}},
+			[]WhereClause{{Field: "pid", Value: []interface{}{guid1, guid2}, Operand: whereEqual}},
+		},
+		{
+			map[string]interface{}{"mime": []interface{}{"image/png", "'image/gif'"}},
+			[]WhereClause{{Field: "mime", Value: []interface{}{"image/png", "image/gif"}, Operand: whereEqual}},
+		},
+		{
+			map[string]interface{}{"mime": []interface{}{}},
+			[]WhereClause{{Field: "mime", Value: []interface{}{}, Operand: whereEqual}},
+		},
+		{
+			map[string]interface{}{"mime PREFIX": "image/"},
+			[]WhereClause{{Field: "mime", Value: "image/%", Operand: whereLike}},
+		},
+		{
+			map[string]interface{}{"mime ILIKE": "%PNG"},
+			[]WhereClause{{Field: "mime", Value: "%PNG", Operand: whereILike}},
+		},
+		{
+			map[string]interface{}{"mime IN": []interface{}{"image/png", "image/gif"}},
+			[]WhereClause{{Field: "mime", Value: []interface{}{"image/png", "image/gif"}, Operand: whereIn}},
+		},
+		{
+			map[string]interface{}{"ratingsAverage NOTIN": 3.0},
+			[]WhereClause{{Field: "ratingsAverage", Value: []interface{}{3.0}, Operand: whereNotIn}},
+		},
+		{
+			map[string]interface{}{"uploadedDate BETWEEN": []interface{}{"2014-03-01T12:00:00Z", 1400000000.0}},
+			[]WhereClause{{Field: "uploadedDate", Value: []interface{}{int64(1393675200), int64(1400000000)}, Operand: whereBetween}},
+		},
+	}
+
+	for _, test := range tests {
+		clauses, er := desugarWhere(test.where, model)
+		if er != nil {
+			t.Errorf("%v: %s", test.where, er)
+			continue
+		}
+
+		if !reflect.DeepEqual(clauses, test.clauses) {
+			t.Errorf("%v: expected %#v, got %#v", test.where, test.clauses, clauses)
+		}
+	}
+}
+
+func TestDesugarWhereGroups(t *testing.T) {
+	model := models.ModelByName("Image")
+
+	clauses, er := desugarWhere(map[string]interface{}{
+		"$or": []interface{}{
+			map[string]interface{}{"mime": "image/png"},
+			map[string]interface{}{"$not": map[string]interface{}{"ratingsAverage <": 3.0}},
+		},
+	}, model)
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	expected := []WhereClause{{Operand: whereOr, Clauses: []WhereClause{
+		{Operand: whereAnd, Clauses: []WhereClause{
+			{Field: "mime", Value: "image/png", Operand: whereEqual},
+		}},
+		{Operand: whereAnd, Clauses: []WhereClause{
+			{Operand: whereNot, Clauses: []WhereClause{
+				{Operand: whereAnd, Clauses: []WhereClause{
+					{Field: "ratingsAverage", Value: 3.0, Operand: whereLess},
+				}},
+			}},
+		}},
+	}}}
+
+	if !reflect.DeepEqual(clauses, expected) {
+		t.Errorf("expected %#v, got %#v", expected, clauses)
+	}
+
+	/* Groups nest only so deep */
+	where := map[string]interface{}{"mime": "image/png"}
+	for i := 0; i <= maxWhereDepth; i += 1 {
+		where = map[string]interface{}{"$and": []interface{}{where}}
+	}
+
+	if _, er := desugarWhere(where, model); er == nil {
+		t.Errorf("expected an error for nesting too deep")
+	}
+}
+
+func TestDesugarWhereRejects(t *testing.T) {
+	model := models.ModelByName("Image")
+
+	for _, where := range []map[string]interface{}{
+		{"bogus": "x"},
+		{"ratingsAverage LIKE": "x"},
+		{"mime LIKE": 2.0},
+		{"mime BETWEEN": []interface{}{"a", "b"}},
+		{"ratingsAverage BETWEEN": 2.0},
+		{"ratingsAverage BETWEEN": []interface{}{1.0, 2.0, 3.0}},
+		{"ratingsAverage NOTIN": []interface{}{"high"}},
+		{"uploadedDate >": "yesterday"},
+		{"mime like": "x"},
+		{"mime = x": "x"},
+		{"ratingsAverage": "high"},
+		{"ratingsAverage": ">= high"},
+		{"mime": "> foo"},
+		{"mime": ">= 'unterminated"},
+		{"mime": []interface{}{"image/png", "!= foo"}},
+		{"ratingsAverage >": "high"},
+		{"pid": "not-a-guid"},
+		{"mime": nil},
+		{"mime": map[string]interface{}{}},
+		{"mime": []interface{}{[]interface{}{}}},
+		{"$xor": []interface{}{}},
+		{"$or": map[string]interface{}{"mime": "x"}},
+		{"$or": []interface{}{"mime"}},
+		{"$and": []interface{}{map[string]interface{}{"bogus": "x"}}},
+		{"$not": "mime"},
+	} {
+		if _, er := desugarWhere(where, model); er == nil {
+			t.Errorf("%v: expected an error", where)
+		}
+	}
+}
@@ -1,11 +1,9 @@
 package resetpassword
 
 import (
-	"database/sql"
-	"encoding/json"
+	"context"
 
 	"macrobooru/api"
-	"macrobooru/models"
 )
 
 type ResetPasswordPayload struct {
@@ -20,7 +18,7 @@ func (rpp *ResetPasswordPayload) Name() string {
 func (rpp *ResetPasswordPayload) Parse(req *api.RequestWrapper) (api.Operation, error) {
 	var payload ResetPasswordPayload
 
-	if er := json.Unmarshal(req.RawData, &payload); er != nil {
+	if er := req.UnmarshalData(&payload); er != nil {
 		return nil, er
 	}
 
@@ -31,6 +29,6 @@ func (ap *ResetPasswordPayload) ParseResponse(resWrapper api.ResponseWrapper) (i
 	return nil, nil
 }
 
-func (rpp *ResetPasswordPayload) Process(u *models.User, db *sql.DB) (interface{}, error) {
+func (rpp *ResetPasswordPayload) Execute(ctx context.Context, req *api.Request, db api.Queryer) (interface{}, error) {
 	return nil, nil
 }
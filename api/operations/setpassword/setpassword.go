@@ -1,8 +1,7 @@
 package setpassword
 
 import (
-	"database/sql"
-	"encoding/json"
+	"context"
 
 	"macrobooru/api"
 	"macrobooru/models"
@@ -29,7 +28,7 @@ func (spp *SetPasswordPayload) Name() string {
 func (spp *SetPasswordPayload) Parse(req *api.RequestWrapper) (api.Operation, error) {
 	var payload SetPasswordPayload
 
-	if er := json.Unmarshal(req.RawData, &payload); er != nil {
+	if er := req.UnmarshalData(&payload); er != nil {
 		return nil, er
 	}
 
@@ -40,6 +39,6 @@ func (ap *SetPasswordPayload) ParseResponse(resWrapper api.ResponseWrapper) (int
 	return nil, nil
 }
 
-func (spp *SetPasswordPayload) Process(u *models.User, db *sql.DB) (interface{}, error) {
+func (spp *SetPasswordPayload) Execute(ctx context.Context, req *api.Request, db api.Queryer) (interface{}, error) {
 	return nil, nil
 }
@@ -5,5 +5,5 @@ import (
 )
 
 func init() {
-	api.RegisterOperation(&StaticStatusPayload{})
+	api.RegisterOperation(&StaticStatusPayload{}, api.RequiresAuth)
 }
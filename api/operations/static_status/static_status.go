@@ -1,11 +1,8 @@
 package static_status
 
 import (
-	"database/sql"
+	"context"
 	"macrobooru/api"
-	"macrobooru/models"
-
-	"encoding/json"
 )
 
 type StaticStatusPayload struct {
@@ -20,7 +17,7 @@ func (spp *StaticStatusPayload) Name() string {
 func (spp *StaticStatusPayload) Parse(req *api.RequestWrapper) (api.Operation, error) {
 	var payload StaticStatusPayload
 
-	if er := json.Unmarshal(req.RawData, &payload); er != nil {
+	if er := req.UnmarshalData(&payload); er != nil {
 		return nil, api.ErrorInvalidInputFormat(er.Error())
 	}
 
@@ -31,6 +28,6 @@ func (ap *StaticStatusPayload) ParseResponse(resWrapper api.ResponseWrapper) (in
 	return nil, nil
 }
 
-func (spp *StaticStatusPayload) Process(u *models.User, db *sql.DB) (interface{}, error) {
+func (spp *StaticStatusPayload) Execute(ctx context.Context, req *api.Request, db api.Queryer) (interface{}, error) {
 	return nil, nil
 }
@@ -0,0 +1,10 @@
+package subscribe
+
+import (
+	"macrobooru/api"
+)
+
+func init() {
+	api.RegisterOperation(&SubscribePayload{}, api.RequiresAuth)
+	api.RegisterOperation(&UnsubscribePayload{}, api.RequiresAuth)
+}
@@ -0,0 +1,206 @@
+package subscribe
+
+import (
+	"context"
+	"time"
+
+	"macrobooru/api"
+	"macrobooru/api/changes"
+	"macrobooru/api/operations/query"
+	"macrobooru/models"
+)
+
+const (
+	DefaultTimeout = 30 * time.Second
+	MaxTimeout     = 60 * time.Second
+)
+
+/* SubscribePayload either creates a subscription (when Subscription is empty) or
+ * long-polls an existing one for events. */
+type SubscribePayload struct {
+	// Model and Where select the objects to hear about, exactly as in a query.
+	Model string                 `json:"model,omitempty"`
+	Where map[string]interface{} `json:"where,omitempty"`
+
+	// Subscription is the ID returned when the subscription was created.
+	Subscription string `json:"subscription,omitempty"`
+
+	// Timeout is how many seconds to wait for events before returning empty
+	// handed. Defaults to DefaultTimeout, and is capped at MaxTimeout.
+	Timeout int `json:"timeout,omitempty"`
+}
+
+func (*SubscribePayload) Name() string {
+	return "subscribe"
+}
+
+func (*SubscribePayload) Parse(req *api.RequestWrapper) (api.Operation, error) {
+	var payload SubscribePayload
+
+	if er := req.UnmarshalData(&payload); er != nil {
+		return nil, api.ErrorInvalidInputFormat(er.Error())
+	}
+
+	if payload.Subscription == "" && payload.Model == "" {
+		return nil, api.ErrorMissingInputField("model")
+	}
+
+	if payload.Timeout < 0 {
+		return nil, api.ErrorInvalidInputField("timeout")
+	}
+
+	return &payload, nil
+}
+
+func (*SubscribePayload) ParseResponse(wrapper api.ResponseWrapper) (interface{}, error) {
+	var raw rawSubscribeResponse
+
+	if er := wrapper.UnmarshalData(&raw); er != nil {
+		return nil, api.ErrorGeneric(er)
+	}
+
+	res := SubscribeResponse{}
+
+	if er := res.fromRaw(&raw, wrapper.UnmarshalNested); er != nil {
+		return nil, api.ErrorGeneric(er)
+	}
+
+	return &res, nil
+}
+
+func (payload *SubscribePayload) timeout() time.Duration {
+	timeout := time.Duration(payload.Timeout) * time.Second
+
+	if timeout == 0 {
+		return DefaultTimeout
+
+	} else if timeout > MaxTimeout {
+		return MaxTimeout
+	}
+
+	return timeout
+}
+
+func (payload *SubscribePayload) Execute(ctx context.Context, req *api.Request, db api.Queryer) (interface{}, error) {
+	if payload.Subscription == "" {
+		sub, er := Subscribe(changes.DefaultHub, owner(req.User), payload.Model, payload.Where)
+		if er != nil {
+			return nil, er
+		}
+
+		return &SubscribeResponse{
+			Subscription: sub.ID,
+			Events:       []changes.Event{},
+		}, nil
+	}
+
+	sub, er := lookup(changes.DefaultHub, req.User, payload.Subscription)
+	if er != nil {
+		return nil, er
+	}
+
+	events, missed := sub.Wait(ctx, payload.timeout())
+
+	return &SubscribeResponse{
+		Subscription: sub.ID,
+		Events:       wireEvents(events),
+		Missed:       missed,
+	}, nil
+}
+
+/* wireEvents is events with their objects in wire form, as they're sent. */
+func wireEvents(events []changes.Event) []changes.Event {
+	wire := make([]changes.Event, len(events))
+
+	for idx, event := range events {
+		event.Object = models.WireForm(event.Object)
+		wire[idx] = event
+	}
+
+	return wire
+}
+
+/* Subscribe registers a subscription, belonging to owner, to the objects of
+ * modelName matching where. Only models a query could name can be subscribed to,
+ * and their events carry the objects in the same wire form a query returns. */
+func Subscribe(hub *changes.Hub, owner string, modelName string, where map[string]interface{}) (*changes.Subscription, error) {
+	if owner == "" {
+		return nil, api.ErrorRequiresAuthentication()
+	}
+
+	matcher, er := query.NewMatcher(modelName, where)
+	if er != nil {
+		return nil, er
+	}
+
+	sub, er := hub.Subscribe(owner, func(event changes.Event) bool {
+		return event.Model == matcher.Model.Name() && matcher.Match(event.Object)
+	})
+
+	if er == changes.ErrTooManySubscriptions {
+		return nil, api.ErrorTooManySubscriptions(changes.MaxSubscriptionsPerOwner)
+
+	} else if er != nil {
+		return nil, api.ErrorGeneric(er)
+	}
+
+	return sub, nil
+}
+
+/* Subscriptions are tied to whoever created them. Creating one takes a user, so
+ * only the empty owner of an anonymous request matches none. */
+func owner(user *models.User) string {
+	if user == nil {
+		return ""
+	}
+
+	return user.Pid.String()
+}
+
+func lookup(hub *changes.Hub, user *models.User, id string) (*changes.Subscription, error) {
+	sub := hub.Lookup(id)
+
+	/* Don't let on whether somebody else's subscription exists */
+	if sub == nil || sub.Owner != owner(user) {
+		return nil, api.ErrorInvalidInputField("subscription")
+	}
+
+	return sub, nil
+}
+
+/* UnsubscribePayload cancels a subscription before it would otherwise expire. */
+type UnsubscribePayload struct {
+	Subscription string `json:"subscription"`
+}
+
+func (*UnsubscribePayload) Name() string {
+	return "unsubscribe"
+}
+
+func (*UnsubscribePayload) Parse(req *api.RequestWrapper) (api.Operation, error) {
+	var payload UnsubscribePayload
+
+	if er := req.UnmarshalData(&payload); er != nil {
+		return nil, api.ErrorInvalidInputFormat(er.Error())
+	}
+
+	if payload.Subscription == "" {
+		return nil, api.ErrorMissingInputField("subscription")
+	}
+
+	return &payload, nil
+}
+
+func (*UnsubscribePayload) ParseResponse(wrapper api.ResponseWrapper) (interface{}, error) {
+	return nil, nil
+}
+
+func (payload *UnsubscribePayload) Execute(ctx context.Context, req *api.Request, db api.Queryer) (interface{}, error) {
+	sub, er := lookup(changes.DefaultHub, req.User, payload.Subscription)
+	if er != nil {
+		return nil, er
+	}
+
+	changes.DefaultHub.Unsubscribe(sub.ID)
+	return nil, nil
+}
@@ -0,0 +1,97 @@
+package subscribe
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"macrobooru/api"
+	"macrobooru/api/changes"
+	"macrobooru/models"
+)
+
+func TestLongPoll(t *testing.T) {
+	ctx := context.Background()
+	req := &api.Request{User: &models.User{Pid: models.NewGUID()}}
+
+	created, er := (&SubscribePayload{
+		Model: "Image",
+		Where: map[string]interface{}{"mime": "image/png"},
+	}).Execute(ctx, req, nil)
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	id := created.(*SubscribeResponse).Subscription
+	defer changes.DefaultHub.Unsubscribe(id)
+
+	png := &models.Image{Pid: models.NewGUID(), Mime: "image/png"}
+	gif := &models.Image{Pid: models.NewGUID(), Mime: "image/gif"}
+
+	changes.Publish(
+		changes.Event{Kind: changes.Created, Model: "Image", Pid: gif.Pid, Object: gif},
+		changes.Event{Kind: changes.Updated, Model: "Image", Pid: png.Pid, Object: png},
+	)
+
+	polled, er := (&SubscribePayload{Subscription: id, Timeout: 1}).Execute(ctx, req, nil)
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	events := polled.(*SubscribeResponse).Events
+	if len(events) != 1 || events[0].Kind != changes.Updated || !events[0].Pid.Equal(png.Pid) {
+		t.Fatalf("unexpected events %v", events)
+	}
+
+	/* Objects are sent as a query would send them */
+	if !reflect.DeepEqual(events[0].Object, models.WireForm(*png)) {
+		t.Errorf("unexpected object %#v", events[0].Object)
+	}
+
+	/* Somebody else can't poll it */
+	other := &api.Request{User: &models.User{Pid: models.NewGUID()}}
+
+	if _, er := (&SubscribePayload{Subscription: id}).Execute(ctx, other, nil); er == nil {
+		t.Fatalf("polled somebody else's subscription")
+	}
+}
+
+func TestSubscribeRejects(t *testing.T) {
+	user := &models.User{Pid: models.NewGUID()}
+
+	tests := []struct {
+		payload SubscribePayload
+		user    *models.User
+		code    int64
+	}{
+		{SubscribePayload{Model: "Image", Where: map[string]interface{}{"bogus": "x"}}, user, api.ErrCodeInvalidInputField},
+		{SubscribePayload{Model: "Image"}, nil, api.ErrCodeRequiresAuthentication},
+		{SubscribePayload{Model: "User"}, user, api.ErrCodeInvalidInputField},
+	}
+
+	for _, test := range tests {
+		_, er := test.payload.Execute(context.Background(), &api.Request{User: test.user}, nil)
+
+		if !api.HasCode(er, test.code) {
+			t.Errorf("%#v: expected code %x, got %v", test.payload, test.code, er)
+		}
+	}
+}
+
+func TestSubscriptionCap(t *testing.T) {
+	req := &api.Request{User: &models.User{Pid: models.NewGUID()}}
+
+	for i := 0; i < changes.MaxSubscriptionsPerOwner; i += 1 {
+		created, er := (&SubscribePayload{Model: "Image"}).Execute(context.Background(), req, nil)
+		if er != nil {
+			t.Fatal(er)
+		}
+
+		defer changes.DefaultHub.Unsubscribe(created.(*SubscribeResponse).Subscription)
+	}
+
+	_, er := (&SubscribePayload{Model: "Image"}).Execute(context.Background(), req, nil)
+	if !api.IsTooManySubscriptions(er) {
+		t.Fatalf("expected too_many_subscriptions, got %v", er)
+	}
+}
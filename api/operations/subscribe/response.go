@@ -0,0 +1,85 @@
+package subscribe
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"macrobooru/api"
+	"macrobooru/api/changes"
+	"macrobooru/models"
+)
+
+type SubscribeResponse struct {
+	Subscription string          `json:"subscription"`
+	Events       []changes.Event `json:"events"`
+
+	// Missed counts the events dropped because the subscription wasn't polled
+	// often enough to keep up.
+	Missed int64 `json:"missed,omitempty"`
+}
+
+type rawEvent struct {
+	Kind   changes.Kind   `json:"kind" codec:"kind"`
+	Model  string         `json:"model" codec:"model"`
+	Pid    models.GUID    `json:"pid" codec:"pid"`
+	Object api.RawMessage `json:"object" codec:"object"`
+}
+
+type rawSubscribeResponse struct {
+	Subscription string     `json:"subscription" codec:"subscription"`
+	Events       []rawEvent `json:"events" codec:"events"`
+	Missed       int64      `json:"missed" codec:"missed"`
+}
+
+func (res *SubscribeResponse) UnmarshalJSON(bs []byte) error {
+	var raw rawSubscribeResponse
+
+	if er := json.Unmarshal(bs, &raw); er != nil {
+		return er
+	}
+
+	return res.fromRaw(&raw, func(msg api.RawMessage, v interface{}) error {
+		return json.Unmarshal(msg, v)
+	})
+}
+
+/* fromRaw decodes each event's object as the model it's tagged with, using
+ * unmarshal (which must match the encoding the response arrived in). */
+func (res *SubscribeResponse) fromRaw(raw *rawSubscribeResponse, unmarshal func(api.RawMessage, interface{}) error) error {
+	events := []changes.Event{}
+
+	for _, rawEvent := range raw.Events {
+		event, er := rawEvent.decode(unmarshal)
+		if er != nil {
+			return er
+		}
+
+		events = append(events, event)
+	}
+
+	res.Subscription = raw.Subscription
+	res.Events = events
+	res.Missed = raw.Missed
+	return nil
+}
+
+func (raw *rawEvent) decode(unmarshal func(api.RawMessage, interface{}) error) (changes.Event, error) {
+	modelMeta := models.ModelByName(raw.Model)
+	if modelMeta == nil {
+		return changes.Event{}, fmt.Errorf("No such model (%s)", raw.Model)
+	}
+
+	object := reflect.New(modelMeta.Type()).Interface()
+
+	if er := unmarshal(raw.Object, object); er != nil {
+		return changes.Event{}, er
+	}
+
+	return changes.Event{
+		Kind:   raw.Kind,
+		Model:  raw.Model,
+		Pid:    raw.Pid,
+		Object: object,
+	}, nil
+}
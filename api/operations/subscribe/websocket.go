@@ -0,0 +1,242 @@
+package subscribe
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+
+	"macrobooru/api"
+	"macrobooru/api/changes"
+	"macrobooru/models"
+)
+
+/* WebSocketHandler streams change events to clients that can hold a socket open,
+ * instead of making them long-poll the subscribe operation. The token, which is
+ * required, is passed as the `token` query parameter, since browsers can't set
+ * headers on the upgrade.
+ *
+ * Clients send JSON text frames:
+ *
+ *   {"action": "subscribe", "model": "Image", "where": {...}}
+ *   {"action": "unsubscribe", "subscription": "..."}
+ *
+ * and receive a frame for each subscription created, each event, and each error:
+ *
+ *   {"subscription": "..."}
+ *   {"subscription": "...", "kind": "updated", "model": "Image", "pid": "...", "object": {...}}
+ *   {"subscription": "...", "missed": 3}
+ *   {"statusCode": ..., "statusMsg": "..."}
+ *
+ * Subscriptions end with the connection. */
+type WebSocketHandler struct {
+	Handler  *api.Handler
+	Hub      *changes.Hub
+	Upgrader websocket.Upgrader
+}
+
+func NewWebSocketHandler(h *api.Handler) *WebSocketHandler {
+	return &WebSocketHandler{
+		Handler: h,
+		Hub:     changes.DefaultHub,
+	}
+}
+
+type clientFrame struct {
+	Action       string                 `json:"action"`
+	Model        string                 `json:"model"`
+	Where        map[string]interface{} `json:"where"`
+	Subscription string                 `json:"subscription"`
+}
+
+type serverFrame struct {
+	Subscription string       `json:"subscription,omitempty"`
+	Kind         changes.Kind `json:"kind,omitempty"`
+	Model        string       `json:"model,omitempty"`
+	Pid          string       `json:"pid,omitempty"`
+	Object       interface{}  `json:"object,omitempty"`
+	Missed       int64        `json:"missed,omitempty"`
+	StatusCode   int64        `json:"statusCode,omitempty"`
+	StatusMsg    string       `json:"statusMsg,omitempty"`
+}
+
+func (wh *WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, er := wh.Handler.LookupUser(r.Context(), r.URL.Query().Get("token"))
+	if er == nil && user == nil {
+		er = api.ErrorRequiresAuthentication()
+	}
+
+	if er != nil {
+		http.Error(w, er.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	conn, er := wh.Upgrader.Upgrade(w, r, nil)
+	if er != nil {
+		/* Upgrade has already written the HTTP error */
+		return
+	}
+
+	session := &wsSession{
+		conn:    conn,
+		hub:     wh.Hub,
+		owner:   owner(user),
+		locale:  api.NegotiateLocale("", r.Header.Get("Accept-Language")),
+		cancels: make(map[string]func()),
+	}
+
+	session.run()
+}
+
+type wsSession struct {
+	conn   *websocket.Conn
+	hub    *changes.Hub
+	owner  string
+	locale string
+
+	// writeLock serializes writes, which the connection doesn't allow concurrently.
+	writeLock sync.Mutex
+
+	lock    sync.Mutex
+	cancels map[string]func()
+	wg      sync.WaitGroup
+}
+
+func (s *wsSession) run() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	defer func() {
+		cancel()
+		s.wg.Wait()
+
+		s.lock.Lock()
+		for id := range s.cancels {
+			s.hub.Unsubscribe(id)
+		}
+		s.lock.Unlock()
+
+		s.conn.Close()
+	}()
+
+	for {
+		var frame clientFrame
+
+		if er := s.conn.ReadJSON(&frame); er != nil {
+			if _, ok := er.(*websocket.CloseError); !ok {
+				api.Logger(ctx).Info("dropping WebSocket", "error", er)
+			}
+
+			return
+		}
+
+		switch frame.Action {
+		case "subscribe":
+			s.subscribe(ctx, &frame)
+
+		case "unsubscribe":
+			s.unsubscribe(&frame)
+
+		default:
+			s.writeError(api.ErrorInvalidInputField("action"))
+		}
+	}
+}
+
+func (s *wsSession) subscribe(ctx context.Context, frame *clientFrame) {
+	sub, er := Subscribe(s.hub, s.owner, frame.Model, frame.Where)
+	if er != nil {
+		s.writeError(er)
+		return
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+
+	s.lock.Lock()
+	s.cancels[sub.ID] = cancel
+	s.lock.Unlock()
+
+	if !s.write(&serverFrame{Subscription: sub.ID}) {
+		return
+	}
+
+	s.wg.Add(1)
+	go s.stream(subCtx, sub)
+}
+
+func (s *wsSession) unsubscribe(frame *clientFrame) {
+	s.lock.Lock()
+	cancel, ok := s.cancels[frame.Subscription]
+	delete(s.cancels, frame.Subscription)
+	s.lock.Unlock()
+
+	if !ok {
+		s.writeError(api.ErrorInvalidInputField("subscription"))
+		return
+	}
+
+	cancel()
+	s.hub.Unsubscribe(frame.Subscription)
+}
+
+/* stream forwards events for sub until ctx is cancelled or the socket breaks. */
+func (s *wsSession) stream(ctx context.Context, sub *changes.Subscription) {
+	defer s.wg.Done()
+
+	release := sub.Hold()
+	defer release()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-sub.Notify():
+		}
+
+		events, missed := sub.Take()
+
+		if missed > 0 {
+			if !s.write(&serverFrame{Subscription: sub.ID, Missed: missed}) {
+				return
+			}
+		}
+
+		for _, event := range events {
+			frame := &serverFrame{
+				Subscription: sub.ID,
+				Kind:         event.Kind,
+				Model:        event.Model,
+				Pid:          event.Pid.String(),
+				Object:       models.WireForm(event.Object),
+			}
+
+			if !s.write(frame) {
+				return
+			}
+		}
+	}
+}
+
+func (s *wsSession) writeError(er error) {
+	wrapper := api.NewResponseWrapper(nil, er)
+	wrapper.Localize(s.locale)
+
+	s.write(&serverFrame{
+		StatusCode: wrapper.StatusCode,
+		StatusMsg:  wrapper.StatusMessage,
+	})
+}
+
+func (s *wsSession) write(frame *serverFrame) bool {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	if er := s.conn.WriteJSON(frame); er != nil {
+		/* Closing unblocks the reader, which tears down the rest of the session */
+		s.conn.Close()
+		return false
+	}
+
+	return true
+}
@@ -1,10 +1,8 @@
 package verify
 
 import (
-	"database/sql"
-	"encoding/json"
+	"context"
 	"macrobooru/api"
-	"macrobooru/models"
 )
 
 type VerifyPayload struct {
@@ -18,19 +16,19 @@ func (vp *VerifyPayload) Name() string {
 func (vp *VerifyPayload) Parse(req *api.RequestWrapper) (api.Operation, error) {
 	var payload VerifyPayload
 
-	if er := json.Unmarshal(req.RawData, &payload); er != nil {
+	if er := req.UnmarshalData(&payload); er != nil {
 		return nil, er
 	}
 
 	return &payload, nil
 }
 
-func (vp *VerifyPayload) Process(u *models.User, db *sql.DB) (interface{}, error) {
+func (vp *VerifyPayload) Execute(ctx context.Context, req *api.Request, db api.Queryer) (interface{}, error) {
 	return nil, nil
 }
 
 func (vp *VerifyPayload) ParseResponse(req api.ResponseWrapper) (interface{}, error) {
 	res := VerifyResponse{}
-	er := json.Unmarshal(req.Data, &res)
+	er := req.UnmarshalData(&res)
 	return &res, er
 }
@@ -1,46 +1,206 @@
 package api
 
 import (
+	"sort"
 	"sync"
 )
 
+type registeredOperation struct {
+	operation  Operation
+	middleware []Middleware
+}
+
+/* anyVersion is the key operations registered without a version are stored under. */
+const anyVersion = 0
+
 var (
-	operationRegistry     map[string]Operation
+	// operationRegistry is keyed on operation name, then version.
+	operationRegistry     map[string]map[int]registeredOperation
+	translations          map[string]map[int]Translator
+	globalMiddleware      []Middleware
 	operationRegistryLock sync.RWMutex
 )
 
-func RegisterOperation(operationType Operation) {
+/* Translator rewrites the payload of an operation from one version of the API into
+ * the next. It works on the generic decoded form of the payload (maps, slices and
+ * scalars, as a codec decodes into an interface{}). */
+type Translator func(data interface{}) (interface{}, error)
+
+/* RegisterOperation makes an operation available by name, in every version of the
+ * API that doesn't have one of its own. Any middleware passed is wrapped around
+ * every execution of the operation, inside the global middleware installed with Use. */
+func RegisterOperation(operationType Operation, middleware ...Middleware) {
+	registerOperation(anyVersion, operationType, middleware)
+}
+
+/* RegisterVersionedOperation makes an operation available by name in one version of
+ * the API only, taking precedence over one registered with RegisterOperation. */
+func RegisterVersionedOperation(version int, operationType Operation, middleware ...Middleware) {
+	registerOperation(version, operationType, middleware)
+}
+
+func registerOperation(version int, operationType Operation, middleware []Middleware) {
 	operationRegistryLock.Lock()
 	defer operationRegistryLock.Unlock()
 
 	if operationRegistry == nil {
-		operationRegistry = make(map[string]Operation)
+		operationRegistry = make(map[string]map[int]registeredOperation)
+	}
+
+	name := operationType.Name()
+
+	if operationRegistry[name] == nil {
+		operationRegistry[name] = make(map[int]registeredOperation)
 	}
 
-	operationRegistry[operationType.Name()] = operationType
+	operationRegistry[name][version] = registeredOperation{
+		operation:  operationType,
+		middleware: middleware,
+	}
 }
 
+/* RegisterTranslation lets requests for the named operation in version fromVersion
+ * be served by its fromVersion+1 (or later) implementation, by translating their
+ * payloads. Translations chain, so a v2 payload may pass through v3 on its way to
+ * v4. A translation takes precedence over an operation registered for every version
+ * with RegisterOperation, but not over one registered for fromVersion itself. */
+func RegisterTranslation(name string, fromVersion int, translator Translator) {
+	operationRegistryLock.Lock()
+	defer operationRegistryLock.Unlock()
+
+	if translations == nil {
+		translations = make(map[string]map[int]Translator)
+	}
+
+	if translations[name] == nil {
+		translations[name] = make(map[int]Translator)
+	}
+
+	translations[name][fromVersion] = translator
+}
+
+/* Use appends middleware to the stack wrapped around the dispatch of every
+ * operation. Middleware installed first runs outermost. */
+func Use(middleware ...Middleware) {
+	operationRegistryLock.Lock()
+	defer operationRegistryLock.Unlock()
+
+	globalMiddleware = append(globalMiddleware, middleware...)
+}
+
+/* lookupOperation finds what handles the named operation in version, along with the
+ * translations its payload must go through first. The caller must hold
+ * operationRegistryLock. */
+func lookupOperation(name string, version int) (registeredOperation, []Translator, bool) {
+	versions := operationRegistry[name]
+
+	if registered, ok := versions[version]; ok {
+		return registered, nil, true
+	}
+
+	chain := []Translator{}
+
+	for from := version; from < LatestVersion; from += 1 {
+		translator, ok := translations[name][from]
+		if !ok {
+			break
+		}
+
+		chain = append(chain, translator)
+
+		if registered, ok := versions[from+1]; ok {
+			return registered, chain, true
+		}
+	}
+
+	registered, ok := versions[anyVersion]
+	return registered, nil, ok
+}
+
+/* OperationByName returns the operation serving name in DefaultVersion. */
 func OperationByName(name string) Operation {
+	return OperationByNameAndVersion(name, DefaultVersion)
+}
+
+func OperationByNameAndVersion(name string, version int) Operation {
 	operationRegistryLock.RLock()
 	defer operationRegistryLock.RUnlock()
 
-	if operation, ok := operationRegistry[name]; ok {
-		return operation
+	if registered, _, ok := lookupOperation(name, version); ok {
+		return registered.operation
 	}
 
 	return nil
 }
 
-func (req *RequestWrapper) Parse() error {
+/* Operations returns every operation available in DefaultVersion, ordered by name. */
+func Operations() []Operation {
+	return OperationsForVersion(DefaultVersion)
+}
+
+/* OperationsForVersion returns the operations serving each name in version, ordered
+ * by name. Operations reached through a translation are the ones that end up
+ * parsing the (translated) payload. */
+func OperationsForVersion(version int) []Operation {
+	operationRegistryLock.RLock()
+	defer operationRegistryLock.RUnlock()
+
+	names := make([]string, 0, len(operationRegistry))
+	for name := range operationRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]Operation, 0, len(names))
+	for _, name := range names {
+		if registered, _, ok := lookupOperation(name, version); ok {
+			result = append(result, registered.operation)
+		}
+	}
+
+	return result
+}
+
+/* middlewareFor returns the middleware to wrap around op, outermost first. */
+func middlewareFor(op Operation, version int) []Middleware {
 	operationRegistryLock.RLock()
 	defer operationRegistryLock.RUnlock()
 
-	operation, ok := operationRegistry[req.Operation]
+	chain := make([]Middleware, 0, len(globalMiddleware))
+	chain = append(chain, globalMiddleware...)
+
+	if registered, _, ok := lookupOperation(op.Name(), version); ok {
+		chain = append(chain, registered.middleware...)
+	}
+
+	return chain
+}
+
+func (req *RequestWrapper) Parse() error {
+	version := req.Version
+	if version == 0 {
+		version = DefaultVersion
+	}
+
+	if !SupportedVersion(version) {
+		return ErrorInvalidInputFormat("unsupported API version")
+	}
+
+	operationRegistryLock.RLock()
+	registered, chain, ok := lookupOperation(req.Operation, version)
+	operationRegistryLock.RUnlock()
+
 	if !ok {
 		return ErrorInvalidInputFormat("invalid operation")
 	}
 
-	payload, er := operation.Parse(req)
+	if len(chain) > 0 {
+		if er := req.translate(chain); er != nil {
+			return er
+		}
+	}
+
+	payload, er := registered.operation.Parse(req)
 	if er != nil {
 		return er
 	}
@@ -48,3 +208,36 @@ func (req *RequestWrapper) Parse() error {
 	req.Data = payload
 	return nil
 }
+
+/* translate passes RawData through each translator in turn. */
+func (req *RequestWrapper) translate(chain []Translator) error {
+	codec := codecOrDefault(req.Codec)
+
+	var data interface{}
+
+	if len(req.RawData) > 0 {
+		if er := codec.Unmarshal(req.RawData, &data); er != nil {
+			return ErrorInvalidInputFormat(er.Error())
+		}
+	}
+
+	for _, translator := range chain {
+		var er error
+
+		if data, er = translator(data); er != nil {
+			if _, ok := er.(*ApiError); !ok {
+				er = ErrorInvalidInputFormat(er.Error())
+			}
+
+			return er
+		}
+	}
+
+	raw, er := codec.Marshal(data)
+	if er != nil {
+		return ErrorGeneric(er)
+	}
+
+	req.RawData = raw
+	return nil
+}
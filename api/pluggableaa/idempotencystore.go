@@ -0,0 +1,56 @@
+package pluggable
+
+import (
+	"sync"
+	"time"
+
+	"macrobooru/models"
+)
+
+/* IdempotencyRecord is what's remembered about a request sent with an idempotency
+ * key. */
+type IdempotencyRecord struct {
+	// Fingerprint identifies the request the key was first used with.
+	Fingerprint string
+
+	// Response is the response envelope as it was sent, or nil if the request
+	// hasn't finished yet.
+	Response []byte
+
+	// ContentType is the media type of the encoding Response is in.
+	ContentType string
+}
+
+type IdempotencyStore interface {
+	// Reserve claims key for the user, recording the fingerprint of the request.
+	// If the key is already claimed (and hasn't expired) the existing record is
+	// returned instead, and nothing is changed. Claims expire after ttl.
+	Reserve(userGuid models.GUID, key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error)
+
+	// Complete records the response to the request that claimed key, encoded as
+	// contentType.
+	Complete(userGuid models.GUID, key, contentType string, response []byte) error
+
+	// Release forgets key, so that the request can be retried from scratch.
+	Release(userGuid models.GUID, key string) error
+}
+
+var idempotencyStoreLock sync.RWMutex
+var idempotencyStore IdempotencyStore
+
+/* RegisterIdempotencyStore sets the store idempotency keys are tracked in. Since a
+ * key has to be claimed atomically there's only ever one; registering another
+ * replaces it. */
+func RegisterIdempotencyStore(store IdempotencyStore) {
+	idempotencyStoreLock.Lock()
+	defer idempotencyStoreLock.Unlock()
+
+	idempotencyStore = store
+}
+
+func RegisteredIdempotencyStore() IdempotencyStore {
+	idempotencyStoreLock.RLock()
+	defer idempotencyStoreLock.RUnlock()
+
+	return idempotencyStore
+}
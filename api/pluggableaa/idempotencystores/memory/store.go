@@ -0,0 +1,95 @@
+package memory
+
+import (
+	"sync"
+	"time"
+
+	. "macrobooru/api/pluggable"
+	"macrobooru/models"
+)
+
+type recordKey struct {
+	user string
+	key  string
+}
+
+type entry struct {
+	record  IdempotencyRecord
+	expires time.Time
+}
+
+type idempotencyStore struct {
+	IdempotencyStore
+
+	lock    sync.Mutex
+	entries map[recordKey]*entry
+	pruned  time.Time
+}
+
+func init() {
+	RegisterIdempotencyStore(NewIdempotencyStore())
+}
+
+/* NewIdempotencyStore creates a store which keeps everything in process memory, so
+ * is only suitable for a single server. */
+func NewIdempotencyStore() IdempotencyStore {
+	return &idempotencyStore{
+		entries: make(map[recordKey]*entry),
+	}
+}
+
+func (store *idempotencyStore) Reserve(userGuid models.GUID, key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	now := time.Now()
+	store.prune(now)
+
+	k := recordKey{userGuid.String(), key}
+
+	if existing, ok := store.entries[k]; ok && now.Before(existing.expires) {
+		record := existing.record
+		return &record, nil
+	}
+
+	store.entries[k] = &entry{
+		record:  IdempotencyRecord{Fingerprint: fingerprint},
+		expires: now.Add(ttl),
+	}
+
+	return nil, nil
+}
+
+func (store *idempotencyStore) Complete(userGuid models.GUID, key, contentType string, response []byte) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if existing, ok := store.entries[recordKey{userGuid.String(), key}]; ok {
+		existing.record.Response = response
+		existing.record.ContentType = contentType
+	}
+
+	return nil
+}
+
+func (store *idempotencyStore) Release(userGuid models.GUID, key string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	delete(store.entries, recordKey{userGuid.String(), key})
+	return nil
+}
+
+/* prune drops expired entries, at most once a minute */
+func (store *idempotencyStore) prune(now time.Time) {
+	if now.Sub(store.pruned) < time.Minute {
+		return
+	}
+	store.pruned = now
+
+	for k, existing := range store.entries {
+		if now.After(existing.expires) {
+			delete(store.entries, k)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package pluggable
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+
+	"macrobooru/models"
+)
+
+/* SearchHit is an object matching a search, along with how well it matches;
+ * higher scores are better. */
+type SearchHit struct {
+	Pid   models.GUID
+	Score float64
+}
+
+type SearchIndex interface {
+	// Index records text as the contents of the indexed field of the object pid,
+	// replacing whatever was indexed for it before.
+	Index(pid models.GUID, text string) error
+
+	// Remove forgets the object pid.
+	Remove(pid models.GUID) error
+
+	// Search finds at most limit objects matching terms, best first.
+	Search(ctx context.Context, terms []SearchTerm, limit int) ([]SearchHit, error)
+}
+
+/* SearchTerm is one word of a search query. A query matches the objects with every
+ * term that isn't excluded, and none of those that are. */
+type SearchTerm struct {
+	Word string
+
+	// Prefix is set if Word may be the start of a longer word (`cat*`).
+	Prefix bool
+
+	// Exclude is set if Word must not appear (`-cat`).
+	Exclude bool
+}
+
+/* SearchSyntaxError is returned for a search query that can't be understood. */
+type SearchSyntaxError struct {
+	Query  string
+	Reason string
+}
+
+func (er *SearchSyntaxError) Error() string {
+	return fmt.Sprintf("%s: %q", er.Reason, er.Query)
+}
+
+/* ParseSearchQuery splits a query into its terms, which are separated by spaces.
+ * A term is a word, optionally preceded by `-` to exclude it and followed by `*`
+ * to match words starting with it. Words are compared as SearchWords splits text,
+ * so punctuation inside a term splits it into several. */
+func ParseSearchQuery(query string) ([]SearchTerm, error) {
+	terms := []SearchTerm{}
+	included := false
+
+	for _, field := range strings.Fields(query) {
+		exclude := strings.HasPrefix(field, "-")
+		field = strings.TrimPrefix(field, "-")
+
+		prefix := strings.HasSuffix(field, "*")
+		field = strings.TrimSuffix(field, "*")
+
+		words := SearchWords(field)
+		if len(words) == 0 {
+			return nil, &SearchSyntaxError{Query: query, Reason: fmt.Sprintf("%q is not a search term", field)}
+		}
+
+		for idx, word := range words {
+			terms = append(terms, SearchTerm{
+				Word:    word,
+				Prefix:  prefix && idx == len(words)-1,
+				Exclude: exclude,
+			})
+		}
+
+		included = included || !exclude
+	}
+
+	if !included {
+		return nil, &SearchSyntaxError{Query: query, Reason: "nothing to search for"}
+	}
+
+	return terms, nil
+}
+
+/* SearchWords splits text into the words an index is searched by: runs of letters
+ * and digits, in lower case. */
+func SearchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+type searchIndexKey struct {
+	model string
+	field string
+}
+
+var searchIndexLock sync.RWMutex
+var searchIndexes = make(map[searchIndexKey]SearchIndex)
+
+/* RegisterSearchIndex sets the index searched by `search` clauses on the field (as
+ * named in JSON) of the model with the given name, and kept up to date as objects
+ * of the model are modified. Registering another for the same field replaces it;
+ * registering nil removes it. */
+func RegisterSearchIndex(model, field string, index SearchIndex) {
+	searchIndexLock.Lock()
+	defer searchIndexLock.Unlock()
+
+	if index == nil {
+		delete(searchIndexes, searchIndexKey{model, field})
+		return
+	}
+
+	searchIndexes[searchIndexKey{model, field}] = index
+}
+
+func RegisteredSearchIndex(model, field string) SearchIndex {
+	searchIndexLock.RLock()
+	defer searchIndexLock.RUnlock()
+
+	return searchIndexes[searchIndexKey{model, field}]
+}
+
+/* RegisteredSearchIndexes returns the index of each field of the model with the
+ * given name that has one. */
+func RegisteredSearchIndexes(model string) map[string]SearchIndex {
+	searchIndexLock.RLock()
+	defer searchIndexLock.RUnlock()
+
+	indexes := make(map[string]SearchIndex)
+
+	for key, index := range searchIndexes {
+		if key.model == model {
+			indexes[key.field] = index
+		}
+	}
+
+	return indexes
+}
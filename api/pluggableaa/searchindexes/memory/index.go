@@ -0,0 +1,177 @@
+package memory
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	. "macrobooru/api/pluggable"
+	"macrobooru/models"
+)
+
+type searchIndex struct {
+	SearchIndex
+
+	lock sync.RWMutex
+
+	// postings maps each word onto how many times it appears in each object (by
+	// the string form of its pid).
+	postings map[string]map[string]int
+
+	// documents are what each object was indexed with, so it can be taken out
+	// again.
+	documents map[string]document
+}
+
+type document struct {
+	pid   models.GUID
+	words []string
+}
+
+/* NewSearchIndex creates an inverted index kept in process memory, so it starts out
+ * empty; fill it with modify.RebuildSearchIndex once it's registered. It suits a
+ * single server with a modest amount of text. */
+func NewSearchIndex() SearchIndex {
+	return &searchIndex{
+		postings:  make(map[string]map[string]int),
+		documents: make(map[string]document),
+	}
+}
+
+func (index *searchIndex) Index(pid models.GUID, text string) error {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	key := pid.String()
+	index.remove(key)
+
+	words := SearchWords(text)
+	if len(words) == 0 {
+		return nil
+	}
+
+	for _, word := range words {
+		counts, ok := index.postings[word]
+		if !ok {
+			counts = make(map[string]int)
+			index.postings[word] = counts
+		}
+
+		counts[key] += 1
+	}
+
+	index.documents[key] = document{pid, words}
+	return nil
+}
+
+func (index *searchIndex) Remove(pid models.GUID) error {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	index.remove(pid.String())
+	return nil
+}
+
+func (index *searchIndex) remove(key string) {
+	for _, word := range index.documents[key].words {
+		delete(index.postings[word], key)
+
+		if len(index.postings[word]) == 0 {
+			delete(index.postings, word)
+		}
+	}
+
+	delete(index.documents, key)
+}
+
+/* Search scores each object by TF-IDF: the more often a term appears in it, and the
+ * fewer other objects it appears in, the better. */
+func (index *searchIndex) Search(ctx context.Context, terms []SearchTerm, limit int) ([]SearchHit, error) {
+	index.lock.RLock()
+	defer index.lock.RUnlock()
+
+	var scores map[string]float64
+	excluded := make(map[string]bool)
+
+	for _, term := range terms {
+		matches := index.matching(term)
+
+		if term.Exclude {
+			for key := range matches {
+				excluded[key] = true
+			}
+
+			continue
+		}
+
+		if scores == nil {
+			scores = matches
+			continue
+		}
+
+		/* Every term has to match */
+		for key, score := range scores {
+			if extra, ok := matches[key]; ok {
+				scores[key] = score + extra
+			} else {
+				delete(scores, key)
+			}
+		}
+	}
+
+	hits := []SearchHit{}
+
+	for key, score := range scores {
+		if !excluded[key] {
+			hits = append(hits, SearchHit{Pid: index.documents[key].pid, Score: score})
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+
+		return hits[i].Pid.String() < hits[j].Pid.String()
+	})
+
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	return hits, ctx.Err()
+}
+
+/* matching scores the objects containing term. */
+func (index *searchIndex) matching(term SearchTerm) map[string]float64 {
+	scores := make(map[string]float64)
+	words := []string{term.Word}
+
+	if term.Prefix {
+		words = words[:0]
+
+		for word := range index.postings {
+			if strings.HasPrefix(word, term.Word) {
+				words = append(words, word)
+			}
+		}
+	}
+
+	for _, word := range words {
+		counts, ok := index.postings[word]
+		if !ok {
+			continue
+		}
+
+		idf := math.Log(1 + float64(len(index.documents))/float64(len(counts)))
+
+		for key, count := range counts {
+			tf := float64(count) / float64(len(index.documents[key].words))
+			scores[key] += tf * idf
+		}
+	}
+
+	return scores
+}
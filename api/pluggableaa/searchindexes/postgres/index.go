@@ -0,0 +1,109 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"macrobooru/api"
+	. "macrobooru/api/pluggable"
+	"macrobooru/models"
+)
+
+type searchIndex struct {
+	SearchIndex
+
+	db     *sql.DB
+	config string
+
+	indexQ  string
+	removeQ string
+	searchQ string
+}
+
+/* NewSearchIndex creates an index kept in a PostgreSQL table of tsvectors, which
+ * must already exist:
+ *
+ *     CREATE TABLE <table> (pid TEXT PRIMARY KEY, document TSVECTOR NOT NULL);
+ *     CREATE INDEX ON <table> USING GIN (document);
+ *
+ * config is the text search configuration (e.g. "english") that decides how words
+ * are stemmed and which are ignored. */
+func NewSearchIndex(db *sql.DB, table, config string) SearchIndex {
+	quoted := api.PostgresDialect.QuoteIdentifier(table)
+
+	return &searchIndex{
+		db:     db,
+		config: config,
+
+		indexQ: fmt.Sprintf(`INSERT INTO %s (pid, document) VALUES ($1, to_tsvector($2::regconfig, $3))
+			ON CONFLICT (pid) DO UPDATE SET document = EXCLUDED.document`, quoted),
+
+		removeQ: fmt.Sprintf(`DELETE FROM %s WHERE pid = $1`, quoted),
+
+		searchQ: fmt.Sprintf(`SELECT pid, ts_rank(document, query) FROM %s, to_tsquery($1::regconfig, $2) AS query
+			WHERE document @@ query ORDER BY 2 DESC, pid LIMIT $3`, quoted),
+	}
+}
+
+func (index *searchIndex) Index(pid models.GUID, text string) error {
+	_, er := index.db.Exec(index.indexQ, pid.String(), index.config, text)
+	return er
+}
+
+func (index *searchIndex) Remove(pid models.GUID) error {
+	_, er := index.db.Exec(index.removeQ, pid.String())
+	return er
+}
+
+func (index *searchIndex) Search(ctx context.Context, terms []SearchTerm, limit int) ([]SearchHit, error) {
+	rows, er := index.db.QueryContext(ctx, index.searchQ, index.config, tsquery(terms), limit)
+	if er != nil {
+		if strings.Contains(er.Error(), "syntax error in tsquery") {
+			return nil, &SearchSyntaxError{Query: tsquery(terms), Reason: er.Error()}
+		}
+
+		return nil, er
+	}
+	defer rows.Close()
+
+	hits := []SearchHit{}
+
+	for rows.Next() {
+		var pid string
+		var hit SearchHit
+
+		if er := rows.Scan(&pid, &hit.Score); er != nil {
+			return nil, er
+		}
+
+		if hit.Pid, er = models.GUIDFromString(pid); er != nil {
+			return nil, er
+		}
+
+		hits = append(hits, hit)
+	}
+
+	return hits, rows.Err()
+}
+
+/* tsquery writes terms in the syntax of to_tsquery. The words are only letters and
+ * digits, so need no quoting. */
+func tsquery(terms []SearchTerm) string {
+	bits := make([]string, len(terms))
+
+	for idx, term := range terms {
+		bits[idx] = term.Word
+
+		if term.Prefix {
+			bits[idx] += ":*"
+		}
+
+		if term.Exclude {
+			bits[idx] = "!" + bits[idx]
+		}
+	}
+
+	return strings.Join(bits, " & ")
+}
@@ -0,0 +1,35 @@
+package pluggable
+
+import (
+	"io"
+	"sync"
+)
+
+type StaticStore interface {
+	// Path is the path a file whose contents hash to sha1Hash is stored at.
+	Path(sha1Hash, mime string) string
+
+	// Store saves file at path, as returned by Path. It's only called once the
+	// static referring to it has been committed, so nothing is stored for uploads
+	// that fail.
+	Store(path string, file io.Reader) error
+}
+
+var staticStoreLock sync.RWMutex
+var staticStore StaticStore
+
+/* RegisterStaticStore sets the store files uploaded for statics are kept in.
+ * Registering another replaces it; until one is registered, uploads are refused. */
+func RegisterStaticStore(store StaticStore) {
+	staticStoreLock.Lock()
+	defer staticStoreLock.Unlock()
+
+	staticStore = store
+}
+
+func RegisteredStaticStore() StaticStore {
+	staticStoreLock.RLock()
+	defer staticStoreLock.RUnlock()
+
+	return staticStore
+}
@@ -0,0 +1,48 @@
+package directory
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "macrobooru/api/pluggable"
+)
+
+type staticStore struct {
+	StaticStore
+
+	dir string
+}
+
+/* NewStaticStore creates a store keeping each file in dir, named by its hash, so
+ * that uploading the same file twice stores it once. The paths it returns are
+ * relative to dir. */
+func NewStaticStore(dir string) StaticStore {
+	return &staticStore{dir: dir}
+}
+
+func (store *staticStore) Path(sha1Hash, mime string) string {
+	return strings.ToLower(sha1Hash)
+}
+
+func (store *staticStore) Store(path string, file io.Reader) error {
+	/* Written aside and renamed into place, so a half-written file is never seen */
+	tmpFile, er := ioutil.TempFile(store.dir, ".upload-")
+	if er != nil {
+		return er
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, er := io.Copy(tmpFile, file); er != nil {
+		tmpFile.Close()
+		return er
+	}
+
+	if er := tmpFile.Close(); er != nil {
+		return er
+	}
+
+	return os.Rename(tmpFile.Name(), filepath.Join(store.dir, path))
+}
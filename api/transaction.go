@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+)
+
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+/* transaction is the Queryer handed to the fn of WithTransaction, which remembers
+ * what to do once it commits. */
+type transaction struct {
+	*sql.Tx
+
+	afterCommit []func()
+}
+
+/* WithTransaction runs fn inside a transaction, committing if it returns nil and
+ * rolling back otherwise. If db is already a transaction (or otherwise can't begin
+ * one) fn simply runs against db, leaving the outcome to whoever owns it. */
+func WithTransaction(ctx context.Context, db Queryer, fn func(Queryer) error) error {
+	beginner, ok := db.(txBeginner)
+	if !ok {
+		return fn(db)
+	}
+
+	sqlTx, er := beginner.BeginTx(ctx, nil)
+	if er != nil {
+		return ErrorGeneric(er)
+	}
+
+	tx := &transaction{Tx: sqlTx}
+
+	if er := fn(tx); er != nil {
+		tx.Rollback()
+		return er
+	}
+
+	if er := tx.Commit(); er != nil {
+		return ErrorGeneric(er)
+	}
+
+	for _, hook := range tx.afterCommit {
+		hook()
+	}
+
+	return nil
+}
+
+/* AfterCommit arranges for fn to run once the changes made through db are visible
+ * to everyone: after the enclosing WithTransaction commits, or straight away if db
+ * isn't a transaction. fn is never run if the transaction rolls back. */
+func AfterCommit(db Queryer, fn func()) {
+	if tx, ok := db.(*transaction); ok {
+		tx.afterCommit = append(tx.afterCommit, fn)
+		return
+	}
+
+	fn()
+}
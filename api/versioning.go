@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	Version2 = 2
+	Version3 = 3
+
+	// DefaultVersion is assumed for requests which don't ask for a version, since
+	// every client that predates versioning speaks v2.
+	DefaultVersion = Version2
+	LatestVersion  = Version3
+)
+
+/* VersionHeader selects the API version, overriding the version in the path. Every
+ * response carries it too, naming the version the request was served in. */
+const VersionHeader = "X-API-Version"
+
+func SupportedVersion(version int) bool {
+	return version >= Version2 && version <= LatestVersion
+}
+
+/* RequestVersion works out the version of the API an HTTP request is for: the one
+ * named by VersionHeader, else the first path segment of the form "v<N>" (so both
+ * /v3/api and /api/v3 work), else DefaultVersion. */
+func RequestVersion(r *http.Request) (int, error) {
+	if header := r.Header.Get(VersionHeader); header != "" {
+		return parseVersion(header)
+	}
+
+	if r.URL == nil {
+		return DefaultVersion, nil
+	}
+
+	for _, segment := range strings.Split(r.URL.Path, "/") {
+		if len(segment) > 1 && (segment[0] == 'v' || segment[0] == 'V') {
+			if _, er := strconv.Atoi(segment[1:]); er == nil {
+				return parseVersion(segment)
+			}
+		}
+	}
+
+	return DefaultVersion, nil
+}
+
+func parseVersion(str string) (int, error) {
+	str = strings.TrimSpace(str)
+	str = strings.TrimPrefix(strings.TrimPrefix(str, "v"), "V")
+
+	version, er := strconv.Atoi(str)
+	if er != nil || !SupportedVersion(version) {
+		return 0, ErrorInvalidInputFormat("unsupported API version")
+	}
+
+	return version, nil
+}
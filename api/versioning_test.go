@@ -0,0 +1,146 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+/* versionedPayload answers with the version that parsed it, and its value */
+type versionedPayload struct {
+	Value   string `json:"value"`
+	version int
+}
+
+func (*versionedPayload) Name() string {
+	return "test_versioned"
+}
+
+func (payload *versionedPayload) Parse(req *RequestWrapper) (Operation, error) {
+	parsed := versionedPayload{version: payload.version}
+
+	if er := req.UnmarshalData(&parsed); er != nil {
+		return nil, ErrorInvalidInputFormat(er.Error())
+	}
+
+	return &parsed, nil
+}
+
+func (payload *versionedPayload) Execute(ctx context.Context, req *Request, db Queryer) (interface{}, error) {
+	return fmt.Sprintf("v%d:%s", payload.version, payload.Value), nil
+}
+
+func (*versionedPayload) ParseResponse(wrapper ResponseWrapper) (interface{}, error) {
+	var res string
+	er := wrapper.UnmarshalData(&res)
+	return res, er
+}
+
+/* translatedPayload only exists in v3, where `name` was renamed to `value` */
+type translatedPayload struct {
+	versionedPayload
+}
+
+func (*translatedPayload) Name() string {
+	return "test_translated"
+}
+
+func init() {
+	RegisterVersionedOperation(Version2, &versionedPayload{version: 2})
+	RegisterVersionedOperation(Version3, &versionedPayload{version: 3})
+
+	RegisterVersionedOperation(Version3, &translatedPayload{versionedPayload{version: 3}})
+	RegisterTranslation("test_translated", Version2, func(data interface{}) (interface{}, error) {
+		fields, ok := data.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected an object")
+		}
+
+		fields["value"] = fields["name"]
+		delete(fields, "name")
+		return fields, nil
+	})
+}
+
+func serveVersionedRequest(t *testing.T, path string, header string, body string) (ResponseWrapper, string) {
+	req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if header != "" {
+		req.Header.Set(VersionHeader, header)
+	}
+
+	recorder := httptest.NewRecorder()
+	NewHandler(nil).ServeHTTP(recorder, req)
+
+	var wrapper ResponseWrapper
+	if er := json.Unmarshal(recorder.Body.Bytes(), &wrapper); er != nil {
+		t.Fatal(er)
+	}
+
+	return wrapper, recorder.Header().Get(VersionHeader)
+}
+
+func TestVersionRouting(t *testing.T) {
+	body := `{ "operation" : "test_versioned", "data" : { "value" : "x" } }`
+
+	cases := []struct {
+		path, header string
+		expected     string
+	}{
+		{"/v2/api", "", "v2:x"},
+		{"/api/v2", "", "v2:x"},
+		{"/v3/api", "", "v3:x"},
+		{"/api/v3", "", "v3:x"},
+		{"/api", "", "v2:x"},
+		{"/v2/api", "3", "v3:x"},
+		{"/api", "v3", "v3:x"},
+	}
+
+	for _, c := range cases {
+		wrapper, version := serveVersionedRequest(t, c.path, c.header, body)
+
+		var res string
+		if er := wrapper.UnmarshalData(&res); er != nil || res != c.expected {
+			t.Errorf("%s (%q): expected %q, got %q (%v)", c.path, c.header, c.expected, res, wrapper.Err())
+		}
+
+		if version != c.expected[1:2] {
+			t.Errorf("%s (%q): response says version %q", c.path, c.header, version)
+		}
+	}
+
+	/* Operations registered for every version are in every version */
+	wrapper, _ := serveVersionedRequest(t, "/v3/api", "", `{ "operation" : "test_echo", "data" : { "value" : "hi" } }`)
+	if wrapper.StatusCode != ErrCodeNoError {
+		t.Errorf("unversioned operation not available in v3: %v", wrapper.Err())
+	}
+
+	for _, path := range []string{"/v1/api", "/v9/api"} {
+		if wrapper, _ := serveVersionedRequest(t, path, "", body); wrapper.StatusCode != ErrCodeInvalidInputFormat {
+			t.Errorf("%s: expected the version to be rejected, got %v", path, wrapper.Err())
+		}
+	}
+}
+
+func TestVersionTranslation(t *testing.T) {
+	wrapper, _ := serveVersionedRequest(t, "/v2/api", "", `{ "operation" : "test_translated", "data" : { "name" : "old" } }`)
+
+	var res string
+	if er := wrapper.UnmarshalData(&res); er != nil || res != "v3:old" {
+		t.Errorf("expected the v2 payload to be translated, got %q (%v)", res, wrapper.Err())
+	}
+
+	wrapper, _ = serveVersionedRequest(t, "/v3/api", "", `{ "operation" : "test_translated", "data" : { "value" : "new" } }`)
+	if er := wrapper.UnmarshalData(&res); er != nil || res != "v3:new" {
+		t.Errorf("expected the v3 payload to be parsed as is, got %q (%v)", res, wrapper.Err())
+	}
+
+	wrapper, _ = serveVersionedRequest(t, "/v2/api", "", `{ "operation" : "test_translated", "data" : [] }`)
+	if wrapper.StatusCode != ErrCodeInvalidInputFormat {
+		t.Errorf("expected a translation failure to be reported, got %v", wrapper.Err())
+	}
+}
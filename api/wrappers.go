@@ -1,15 +1,17 @@
 package api
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/textproto"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 
 	"mime"
@@ -20,8 +22,30 @@ type RequestWrapper struct {
 	Operation string `json:"operation"`
 	AuthToken string `json:"token,omitempty"`
 
-	RawData json.RawMessage `json:"data"`
-	Data    Operation       `json:"-"`
+	// Locale requests statusMsg in a specific language, overriding Accept-Language.
+	Locale string `json:"locale,omitempty"`
+
+	// IdempotencyKey, if set, makes retries of this request safe: a request
+	// repeating a key gets the original response rather than running again.
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+
+	RawData RawMessage `json:"data"`
+	Data    Operation  `json:"-"`
+
+	// Codec is the encoding RawData is in, and the one Data is sent with. nil means JSON.
+	Codec Codec `json:"-"`
+
+	// ContentEncoding, if "gzip" or "deflate", compresses the data part when it's
+	// large enough to be worth it. Only used when sending.
+	ContentEncoding string `json:"-"`
+
+	// RequestID is assigned when the request is unwrapped, taken from the
+	// X-Request-ID header if the client sent a usable one.
+	RequestID string `json:"-"`
+
+	// Version is the version of the API the payload is written for, which decides
+	// how it's parsed. Zero means DefaultVersion. It's sent in VersionHeader.
+	Version int `json:"-"`
 
 	/* XXX: This has to be reconstructed from a map[string][]multipart.File, which by
 	 * default is constructed by peeking at the Content-Disposition header. We need to
@@ -33,9 +57,85 @@ type RequestWrapper struct {
 }
 
 type ResponseWrapper struct {
-	StatusCode    int64           `json:"statusCode"`
-	StatusMessage string          `json:"statusMsg"`
-	Data          json.RawMessage `json:"data,omitempty"`
+	StatusCode    int64         `json:"statusCode"`
+	StatusKey     string        `json:"statusKey,omitempty"`
+	StatusMessage string        `json:"statusMsg"`
+	StatusArgs    []interface{} `json:"statusArgs,omitempty"`
+	Data          RawMessage    `json:"data,omitempty"`
+
+	// RequestID identifies the request in the server's logs; quote it when
+	// reporting problems.
+	RequestID string `json:"requestId,omitempty"`
+
+	// Codec is the encoding Data is in. nil means JSON.
+	Codec Codec `json:"-"`
+}
+
+/* NewResponseWrapper packages the result of an operation into the status envelope
+ * described by the spec, with data encoded as JSON. Errors which are not already
+ * an *ApiError are reported as ErrCodeGeneric. */
+func NewResponseWrapper(data interface{}, er error) *ResponseWrapper {
+	return NewEncodedResponseWrapper(JSONCodec, data, er)
+}
+
+/* NewEncodedResponseWrapper is NewResponseWrapper with data encoded by codec, which
+ * must be the codec the envelope itself is sent with. */
+func NewEncodedResponseWrapper(codec Codec, data interface{}, er error) *ResponseWrapper {
+	codec = codecOrDefault(codec)
+
+	if er != nil {
+		apiEr, ok := er.(*ApiError)
+		if !ok {
+			apiEr = ErrorGeneric(er).(*ApiError)
+		}
+
+		return &ResponseWrapper{
+			StatusCode:    apiEr.Code(),
+			StatusKey:     apiEr.Key(),
+			StatusMessage: apiEr.Original(),
+			StatusArgs:    apiEr.Args(),
+			Codec:         codec,
+		}
+	}
+
+	wrapper := &ResponseWrapper{
+		StatusCode:    ErrCodeNoError,
+		StatusKey:     ErrorKey(ErrCodeNoError),
+		StatusMessage: "ok",
+		Codec:         codec,
+	}
+
+	if data != nil {
+		raw, er := codec.Marshal(data)
+		if er != nil {
+			return NewEncodedResponseWrapper(codec, nil, er)
+		}
+
+		wrapper.Data = raw
+	}
+
+	return wrapper
+}
+
+/* Localize replaces statusMsg with the catalog message for the envelope's status
+ * in the given locale. */
+func (wrapper *ResponseWrapper) Localize(locale string) {
+	wrapper.StatusMessage = Message(locale, wrapper.StatusCode, wrapper.StatusArgs)
+}
+
+/* Err rebuilds the *ApiError described by the envelope, or returns nil if the
+ * envelope reports success. */
+func (wrapper *ResponseWrapper) Err() error {
+	if wrapper.StatusCode == ErrCodeNoError {
+		return nil
+	}
+
+	var original error
+	if wrapper.StatusMessage != "" {
+		original = errors.New(wrapper.StatusMessage)
+	}
+
+	return NewApiError(wrapper.StatusCode, wrapper.StatusArgs, original)
 }
 
 func (wrapper *RequestWrapper) Close() {
@@ -59,32 +159,68 @@ func (wrapper *RequestWrapper) MarshalJSON() ([]byte, error) {
 		obj["token"] = wrapper.AuthToken
 	}
 
+	if wrapper.Locale != "" {
+		obj["locale"] = wrapper.Locale
+	}
+
+	if wrapper.IdempotencyKey != "" {
+		obj["idempotencyKey"] = wrapper.IdempotencyKey
+	}
+
 	return json.Marshal(obj)
 }
 
-func UnwrapHttpRequest(r *http.Request) (req *RequestWrapper, er error) {
+func UnwrapHttpRequest(r *http.Request) (*RequestWrapper, error) {
+	return UnwrapHttpRequestWithLimits(r, DefaultAttachmentLimits)
+}
+
+/* UnwrapHttpRequestWithLimits is UnwrapHttpRequest with the size of multipart
+ * attachments bounded by limits. */
+func UnwrapHttpRequestWithLimits(r *http.Request, limits AttachmentLimits) (req *RequestWrapper, er error) {
 	contentType := r.Header.Get("Content-Type")
 	defer r.Body.Close()
 
+	body, er := DecompressReader(r.Header.Get("Content-Encoding"), r.Body)
+	if er != nil {
+		return nil, er
+	}
+	defer body.Close()
+
 	if strings.Index(contentType, "multipart/") == 0 {
-		req, er = unwrapMultipartHttpRequest(r)
+		req, er = unwrapMultipartHttpRequest(body, contentType, limits)
 
 	} else {
-		req, er = unwrapJsonHttpRequest(r)
+		req, er = unwrapEncodedHttpRequest(body, requestCodec(contentType), limits)
 	}
 
 	if er != nil {
 		return nil, er
 	}
 
+	if req.Version, er = RequestVersion(r); er != nil {
+		req.Close()
+		return nil, er
+	}
+
 	if er := req.Parse(); er != nil {
+		req.Close()
 		return nil, er
 	}
 
+	req.RequestID = requestIDFor(r)
+
 	return req, nil
 }
 
 func UnwrapHttpResponse(r io.Reader) (*ResponseWrapper, error) {
+	return UnwrapHttpResponseWithCodec(r, JSONCodec)
+}
+
+/* UnwrapHttpResponseWithCodec reads a response envelope encoded with codec, which
+ * should be picked from the response's Content-Type. */
+func UnwrapHttpResponseWithCodec(r io.Reader, codec Codec) (*ResponseWrapper, error) {
+	codec = codecOrDefault(codec)
+
 	responseBytes, er := ioutil.ReadAll(r)
 	if er != nil {
 		return nil, er
@@ -92,97 +228,274 @@ func UnwrapHttpResponse(r io.Reader) (*ResponseWrapper, error) {
 
 	wrapper := ResponseWrapper{}
 
-	if er := json.Unmarshal(responseBytes, &wrapper); er != nil {
-		log.Printf("Response contents:\n%s\n", string(responseBytes))
+	if er := codec.Unmarshal(responseBytes, &wrapper); er != nil {
+		Logger(context.Background()).Debug("unable to decode response envelope", "error", er, "bytes", len(responseBytes))
 		return nil, er
 	}
 
+	wrapper.Codec = codec
+
 	return &wrapper, nil
 }
 
+/* ProgressFunc is invoked as the body of a wrapped request is sent. total is -1 if
+ * the length of the body could not be determined before sending. */
+type ProgressFunc func(written, total int64)
+
 func WrapHttpRequest(endpoint string, req *RequestWrapper) (*http.Request, error) {
-	/* XXX: THIS WORKS COMPLETELY IN-MEMORY BECAUSE mime/multipart.Writer IS STUPID */
-	buf := &bytes.Buffer{}
-	writer := multipart.NewWriter(buf)
+	return WrapHttpRequestWithProgress(endpoint, req, nil)
+}
 
-	/* Generate the Attachments body */
-	for name, attachment := range req.Attachments {
-		if _, er := attachment.Seek(0, 0); er != nil {
+/* WrapHttpRequestWithProgress packages req as a multipart/mixed request. The body is
+ * streamed through a pipe as it is sent rather than assembled in memory, so the
+ * attachments are read while the request is being transmitted. The returned request
+ * must be sent (or its body closed), otherwise the encoding goroutine never exits. */
+func WrapHttpRequestWithProgress(endpoint string, req *RequestWrapper, progress ProgressFunc) (*http.Request, error) {
+	/* Serialize data payload up front; it's small, and marshalling errors
+	 * should surface here rather than halfway through the upload. */
+	codec := codecOrDefault(req.Codec)
+
+	data, er := codec.Marshal(req)
+	if er != nil {
+		return nil, er
+	}
+
+	dataEncoding := ""
+	if req.ContentEncoding != "" && len(data) >= minCompressSize {
+		if data, er = Compress(req.ContentEncoding, data); er != nil {
 			return nil, er
 		}
 
-		mime := req.MimeTypes[name]
+		dataEncoding = req.ContentEncoding
+	}
 
-		header := textproto.MIMEHeader{}
-		header.Set("Content-Type", mime)
-		header.Set("Content-ID", name)
+	names := make([]string, 0, len(req.Attachments))
+	for name := range req.Attachments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-		partWriter, er := writer.CreatePart(header)
-		if er != nil {
-			return nil, er
-		}
+	boundary := multipart.NewWriter(ioutil.Discard).Boundary()
+	length := multipartLength(req, names, data, dataHeader(codec, dataEncoding), boundary)
 
-		if _, er := io.Copy(partWriter, attachment); er != nil {
-			return nil, er
+	pipeReader, pipeWriter := io.Pipe()
+
+	var bodyWriter io.Writer = pipeWriter
+	if progress != nil {
+		bodyWriter = &progressWriter{
+			writer:   pipeWriter,
+			total:    length,
+			progress: progress,
 		}
 	}
 
-	/* Serialize data payload to JSON and jam in into the request data */
-	data, er := json.Marshal(req)
+	go func() {
+		pipeWriter.CloseWithError(writeMultipartBody(bodyWriter, req, names, data, dataHeader(codec, dataEncoding), boundary))
+	}()
+
+	/* Package up a http request */
+	httpReq, er := http.NewRequest("POST", endpoint, pipeReader)
 	if er != nil {
+		pipeReader.Close()
 		return nil, er
 	}
 
+	contentType := fmt.Sprintf("multipart/mixed;boundary=\"%s\"", boundary)
+	httpReq.Header.Set("Content-Type", contentType)
+	httpReq.Header.Set("Accept", codec.ContentType())
+
+	if req.Version != 0 {
+		httpReq.Header.Set(VersionHeader, strconv.Itoa(req.Version))
+	}
+
+	if req.ContentEncoding != "" {
+		httpReq.Header.Set("Accept-Encoding", "gzip, deflate")
+	}
+
+	/* A length of -1 makes net/http fall back to chunked transfer encoding */
+	httpReq.ContentLength = length
+
+	return httpReq, nil
+}
+
+func attachmentHeader(name, mime string) textproto.MIMEHeader {
 	header := textproto.MIMEHeader{}
-	header.Set("Content-Type", "application/json")
+	header.Set("Content-Type", mime)
+	header.Set("Content-ID", name)
+	return header
+}
+
+func dataHeader(codec Codec, encoding string) textproto.MIMEHeader {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", codec.ContentType())
 	header.Set("Content-ID", "data")
 
-	partWriter, er := writer.CreatePart(header)
+	if encoding != "" {
+		header.Set("Content-Encoding", encoding)
+	}
+
+	return header
+}
+
+/* attachmentSize figures out the length of an attachment by seeking to its end, and
+ * leaves it rewound. */
+func attachmentSize(attachment multipart.File) (int64, error) {
+	size, er := attachment.Seek(0, os.SEEK_END)
 	if er != nil {
-		return nil, er
+		return 0, er
 	}
 
-	if _, er := io.Copy(partWriter, bytes.NewBuffer(data)); er != nil {
-		return nil, er
+	if _, er := attachment.Seek(0, os.SEEK_SET); er != nil {
+		return 0, er
+	}
+
+	return size, nil
+}
+
+/* multipartLength computes the exact length of the body writeMultipartBody will
+ * produce by writing the part framing to a counter and adding in the size of each
+ * attachment. Returns -1 if any attachment can't report its size. */
+func multipartLength(req *RequestWrapper, names []string, data []byte, header textproto.MIMEHeader, boundary string) int64 {
+	counter := &countingWriter{}
+	writer := multipart.NewWriter(counter)
+
+	if er := writer.SetBoundary(boundary); er != nil {
+		return -1
+	}
+
+	for _, name := range names {
+		size, er := attachmentSize(req.Attachments[name])
+		if er != nil {
+			return -1
+		}
+
+		if _, er := writer.CreatePart(attachmentHeader(name, req.MimeTypes[name])); er != nil {
+			return -1
+		}
+
+		counter.written += size
 	}
 
+	if _, er := writer.CreatePart(header); er != nil {
+		return -1
+	}
+	counter.written += int64(len(data))
+
 	if er := writer.Close(); er != nil {
-		return nil, er
+		return -1
 	}
 
-	/* Package up a http request */
-	httpReq, er := http.NewRequest("POST", endpoint, buf)
+	return counter.written
+}
+
+func writeMultipartBody(w io.Writer, req *RequestWrapper, names []string, data []byte, header textproto.MIMEHeader, boundary string) error {
+	writer := multipart.NewWriter(w)
+
+	if er := writer.SetBoundary(boundary); er != nil {
+		return er
+	}
+
+	/* Generate the Attachments body */
+	for _, name := range names {
+		attachment := req.Attachments[name]
+
+		if _, er := attachment.Seek(0, os.SEEK_SET); er != nil {
+			return er
+		}
+
+		partWriter, er := writer.CreatePart(attachmentHeader(name, req.MimeTypes[name]))
+		if er != nil {
+			return er
+		}
+
+		if _, er := io.Copy(partWriter, attachment); er != nil {
+			return er
+		}
+	}
+
+	partWriter, er := writer.CreatePart(header)
 	if er != nil {
-		return nil, er
+		return er
 	}
 
-	contentType := fmt.Sprintf("multipart/mixed;boundary=\"%s\"", writer.Boundary())
-	httpReq.Header.Set("Content-Type", contentType)
-	httpReq.ContentLength = int64(buf.Len())
+	if _, er := partWriter.Write(data); er != nil {
+		return er
+	}
 
-	return httpReq, nil
+	return writer.Close()
+}
+
+type countingWriter struct {
+	written int64
+}
+
+func (cw *countingWriter) Write(bs []byte) (int, error) {
+	cw.written += int64(len(bs))
+	return len(bs), nil
+}
+
+type progressWriter struct {
+	writer   io.Writer
+	written  int64
+	total    int64
+	progress ProgressFunc
+}
+
+func (pw *progressWriter) Write(bs []byte) (int, error) {
+	n, er := pw.writer.Write(bs)
+	pw.written += int64(n)
+	pw.progress(pw.written, pw.total)
+	return n, er
+}
+
+/* requestCodec picks the codec a request body (or its data part) was sent in. Bodies
+ * without a recognised Content-Type have always been read as JSON. */
+func requestCodec(contentType string) Codec {
+	if codec := CodecByContentType(contentType); codec != nil {
+		return codec
+	}
+
+	return JSONCodec
 }
 
-func unwrapJsonHttpRequest(r *http.Request) (*RequestWrapper, error) {
-	jsonBytes, er := ioutil.ReadAll(r.Body)
+/* unwrapEncodedHttpRequest reads a request that isn't multipart. The whole body is
+ * the data part, so it's held to the part limit once decompressed. */
+func unwrapEncodedHttpRequest(body io.Reader, codec Codec, limits AttachmentLimits) (*RequestWrapper, error) {
+	limit := limits.withDefaults().MaxPartSize
+
+	dataBytes, er := ioutil.ReadAll(io.LimitReader(body, limit+1))
 	if er != nil {
 		return nil, er
 	}
 
-	wrapper := RequestWrapper{}
+	if int64(len(dataBytes)) > limit {
+		return nil, ErrorAttachmentTooLarge("data", limit)
+	}
 
-	if er := json.Unmarshal(jsonBytes, &wrapper); er != nil {
-		log.Printf("Unmarshal error\n%s\n", string(jsonBytes))
+	wrapper, er := decodeRequestWrapper(dataBytes, codec)
+	if er != nil {
 		return nil, er
 	}
 
 	wrapper.Attachments = make(map[string]multipart.File)
 
+	return wrapper, nil
+}
+
+func decodeRequestWrapper(dataBytes []byte, codec Codec) (*RequestWrapper, error) {
+	wrapper := RequestWrapper{}
+
+	if er := codec.Unmarshal(dataBytes, &wrapper); er != nil {
+		Logger(context.Background()).Debug("unable to decode request envelope", "error", er, "bytes", len(dataBytes))
+		return nil, er
+	}
+
+	wrapper.Codec = codec
+
 	return &wrapper, nil
 }
 
-func unwrapMultipartHttpRequest(r *http.Request) (*RequestWrapper, error) {
-	contentType := r.Header.Get("Content-Type")
+func unwrapMultipartHttpRequest(body io.Reader, contentType string, limits AttachmentLimits) (*RequestWrapper, error) {
 	_, params, er := mime.ParseMediaType(contentType)
 	if er != nil {
 		return nil, er
@@ -193,22 +506,27 @@ func unwrapMultipartHttpRequest(r *http.Request) (*RequestWrapper, error) {
 		return nil, fmt.Errorf("No boundary parameter in multipart request")
 	}
 
-	reader := multipart.NewReader(r.Body, boundary)
+	limits = limits.withDefaults()
+	remaining := limits.MaxTotalSize
+
+	reader := multipart.NewReader(body, boundary)
 
 	attachments := map[string]multipart.File{}
 	mimeTypes := map[string]string{}
 
 	/* We're returning open file handles here, so make sure to kill them 
 	 * all off if we happen to return an error prematurely. */
-	filesToDestroy := []*os.File{}
 	defer func() {
-		for _, f := range filesToDestroy {
-			f.Close()
-			os.Remove(f.Name())
+		for _, file := range attachments {
+			if osfile, ok := file.(*os.File); ok {
+				osfile.Close()
+				os.Remove(osfile.Name())
+			}
 		}
 	}()
 
-	var jsonBytes []byte = nil
+	var dataBytes []byte = nil
+	var dataCodec Codec = JSONCodec
 
 	for {
 		part, er := reader.NextPart()
@@ -232,61 +550,67 @@ func unwrapMultipartHttpRequest(r *http.Request) (*RequestWrapper, error) {
 			contentId = part.FileName()
 		}
 
-		if contentType == "" {
-			contentType = "application/octect-stream"
-		}
-
 		if contentId == "" {
 			/* XXX: Potentially emit an error here */
 			continue
 		}
 
-		/* Shim to pull out the data segment to avoid another file
-		 * allocation */
-		if contentId == "data" {
-			if jsonBytes, er = ioutil.ReadAll(part); er != nil {
-				return nil, er
-			}
-
-			continue
+		if _, ok := attachments[contentId]; ok || (contentId == "data" && dataBytes != nil) {
+			return nil, ErrorInvalidInputFormat("duplicate Content-ID " + contentId)
 		}
 
-		/* Dump the rest of the data into separate files rather than keeping
-		 * them in-memory. This is a bit slow, but ideally the server is configured
-		 * to use a swap-backed tmpfs. We'll be discarding these files in either case.
-		 */
-		tmpFile, er := ioutil.TempFile("", "macrobooru-")
+		partReader, er := DecompressReader(part.Header.Get("Content-Encoding"), part)
 		if er != nil {
 			return nil, er
 		}
-		filesToDestroy = append(filesToDestroy, tmpFile)
 
-		if _, er := io.Copy(tmpFile, part); er != nil {
+		spooled, er := spoolPart(partReader, contentId, limits, remaining)
+		partReader.Close()
+
+		if er != nil {
 			return nil, er
 		}
 
-		if _, er := tmpFile.Seek(0, os.SEEK_SET); er != nil {
-			return nil, er
+		remaining -= spooled.size
+
+		/* The data segment is always small enough to have stayed in memory, unless
+		 * somebody configured a tiny threshold */
+		if contentId == "data" {
+			dataBytes, er = ioutil.ReadAll(spooled.file)
+			spooled.file.Close()
+
+			if osfile, ok := spooled.file.(*os.File); ok {
+				os.Remove(osfile.Name())
+			}
+
+			if er != nil {
+				return nil, er
+			}
+
+			dataCodec = requestCodec(contentType)
+
+			continue
 		}
 
-		attachments[contentId] = tmpFile
-		mimeTypes[contentId] = contentType
+		attachments[contentId] = spooled.file
+
+		if mimeTypes[contentId], er = checkContentType(contentType, spooled.head); er != nil {
+			return nil, er
+		}
 	}
 
-	if jsonBytes == nil {
+	if dataBytes == nil {
 		return nil, fmt.Errorf("Missing 'data' section")
 	}
 
-	wrapper := RequestWrapper{}
-
-	if er := json.Unmarshal(jsonBytes, &wrapper); er != nil {
-		log.Printf("Unmarshal error\n%s\n", string(jsonBytes))
+	wrapper, er := decodeRequestWrapper(dataBytes, dataCodec)
+	if er != nil {
 		return nil, er
 	}
 
 	wrapper.Attachments = attachments
 	wrapper.MimeTypes = mimeTypes
 
-	filesToDestroy = nil
-	return &wrapper, nil
+	attachments = nil
+	return wrapper, nil
 }
@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"testing"
@@ -92,3 +93,74 @@ func TestParseMultipartWrapper(t *testing.T) {
 	}
 	defer wrapper.Close()
 }
+
+type memFile struct {
+	*bytes.Reader
+}
+
+func (*memFile) Close() error {
+	return nil
+}
+
+func TestWrapStreamingRoundTrip(t *testing.T) {
+	attachment := append([]byte("GIF89a"), bytes.Repeat([]byte("macrobooru"), 4096)...)
+
+	wrapper := &RequestWrapper{
+		Operation: "test_echo",
+		AuthToken: "asdf",
+		Data:      &echoPayload{Value: "hello"},
+		Attachments: map[string]multipart.File{
+			"static1": &memFile{bytes.NewReader(attachment)},
+		},
+		MimeTypes: map[string]string{
+			"static1": "image/gif",
+		},
+	}
+
+	var lastWritten, lastTotal int64
+
+	httpReq, er := WrapHttpRequestWithProgress("http://localhost/v2/api", wrapper, func(written, total int64) {
+		lastWritten, lastTotal = written, total
+	})
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	body, er := ioutil.ReadAll(httpReq.Body)
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	if httpReq.ContentLength != int64(len(body)) {
+		t.Fatalf("Content-Length is %d, body is %d bytes", httpReq.ContentLength, len(body))
+	}
+
+	if lastWritten != httpReq.ContentLength || lastTotal != httpReq.ContentLength {
+		t.Fatalf("progress reported %d/%d, Content-Length is %d", lastWritten, lastTotal, httpReq.ContentLength)
+	}
+
+	httpReq.Body = (*BufCloser)(bytes.NewBuffer(body))
+
+	unwrapped, er := UnwrapHttpRequest(httpReq)
+	if er != nil {
+		t.Fatal(er)
+	}
+	defer unwrapped.Close()
+
+	if payload, ok := unwrapped.Data.(*echoPayload); !ok || payload.Value != "hello" {
+		t.Fatalf("payload did not survive the round trip: %#v", unwrapped.Data)
+	}
+
+	received, er := ioutil.ReadAll(unwrapped.Attachments["static1"])
+	if er != nil {
+		t.Fatal(er)
+	}
+
+	if !bytes.Equal(received, attachment) {
+		t.Fatalf("attachment did not survive the round trip")
+	}
+
+	if unwrapped.MimeTypes["static1"] != "image/gif" {
+		t.Fatalf("mime type did not survive the round trip: %s", unwrapped.MimeTypes["static1"])
+	}
+}
@@ -1,10 +1,11 @@
 package models
 
 import (
-	"encoding/json"
 	"reflect"
 
 	"time"
+
+	"github.com/ugorji/go/codec"
 )
 
 type Comment struct {
@@ -47,6 +48,10 @@ func (modelMeta *commentMeta) RelationExists(rel string) bool {
 	return meta != nil
 }
 
+func (*commentMeta) Relations() []string {
+	return nil
+}
+
 func (*commentMeta) RelationFieldNames(rel string) (string, string, ModelMeta) {
 
 	return "", "", nil
@@ -66,30 +71,18 @@ type wireComment struct {
 	Contents    string `json:"contents"`
 }
 
-func (model *Comment) UnmarshalJSON(data []byte) (er error) {
-	var wire wireComment
-
-	if er = json.Unmarshal(data, &wire); er != nil {
-		return er
-	}
+func (model *Comment) UnmarshalJSON(data []byte) error {
+	return unmarshalWire(data, model)
+}
 
-	model.Pid = wire.Pid
-	model.Parent_id = wire.Parent_id
-	if model.DateCreated, er = parseTimestamp(wire.DateCreated); er != nil {
-		return er
-	}
-	model.Contents = wire.Contents
+func (model *Comment) MarshalJSON() ([]byte, error) {
+	return marshalWire(model)
+}
 
-	return nil
+func (model *Comment) CodecEncodeSelf(e *codec.Encoder) {
+	encodeWire(e, model)
 }
 
-func (model *Comment) MarshalJSON() ([]byte, error) {
-	wire := wireComment{
-		Pid:         model.Pid,
-		Parent_id:   model.Parent_id,
-		DateCreated: unparseTimestamp(model.DateCreated),
-		Contents:    model.Contents,
-	}
-
-	return json.Marshal(wire)
+func (model *Comment) CodecDecodeSelf(d *codec.Decoder) {
+	decodeWire(d, model)
 }
@@ -1,10 +1,11 @@
 package models
 
 import (
-	"encoding/json"
 	"reflect"
 
 	"time"
+
+	"github.com/ugorji/go/codec"
 )
 
 type Image struct {
@@ -48,6 +49,10 @@ func (modelMeta *imageMeta) RelationExists(rel string) bool {
 	return meta != nil
 }
 
+func (*imageMeta) Relations() []string {
+	return []string{"comments", "ratings"}
+}
+
 func (*imageMeta) RelationFieldNames(rel string) (string, string, ModelMeta) {
 	if rel == "comments" {
 		return "comments", "parent_id", CommentMeta
@@ -73,32 +78,18 @@ type wireImage struct {
 	RatingsAverage float32 `json:"ratingsAverage"`
 }
 
-func (model *Image) UnmarshalJSON(data []byte) (er error) {
-	var wire wireImage
-
-	if er = json.Unmarshal(data, &wire); er != nil {
-		return er
-	}
-
-	model.Pid = wire.Pid
-	model.Filehash = wire.Filehash
-	model.Mime = wire.Mime
-	if model.UploadedDate, er = parseTimestamp(wire.UploadedDate); er != nil {
-		return er
-	}
-	model.RatingsAverage = wire.RatingsAverage
-
-	return nil
+func (model *Image) UnmarshalJSON(data []byte) error {
+	return unmarshalWire(data, model)
 }
 
 func (model *Image) MarshalJSON() ([]byte, error) {
-	wire := wireImage{
-		Pid:            model.Pid,
-		Filehash:       model.Filehash,
-		Mime:           model.Mime,
-		UploadedDate:   unparseTimestamp(model.UploadedDate),
-		RatingsAverage: model.RatingsAverage,
-	}
+	return marshalWire(model)
+}
+
+func (model *Image) CodecEncodeSelf(e *codec.Encoder) {
+	encodeWire(e, model)
+}
 
-	return json.Marshal(wire)
+func (model *Image) CodecDecodeSelf(d *codec.Decoder) {
+	decodeWire(d, model)
 }
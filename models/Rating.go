@@ -1,8 +1,9 @@
 package models
 
 import (
-	"encoding/json"
 	"reflect"
+
+	"github.com/ugorji/go/codec"
 )
 
 type Rating struct {
@@ -45,6 +46,10 @@ func (modelMeta *ratingMeta) RelationExists(rel string) bool {
 	return meta != nil
 }
 
+func (*ratingMeta) Relations() []string {
+	return nil
+}
+
 func (*ratingMeta) RelationFieldNames(rel string) (string, string, ModelMeta) {
 
 	return "", "", nil
@@ -64,28 +69,18 @@ type wireRating struct {
 	RaterEmail string `json:"raterEmail"`
 }
 
-func (model *Rating) UnmarshalJSON(data []byte) (er error) {
-	var wire wireRating
-
-	if er = json.Unmarshal(data, &wire); er != nil {
-		return er
-	}
+func (model *Rating) UnmarshalJSON(data []byte) error {
+	return unmarshalWire(data, model)
+}
 
-	model.Pid = wire.Pid
-	model.Image_id = wire.Image_id
-	model.Rating = wire.Rating
-	model.RaterEmail = wire.RaterEmail
+func (model *Rating) MarshalJSON() ([]byte, error) {
+	return marshalWire(model)
+}
 
-	return nil
+func (model *Rating) CodecEncodeSelf(e *codec.Encoder) {
+	encodeWire(e, model)
 }
 
-func (model *Rating) MarshalJSON() ([]byte, error) {
-	wire := wireRating{
-		Pid:        model.Pid,
-		Image_id:   model.Image_id,
-		Rating:     model.Rating,
-		RaterEmail: model.RaterEmail,
-	}
-
-	return json.Marshal(wire)
+func (model *Rating) CodecDecodeSelf(d *codec.Decoder) {
+	decodeWire(d, model)
 }
@@ -5,6 +5,8 @@ import (
 	"reflect"
 
 	"time"
+
+	"github.com/ugorji/go/codec"
 )
 
 type PointOfInterest struct {
@@ -68,6 +70,10 @@ func (modelMeta *staticMeta) RelationExists(rel string) bool {
 	return meta != nil
 }
 
+func (*staticMeta) Relations() []string {
+	return nil
+}
+
 func (*staticMeta) RelationFieldNames(rel string) (string, string, ModelMeta) {
 
 	return "", "", nil
@@ -89,36 +95,18 @@ type wireStatic struct {
 	Thumb                PointOfInterest `json:"thumb" crud:"thumb"`
 }
 
-func (model *Static) UnmarshalJSON(data []byte) (er error) {
-	var wire wireStatic
-
-	if er = json.Unmarshal(data, &wire); er != nil {
-		return er
-	}
-
-	model.Pid = wire.Pid
-	if wire.LastUpdatedTimestamp != nil {
-		if model.LastUpdatedTimestamp, er = parseTimestamp(*wire.LastUpdatedTimestamp); er != nil {
-			return er
-		}
-	}
-	model.Mime = wire.Mime
-	model.Path = wire.Path
-	model.SHA1Hash = wire.SHA1Hash
-	model.Thumb = wire.Thumb
-
-	return nil
+func (model *Static) UnmarshalJSON(data []byte) error {
+	return unmarshalWire(data, model)
 }
 
 func (model *Static) MarshalJSON() ([]byte, error) {
-	wire := wireStatic{
-		Pid:                  model.Pid,
-		LastUpdatedTimestamp: unparseTimestampOptional(model.LastUpdatedTimestamp),
-		Mime:                 model.Mime,
-		Path:                 model.Path,
-		SHA1Hash:             model.SHA1Hash,
-		Thumb:                model.Thumb,
-	}
+	return marshalWire(model)
+}
+
+func (model *Static) CodecEncodeSelf(e *codec.Encoder) {
+	encodeWire(e, model)
+}
 
-	return json.Marshal(wire)
+func (model *Static) CodecDecodeSelf(d *codec.Decoder) {
+	decodeWire(d, model)
 }
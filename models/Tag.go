@@ -1,8 +1,9 @@
 package models
 
 import (
-	"encoding/json"
 	"reflect"
+
+	"github.com/ugorji/go/codec"
 )
 
 type Tag struct {
@@ -43,6 +44,10 @@ func (modelMeta *tagMeta) RelationExists(rel string) bool {
 	return meta != nil
 }
 
+func (*tagMeta) Relations() []string {
+	return nil
+}
+
 func (*tagMeta) RelationFieldNames(rel string) (string, string, ModelMeta) {
 
 	return "", "", nil
@@ -59,24 +64,18 @@ type wireTag struct {
 	Name string `json:"name"`
 }
 
-func (model *Tag) UnmarshalJSON(data []byte) (er error) {
-	var wire wireTag
-
-	if er = json.Unmarshal(data, &wire); er != nil {
-		return er
-	}
-
-	model.Pid = wire.Pid
-	model.Name = wire.Name
-
-	return nil
+func (model *Tag) UnmarshalJSON(data []byte) error {
+	return unmarshalWire(data, model)
 }
 
 func (model *Tag) MarshalJSON() ([]byte, error) {
-	wire := wireTag{
-		Pid:  model.Pid,
-		Name: model.Name,
-	}
+	return marshalWire(model)
+}
+
+func (model *Tag) CodecEncodeSelf(e *codec.Encoder) {
+	encodeWire(e, model)
+}
 
-	return json.Marshal(wire)
+func (model *Tag) CodecDecodeSelf(d *codec.Decoder) {
+	decodeWire(d, model)
 }
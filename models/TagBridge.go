@@ -1,8 +1,9 @@
 package models
 
 import (
-	"encoding/json"
 	"reflect"
+
+	"github.com/ugorji/go/codec"
 )
 
 type TagBridge struct {
@@ -44,6 +45,10 @@ func (modelMeta *tagBridgeMeta) RelationExists(rel string) bool {
 	return meta != nil
 }
 
+func (*tagBridgeMeta) Relations() []string {
+	return nil
+}
+
 func (*tagBridgeMeta) RelationFieldNames(rel string) (string, string, ModelMeta) {
 
 	return "", "", nil
@@ -62,26 +67,18 @@ type wireTagBridge struct {
 	Tag_id   GUID `json:"tag_id"`
 }
 
-func (model *TagBridge) UnmarshalJSON(data []byte) (er error) {
-	var wire wireTagBridge
-
-	if er = json.Unmarshal(data, &wire); er != nil {
-		return er
-	}
-
-	model.Pid = wire.Pid
-	model.Image_id = wire.Image_id
-	model.Tag_id = wire.Tag_id
-
-	return nil
+func (model *TagBridge) UnmarshalJSON(data []byte) error {
+	return unmarshalWire(data, model)
 }
 
 func (model *TagBridge) MarshalJSON() ([]byte, error) {
-	wire := wireTagBridge{
-		Pid:      model.Pid,
-		Image_id: model.Image_id,
-		Tag_id:   model.Tag_id,
-	}
+	return marshalWire(model)
+}
+
+func (model *TagBridge) CodecEncodeSelf(e *codec.Encoder) {
+	encodeWire(e, model)
+}
 
-	return json.Marshal(wire)
+func (model *TagBridge) CodecDecodeSelf(d *codec.Decoder) {
+	decodeWire(d, model)
 }
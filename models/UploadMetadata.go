@@ -1,8 +1,9 @@
 package models
 
 import (
-	"encoding/json"
 	"reflect"
+
+	"github.com/ugorji/go/codec"
 )
 
 type UploadMetadata struct {
@@ -45,6 +46,10 @@ func (modelMeta *uploadMetadataMeta) RelationExists(rel string) bool {
 	return meta != nil
 }
 
+func (*uploadMetadataMeta) Relations() []string {
+	return nil
+}
+
 func (*uploadMetadataMeta) RelationFieldNames(rel string) (string, string, ModelMeta) {
 
 	return "", "", nil
@@ -64,28 +69,18 @@ type wireUploadMetadata struct {
 	OriginalExtension string `json:"originalExtension"`
 }
 
-func (model *UploadMetadata) UnmarshalJSON(data []byte) (er error) {
-	var wire wireUploadMetadata
-
-	if er = json.Unmarshal(data, &wire); er != nil {
-		return er
-	}
+func (model *UploadMetadata) UnmarshalJSON(data []byte) error {
+	return unmarshalWire(data, model)
+}
 
-	model.Pid = wire.Pid
-	model.ImageGUID = wire.ImageGUID
-	model.UploadedBy = wire.UploadedBy
-	model.OriginalExtension = wire.OriginalExtension
+func (model *UploadMetadata) MarshalJSON() ([]byte, error) {
+	return marshalWire(model)
+}
 
-	return nil
+func (model *UploadMetadata) CodecEncodeSelf(e *codec.Encoder) {
+	encodeWire(e, model)
 }
 
-func (model *UploadMetadata) MarshalJSON() ([]byte, error) {
-	wire := wireUploadMetadata{
-		Pid:               model.Pid,
-		ImageGUID:         model.ImageGUID,
-		UploadedBy:        model.UploadedBy,
-		OriginalExtension: model.OriginalExtension,
-	}
-
-	return json.Marshal(wire)
+func (model *UploadMetadata) CodecDecodeSelf(d *codec.Decoder) {
+	decodeWire(d, model)
 }
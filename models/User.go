@@ -1,10 +1,11 @@
 package models
 
 import (
-	"encoding/json"
 	"reflect"
 
 	"time"
+
+	"github.com/ugorji/go/codec"
 )
 
 type User struct {
@@ -56,6 +57,10 @@ func (modelMeta *userMeta) RelationExists(rel string) bool {
 	return meta != nil
 }
 
+func (*userMeta) Relations() []string {
+	return nil
+}
+
 func (*userMeta) RelationFieldNames(rel string) (string, string, ModelMeta) {
 	return "", "", nil
 }
@@ -83,50 +88,18 @@ type wireUser struct {
 	FacebookID           *string `json:"facebookID"`
 }
 
-func (model *User) UnmarshalJSON(data []byte) (er error) {
-	var wire wireUser
-
-	if er = json.Unmarshal(data, &wire); er != nil {
-		return er
-	}
-
-	if wire.LastUpdatedTimestamp != nil {
-		if model.LastUpdatedTimestamp, er = parseTimestamp(*wire.LastUpdatedTimestamp); er != nil {
-			return er
-		}
-	}
-	model.Pid = wire.Pid
-	model.Email = wire.Email
-	model.Username = wire.Username
-	model.DisplayName = wire.DisplayName
-	model.Location = wire.Location
-	model.Image = wire.Image
-	model.Passhash = wire.Passhash
-	model.IsSuspended = wire.IsSuspended
-	model.IsAdmin = wire.IsAdmin
-	model.TwitterID = wire.TwitterID
-	model.GoogleID = wire.GoogleID
-	model.FacebookID = wire.FacebookID
-
-	return nil
+func (model *User) UnmarshalJSON(data []byte) error {
+	return unmarshalWire(data, model)
 }
 
 func (model *User) MarshalJSON() ([]byte, error) {
-	wire := wireUser{
-		LastUpdatedTimestamp: unparseTimestampOptional(model.LastUpdatedTimestamp),
-		Pid:                  model.Pid,
-		Email:                model.Email,
-		Username:             model.Username,
-		DisplayName:          model.DisplayName,
-		Location:             model.Location,
-		Image:                model.Image,
-		Passhash:             model.Passhash,
-		IsSuspended:          model.IsSuspended,
-		IsAdmin:              model.IsAdmin,
-		TwitterID:            model.TwitterID,
-		GoogleID:             model.GoogleID,
-		FacebookID:           model.FacebookID,
-	}
-
-	return json.Marshal(wire)
+	return marshalWire(model)
+}
+
+func (model *User) CodecEncodeSelf(e *codec.Encoder) {
+	encodeWire(e, model)
+}
+
+func (model *User) CodecDecodeSelf(d *codec.Decoder) {
+	decodeWire(d, model)
 }
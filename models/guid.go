@@ -9,6 +9,8 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	"github.com/ugorji/go/codec"
 )
 
 type GUID struct {
@@ -18,6 +20,7 @@ type GUID struct {
 var _ sql.Scanner = &GUID{}
 var _ json.Marshaler = &GUID{}
 var _ json.Unmarshaler = &GUID{}
+var _ codec.Selfer = &GUID{}
 
 const (
 	invalidGUIDString = "00000000FFFFFFFF00000000FFFFFFFF"
@@ -152,3 +155,42 @@ func (g *GUID) UnmarshalJSON(bs []byte) error {
 
 	return nil
 }
+
+/* The binary encodings send the 16 raw bytes rather than the hex string, and the
+ * invalid GUID as nil. Hex strings are still accepted when decoding. */
+func (g *GUID) CodecEncodeSelf(e *codec.Encoder) {
+	if g.bytes == nil {
+		e.MustEncode(nil)
+		return
+	}
+
+	e.MustEncode(g.bytes)
+}
+
+func (g *GUID) CodecDecodeSelf(d *codec.Decoder) {
+	var val interface{}
+	d.MustDecode(&val)
+
+	switch val := val.(type) {
+	case nil:
+		*g = GUID{}
+
+	case []byte:
+		if len(val) != 16 {
+			panic(fmt.Errorf("GUID must be 16 bytes, not %d", len(val)))
+		}
+
+		*g = GUID{append([]byte(nil), val...)}
+
+	case string:
+		tmp, er := GUIDFromString(val)
+		if er != nil {
+			panic(er)
+		}
+
+		*g = tmp
+
+	default:
+		panic(fmt.Errorf("Unable to coerce %T %#v to GUID", val, val))
+	}
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"reflect"
+	"sort"
 	"strings"
 )
 
@@ -10,6 +11,7 @@ type ModelMeta interface {
 	TableName() string
 	PrimaryKey() string
 	RelationExists(string) bool
+	Relations() []string
 	GUID() GUID
 
 	Type() reflect.Type
@@ -27,6 +29,11 @@ var (
 	UploadMetadataMeta = &uploadMetadataMeta{}
 	RatingMeta         = &ratingMeta{}
 	StaticMeta         = &staticMeta{}
+
+	// UserMeta is for the handler and user stores. It's deliberately left out of
+	// modelNameMap: nothing checks who may read or write users, so clients can't
+	// name the model in any operation.
+	UserMeta = &userMeta{}
 )
 
 var modelNameMap = map[string]ModelMeta{
@@ -47,6 +54,22 @@ func ModelByName(name string) ModelMeta {
 	return nil
 }
 
+/* Models returns every model, ordered by name. */
+func Models() []ModelMeta {
+	names := make([]string, 0, len(modelNameMap))
+	for name := range modelNameMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]ModelMeta, 0, len(names))
+	for _, name := range names {
+		result = append(result, modelNameMap[name])
+	}
+
+	return result
+}
+
 func JsonFieldInfo(model ModelMeta, fieldName string) (string, reflect.Type, bool) {
 	modelType := model.Type()
 
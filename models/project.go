@@ -0,0 +1,47 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+)
+
+/* WireForm is object (a model struct, or a pointer to one) in the form it's sent
+ * to clients, or nil if it isn't a model clients can name. Everything returning
+ * objects goes through here, so a model kept out of ModelByName is never sent. */
+func WireForm(object interface{}) interface{} {
+	val := reflect.ValueOf(object)
+	if !val.IsValid() {
+		return nil
+	}
+
+	model := ModelByName(reflect.Indirect(val).Type().Name())
+	if model == nil || model.Type() != reflect.Indirect(val).Type() {
+		return nil
+	}
+
+	return toWire(object)
+}
+
+/* ProjectFields returns the wire form of object (a model struct, or a pointer to
+ * one) with only the fields named, by their JSON names. It encodes just as the
+ * whole object would, less the other fields. */
+func ProjectFields(object interface{}, fields []string) map[string]interface{} {
+	projected := map[string]interface{}{}
+
+	wire := reflect.ValueOf(WireForm(object))
+	if !wire.IsValid() {
+		return projected
+	}
+
+	for i := 0; i < wire.NumField(); i += 1 {
+		name := strings.Split(wire.Type().Field(i).Tag.Get("json"), ",")[0]
+
+		for _, field := range fields {
+			if field == name && name != "-" {
+				projected[name] = wire.Field(i).Interface()
+			}
+		}
+	}
+
+	return projected
+}
@@ -7,6 +7,11 @@ import (
 
 /* XXX: This should move elsewhere */
 func parseTimestamp(str string) (time.Time, error) {
+	/* Fields left out of a projection arrive empty */
+	if str == "" {
+		return time.Time{}, nil
+	}
+
 	unix, er := strconv.ParseInt(str, 10, 64)
 	if er != nil {
 		return time.Time{}, er
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/ugorji/go/codec"
+)
+
+/* wireTypes maps each model onto the struct it's sent over the wire as. The two
+ * have the same fields by name, except that timestamps are sent as strings of UNIX
+ * time (or pointers to them, if the timestamp is optional). */
+var wireTypes = map[reflect.Type]reflect.Type{
+	reflect.TypeOf(Comment{}):        reflect.TypeOf(wireComment{}),
+	reflect.TypeOf(Image{}):          reflect.TypeOf(wireImage{}),
+	reflect.TypeOf(Rating{}):         reflect.TypeOf(wireRating{}),
+	reflect.TypeOf(Static{}):         reflect.TypeOf(wireStatic{}),
+	reflect.TypeOf(Tag{}):            reflect.TypeOf(wireTag{}),
+	reflect.TypeOf(TagBridge{}):      reflect.TypeOf(wireTagBridge{}),
+	reflect.TypeOf(UploadMetadata{}): reflect.TypeOf(wireUploadMetadata{}),
+	reflect.TypeOf(User{}):           reflect.TypeOf(wireUser{}),
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+/* toWire is the wire form of model (a model struct, or a pointer to one), or nil
+ * if it isn't a model. */
+func toWire(model interface{}) interface{} {
+	val := reflect.Indirect(reflect.ValueOf(model))
+	if !val.IsValid() {
+		return nil
+	}
+
+	wireType, ok := wireTypes[val.Type()]
+	if !ok {
+		return nil
+	}
+
+	wire := reflect.New(wireType).Elem()
+
+	for i := 0; i < wireType.NumField(); i += 1 {
+		field := wireType.Field(i)
+		if field.Tag.Get("json") == "-" {
+			continue
+		}
+
+		from, to := val.FieldByName(field.Name), wire.Field(i)
+
+		if from.Type() != timeType {
+			to.Set(from)
+
+		} else if to.Kind() == reflect.Ptr {
+			to.Set(reflect.ValueOf(unparseTimestampOptional(from.Interface().(time.Time))))
+
+		} else {
+			to.SetString(unparseTimestamp(from.Interface().(time.Time)))
+		}
+	}
+
+	return wire.Interface()
+}
+
+/* fromWire sets the fields of model (a pointer to a model struct) from wire, a
+ * pointer to its wire form. Optional timestamps left out are left alone. */
+func fromWire(wire interface{}, model interface{}) error {
+	src := reflect.ValueOf(wire).Elem()
+	dst := reflect.ValueOf(model).Elem()
+
+	for i := 0; i < src.NumField(); i += 1 {
+		field := src.Type().Field(i)
+		if field.Tag.Get("json") == "-" {
+			continue
+		}
+
+		from, to := src.Field(i), dst.FieldByName(field.Name)
+
+		if to.Type() != timeType {
+			to.Set(from)
+			continue
+		}
+
+		if from.Kind() == reflect.Ptr {
+			if from.IsNil() {
+				continue
+			}
+
+			from = from.Elem()
+		}
+
+		timestamp, er := parseTimestamp(from.String())
+		if er != nil {
+			return er
+		}
+
+		to.Set(reflect.ValueOf(timestamp))
+	}
+
+	return nil
+}
+
+func newWire(model interface{}) interface{} {
+	return reflect.New(wireTypes[reflect.TypeOf(model).Elem()]).Interface()
+}
+
+/* The models' encoding methods are these, so that every encoding sends the same
+ * wire form. */
+
+func marshalWire(model interface{}) ([]byte, error) {
+	return json.Marshal(toWire(model))
+}
+
+func unmarshalWire(data []byte, model interface{}) error {
+	wire := newWire(model)
+
+	if er := json.Unmarshal(data, wire); er != nil {
+		return er
+	}
+
+	return fromWire(wire, model)
+}
+
+func encodeWire(e *codec.Encoder, model interface{}) {
+	e.MustEncode(toWire(model))
+}
+
+func decodeWire(d *codec.Decoder, model interface{}) {
+	wire := newWire(model)
+	d.MustDecode(wire)
+
+	if er := fromWire(wire, model); er != nil {
+		panic(er)
+	}
+}